@@ -16,6 +16,8 @@ import (
 var (
 	initMessage  string
 	taskFileFlag string
+	scriptFlag   string
+	paneFlag     string
 )
 
 var rootCmd = &cobra.Command{
@@ -51,11 +53,26 @@ var rootCmd = &cobra.Command{
 			logger.Info("Read request from file: %s", taskFileFlag)
 		}
 
-		mgr, err := internal.NewManager(cfg)
+		mgr, err := internal.NewManager(cfg, paneFlag)
 		if err != nil {
 			logger.Error("manager.NewManager failed: %v", err)
 			os.Exit(1)
 		}
+		if err := mgr.StartRemoteServer(); err != nil {
+			logger.Error("Remote control API failed to start: %v", err)
+			fmt.Fprintf(os.Stderr, "Remote control API failed to start: %v\n", err)
+			os.Exit(1)
+		}
+
+		if scriptFlag != "" {
+			if err := mgr.RunScript(scriptFlag); err != nil {
+				logger.Error("Script failed: %v", err)
+				fmt.Fprintf(os.Stderr, "Script failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		if initMessage != "" {
 			logger.Info("Starting with initial subcommand: %s", initMessage)
 		}
@@ -69,6 +86,8 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.Flags().StringVarP(&taskFileFlag, "file", "f", "", "Read request from specified file")
+	rootCmd.Flags().StringVar(&scriptFlag, "script", "", "Run each line of the specified file as a prompt or subcommand, then exit")
+	rootCmd.Flags().StringVar(&paneFlag, "pane", "", "Use this pane id (%3) or target (session:window.pane) as the exec pane")
 	rootCmd.Flags().BoolP("version", "v", false, "Print version information")
 }
 
@@ -5,44 +5,154 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 	"github.com/spf13/viper"
 )
 
+// DefaultBlockedCommandPatterns match commands that are never run, even when confirmed
+var DefaultBlockedCommandPatterns = []string{
+	// rm -rf /, ~ or $HOME
+	`\brm\s+(-\S+\s+)*(/|/\*|~/?|\$HOME/?)(\s|;|&|\||$)`,
+	// formatting a filesystem
+	`\bmkfs(\.\w+)?\b`,
+	// dd or a redirect onto a disk device
+	`\bdd\b.*\bof=/dev/`,
+	`>\s*/dev/(sd[a-z]|hd[a-z]|vd[a-z]|nvme\d|disk\d)`,
+	// fork bomb
+	`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`,
+}
+
 // Config holds the application configuration
 type Config struct {
 	Debug                 bool              `mapstructure:"debug"`
+	RedactSecrets         bool              `mapstructure:"redact_secrets"`
 	MaxCaptureLines       int               `mapstructure:"max_capture_lines"`
+	MaxCommandOutputBytes int               `mapstructure:"max_command_output_bytes"`
+	TruncationNotice      string            `mapstructure:"truncation_notice"`
 	MaxContextSize        int               `mapstructure:"max_context_size"`
+	Tokenizer             string            `mapstructure:"tokenizer"`
+	SummaryModel          string            `mapstructure:"summary_model"`
+	ActionSyntax          string            `mapstructure:"action_syntax"`
+	ModelContextWindows   map[string]int    `mapstructure:"model_context_windows"`
 	WaitInterval          int               `mapstructure:"wait_interval"`
+	WatchIdlePauseSec     int               `mapstructure:"watch_idle_pause_sec"`
+	TurnDeadlineSec       int               `mapstructure:"turn_deadline_sec"`
 	SendKeysConfirm       bool              `mapstructure:"send_keys_confirm"`
 	PasteMultilineConfirm bool              `mapstructure:"paste_multiline_confirm"`
 	ExecConfirm           bool              `mapstructure:"exec_confirm"`
+	AllowPlainAnswers     bool              `mapstructure:"allow_plain_answers"`
+	Stream                bool              `mapstructure:"stream"`
+	RequireVerification   bool              `mapstructure:"require_verification"`
+	RefusalMarkers        []string          `mapstructure:"refusal_markers"`
+	EmptyResponseRetries  int               `mapstructure:"empty_response_retries"`
+	DisabledActions       []string          `mapstructure:"disabled_actions"`
 	WhitelistPatterns     []string          `mapstructure:"whitelist_patterns"`
 	BlacklistPatterns     []string          `mapstructure:"blacklist_patterns"`
+	BlockedCmdPatterns    []string          `mapstructure:"blocked_command_patterns"`
+	AuditApprovedCommands bool              `mapstructure:"audit_approved_commands"`
 	OpenRouter            OpenRouterConfig  `mapstructure:"openrouter"`
 	AzureOpenAI           AzureOpenAIConfig `mapstructure:"azure_openai"`
+	Anthropic             AnthropicConfig   `mapstructure:"anthropic"`
+	Ollama                OllamaConfig      `mapstructure:"ollama"`
+	HTTP                  HTTPConfig        `mapstructure:"http"`
 	Prompts               PromptsConfig     `mapstructure:"prompts"`
 	Personas              map[string]*Persona `mapstructure:"personas"`
 	PersonaRules          []PersonaRule     `mapstructure:"persona_rules"`
 	DefaultPersona        string            `mapstructure:"default_persona"`
+	OutputFilters         []OutputFilter    `mapstructure:"output_filters"`
+	SummarizeToolOutput   bool              `mapstructure:"summarize_tool_output"`
+	HistoryPerProject     bool              `mapstructure:"history_per_project"`
+	WelcomeMessage        string            `mapstructure:"welcome_message"`
+	PromptFormat          string            `mapstructure:"prompt_format"`
+	CommandLogFile        string            `mapstructure:"command_log_file"`
+	WebhookURL            string            `mapstructure:"webhook_url"`
+	ListenAddr            string            `mapstructure:"listen_addr"`
+	RemoteToken           string            `mapstructure:"remote_token"`
+	StripPaneContext      bool              `mapstructure:"strip_pane_context"`
+	ContinueKeywords      []string          `mapstructure:"continue_keywords"`
+	ConfirmOncePerType    bool              `mapstructure:"confirm_once_per_type"`
+	ScriptStopOnFailure   bool              `mapstructure:"script_stop_on_failure"`
+	ConfirmSingleKey      bool              `mapstructure:"confirm_single_key"`
+	ConfirmKeys           map[string]string `mapstructure:"confirm_keys"`
+	CompletionMarker      bool              `mapstructure:"completion_marker"`
+	ExecPromptRegex       string            `mapstructure:"exec_prompt_regex"`
+	IsolateCommands       bool              `mapstructure:"isolate_commands"`
+	PortabilityHints      bool              `mapstructure:"portability_hints"`
+	ParseRetrySettleMs    int               `mapstructure:"parse_retry_settle_ms"`
+	StreamCmdOutput       bool              `mapstructure:"stream_command_output"`
+	StreamCmdOutputSec    int               `mapstructure:"stream_command_output_sec"`
+	PreparedFollowUp      bool              `mapstructure:"prepared_follow_up"`
+	FeedbackOnError       bool              `mapstructure:"feedback_on_error"`
+	PrepareClearScreen    bool              `mapstructure:"prepare_clear_screen"`
+	PrepareResetHistory   bool              `mapstructure:"prepare_reset_history"`
+	ProjectContextFiles   []string          `mapstructure:"project_context_files"`
+	ProjectContextMaxSize int               `mapstructure:"project_context_max_size"`
+	StatusLine            bool              `mapstructure:"status_line"`
+	SpinnerStyle          string            `mapstructure:"spinner_style"`
+	SpinnerIntervalMs     int               `mapstructure:"spinner_interval_ms"`
+	MaxSessionCostUSD     float64           `mapstructure:"max_session_cost_usd"`
+
+	// per-million-token prices by model, "default" applies to models not listed
+	Pricing map[string]ModelPricing `mapstructure:"pricing"`
 }
 
 // OpenRouterConfig holds OpenRouter API configuration
 type OpenRouterConfig struct {
-	APIKey  string `mapstructure:"api_key"`
-	Model   string `mapstructure:"model"`
-	BaseURL string `mapstructure:"base_url"`
+	APIKey   string            `mapstructure:"api_key"`
+	Model    string            `mapstructure:"model"`
+	BaseURL  string            `mapstructure:"base_url"`
+	ChatPath string            `mapstructure:"chat_path"`
+	Headers  map[string]string `mapstructure:"headers"`
 }
 
 // AzureOpenAIConfig holds Azure OpenAI API configuration
 type AzureOpenAIConfig struct {
-	APIKey         string `mapstructure:"api_key"`
-	APIBase        string `mapstructure:"api_base"`
-	APIVersion     string `mapstructure:"api_version"`
-	DeploymentName string `mapstructure:"deployment_name"`
+	APIKey         string            `mapstructure:"api_key"`
+	APIBase        string            `mapstructure:"api_base"`
+	APIVersion     string            `mapstructure:"api_version"`
+	DeploymentName string            `mapstructure:"deployment_name"`
+	ChatPath       string            `mapstructure:"chat_path"`
+	Headers        map[string]string `mapstructure:"headers"`
+}
+
+// AnthropicConfig holds Anthropic API configuration
+type AnthropicConfig struct {
+	APIKey    string            `mapstructure:"api_key"`
+	BaseURL   string            `mapstructure:"base_url"`
+	Model     string            `mapstructure:"model"`
+	MaxTokens int               `mapstructure:"max_tokens"`
+	Headers   map[string]string `mapstructure:"headers"`
+}
+
+// OllamaConfig holds the configuration of a local Ollama server, which needs no API key
+type OllamaConfig struct {
+	BaseURL string `mapstructure:"base_url"`
+	Model   string `mapstructure:"model"`
+}
+
+// OutputFilter pipes the output of exec pane commands matching Pattern (regex) through Command
+type OutputFilter struct {
+	Pattern string `mapstructure:"pattern"`
+	Command string `mapstructure:"command"`
+}
+
+// HTTPConfig tunes the connection pool of the AI client
+type HTTPConfig struct {
+	MaxIdleConns       int `mapstructure:"max_idle_conns"`
+	IdleConnTimeoutSec int `mapstructure:"idle_conn_timeout_sec"`
+	KeepAliveSec       int `mapstructure:"keep_alive_sec"`
+	TimeoutSec         int `mapstructure:"timeout_sec"`
+	MaxRetries         int `mapstructure:"max_retries"`
+	RetryBaseDelayMs   int `mapstructure:"retry_base_delay_ms"`
+}
+
+// ModelPricing holds per-million-token prices in USD for a model
+type ModelPricing struct {
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
 }
 
 // PromptsConfig holds customizable prompt templates
@@ -101,19 +211,44 @@ DO NOT WRITE MORE TEXT AFTER THE TOOL CALLS IN A RESPONSE. You can wait until th
 
 	return &Config{
 		Debug:                 false,
+		RedactSecrets:         true,
 		MaxCaptureLines:       200,
+		TruncationNotice:      "[output truncated, {details}. Use a more specific command, e.g. with grep, head or tail, to see the rest]",
 		MaxContextSize:        100000,
+		Tokenizer:             "auto",
+		ActionSyntax:          "xml",
 		WaitInterval:          5,
 		SendKeysConfirm:       true,
 		PasteMultilineConfirm: true,
 		ExecConfirm:           true,
+		PrepareClearScreen:    true,
+		ParseRetrySettleMs:    2000,
+		EmptyResponseRetries:  1,
+		StreamCmdOutputSec:    15,
+		SpinnerStyle:          "dots",
+		WelcomeMessage:        "Type '/help' for a list of commands, '/exit' to quit",
+		ProjectContextFiles:   []string{"AGENT.md", ".tmuxai.md"},
+		ProjectContextMaxSize: 8000,
+		SpinnerIntervalMs:     100,
 		WhitelistPatterns:     []string{},
 		BlacklistPatterns:     []string{},
+		BlockedCmdPatterns:    slices.Clone(DefaultBlockedCommandPatterns),
+		ContinueKeywords:      []string{"continue", "go on", "go", "proceed"},
+		RefusalMarkers:        []string{"I can't help with", "I cannot help with", "I can't assist with", "I cannot assist with", "I won't be able to help"},
+		PreparedFollowUp:      true,
+		PortabilityHints:      true,
 		OpenRouter: OpenRouterConfig{
 			BaseURL: "https://openrouter.ai/api/v1",
 			Model:   "google/gemini-2.5-flash-preview",
 		},
 		AzureOpenAI: AzureOpenAIConfig{},
+		HTTP: HTTPConfig{
+			MaxIdleConns:       10,
+			IdleConnTimeoutSec: 90,
+			KeepAliveSec:       30,
+			MaxRetries:         3,
+			RetryBaseDelayMs:   1000,
+		},
 		Prompts: PromptsConfig{
 			BaseSystem:    ``,
 			ChatAssistant: ``,
@@ -121,6 +256,7 @@ DO NOT WRITE MORE TEXT AFTER THE TOOL CALLS IN A RESPONSE. You can wait until th
 		Personas:       defaultPersonas,
 		DefaultPersona: "pair_programmer",
 		PersonaRules:   []PersonaRule{},
+		Pricing:        map[string]ModelPricing{},
 	}
 }
 
@@ -345,6 +481,12 @@ func TryInferType(key, value string) any {
 				if err == nil {
 					typedValue = intVal
 				}
+			case reflect.Float64, reflect.Float32:
+				var floatVal float64
+				_, err := fmt.Sscanf(value, "%g", &floatVal)
+				if err == nil {
+					typedValue = floatVal
+				}
 			}
 		}
 		// Nested struct support
@@ -374,6 +516,12 @@ func TryInferType(key, value string) any {
 							if err == nil {
 								typedValue = intVal
 							}
+						case reflect.Float64, reflect.Float32:
+							var floatVal float64
+							_, err := fmt.Sscanf(value, "%g", &floatVal)
+							if err == nil {
+								typedValue = floatVal
+							}
 						}
 					}
 				}
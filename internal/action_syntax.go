@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// action_syntax settings: the XML tags, fenced code blocks, or both
+const (
+	actionSyntaxXML   = "xml"
+	actionSyntaxFence = "fence"
+	actionSyntaxBoth  = "both"
+)
+
+// actionFenceRegex matches ```exec, ```sendkeys and ```paste blocks, group 1 is the kind, group 2 the content
+var actionFenceRegex = regexp.MustCompile("(?s)```(exec|sendkeys|paste)[ \t]*\r?\n(.*?)\r?\n?```")
+
+// actionFencePrompt documents the fence convention for models that handle code blocks better than XML tags
+const actionFencePrompt = "- ```exec followed by a single shell command and a closing ``` works like <ExecCommand>.\n" +
+	"- ```sendkeys followed by one key sequence per line and a closing ``` works like one <TmuxSendKeys> per line.\n" +
+	"- ```paste followed by the content and a closing ``` works like <PasteMultilineContent>.\n" +
+	"Keep using the boolean tags, e.g. <RequestAccomplished>1</RequestAccomplished>, to report the request's status.\n" +
+	"Example:\nI'll list the files for you.\n```exec\nls -l\n```\n"
+
+// actionSyntaxPrompt returns the system prompt section adding the fence convention to the XML tags in both mode,
+// fence mode documents it in place of the tags with fenceActionsPrompt
+func (m *Manager) actionSyntaxPrompt() string {
+	if m.actionSyntax() == actionSyntaxBoth {
+		return "\nYou can also write actions as fenced code blocks instead of the XML tags:\n" + actionFencePrompt
+	}
+	return ""
+}
+
+// fenceActionsPrompt documents the fenced action blocks, rules and examples of the chat assistant prompt in fence mode
+func fenceActionsPrompt(prepared bool) string {
+	var builder strings.Builder
+	builder.WriteString("\nYour primary function is to assist users by interpreting their requests and executing appropriate actions.\n" +
+		"You control the tmux pane with fenced code blocks:\n\n" +
+		"```exec: Use this to execute a shell command in the tmux pane, one command per block.\n" +
+		"```sendkeys: Use this to send keystrokes to the tmux pane, one key sequence per line. Supported keys include standard characters, function keys (F1-F12), navigation keys (Up,Down,Left,Right,BSpace,BTab,DC,End,Enter,Escape,Home,IC,NPage,PageDown,PgDn,PPage,PageUp,PgUp,Space,Tab), and modifier keys (C-, M-).\n" +
+		"```paste: Use this to send multiline content into the tmux pane, e.g. text typed into vim. It's forbidden to use this to execute commands in a shell, use ```exec for that.\n" +
+		"Report the request's status with these boolean XML tags:\n" +
+		"<WaitingForUserResponse>: Use this boolean tag (value 1) when you have a question, need input or clarification from the user to accomplish the request.\n" +
+		"<RequestAccomplished>: Use this boolean tag (value 1) when you have successfully completed and verified the user's request.\n")
+	if !prepared {
+		builder.WriteString("<ExecPaneSeemsBusy>: Use this boolean tag (value 1) when you need to wait for the exec pane to finish before proceeding.\n")
+	}
+
+	builder.WriteString("\nWhen responding to user messages:\n" +
+		"1. Analyze the user's request carefully.\n" +
+		"2. Analyze the user's current tmux pane(s) content and detect what is running there, whether the pane is busy, and whether you should wait or proceed.\n" +
+		"3. Respond with a brief explanation in normal text, followed by the action block or status tag at the end of your response.\n\n" +
+		"When generating your response you will be PUNISHED if you don't follow these rules:\n" +
+		"- Keep each ```exec command under 60 characters where possible, split longer tasks and run only the first step in this response.\n" +
+		"- Use only ONE KIND of action block in your response.\n" +
+		"- Always include at least one action block or status tag in your response.\n\n" +
+		"<examples_of_responses>\n" +
+		"<executing_a_command_example>\n" +
+		"I'll list the contents of the current directory.\n" +
+		"```exec\nls -l\n```\n" +
+		"</executing_a_command_example>\n\n" +
+		"<sending_keystrokes_example>\n" +
+		"I'll delete line 10 in file 'example.txt' in vim for you.\n" +
+		"```sendkeys\nvim example.txt\nEnter\n10G\ndd\n```\n" +
+		"</sending_keystrokes_example>\n\n" +
+		"<waiting_for_user_input_example>\n" +
+		"Do you want me to save the changes to the file?\n" +
+		"<WaitingForUserResponse>1</WaitingForUserResponse>\n" +
+		"</waiting_for_user_input_example>\n\n" +
+		"<completing_a_request_example>\n" +
+		"I've successfully created the new directory as requested.\n" +
+		"<RequestAccomplished>1</RequestAccomplished>\n" +
+		"</completing_a_request_example>\n" +
+		"</examples_of_responses>\n")
+	return builder.String()
+}
+
+// actionSyntax returns the action_syntax setting, xml when it's unset or unknown
+func (m *Manager) actionSyntax() string {
+	if m.Config == nil {
+		return actionSyntaxXML
+	}
+	switch syntax := strings.ToLower(strings.TrimSpace(m.Config.ActionSyntax)); syntax {
+	case actionSyntaxFence, actionSyntaxBoth:
+		return syntax
+	}
+	return actionSyntaxXML
+}
+
+// actionOffsets are the positions in the response of the parsed ExecCommand and SendKeys entries,
+// used to keep fenced and XML actions in the order they were written
+type actionOffsets struct {
+	exec []int
+	keys []int
+}
+
+// parseActionFences fills r from the fenced action blocks in response and returns response without them
+func parseActionFences(r *AIResponse, offsets *actionOffsets, response string) string {
+	for _, loc := range actionFenceRegex.FindAllStringSubmatchIndex(response, -1) {
+		content := strings.TrimSpace(response[loc[4]:loc[5]])
+		if content == "" {
+			continue
+		}
+		switch response[loc[2]:loc[3]] {
+		case "exec":
+			r.ExecCommand = append(r.ExecCommand, content)
+			r.ExecCommandAttrs = append(r.ExecCommandAttrs, ExecCommandAttrs{})
+			offsets.exec = append(offsets.exec, loc[0])
+		case "sendkeys":
+			for _, line := range strings.Split(content, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					r.SendKeys = append(r.SendKeys, line)
+					offsets.keys = append(offsets.keys, loc[0])
+				}
+			}
+		case "paste":
+			r.PasteMultilineContent = content
+		}
+	}
+	return actionFenceRegex.ReplaceAllString(response, "")
+}
+
+// mergeActions adds the fenced actions to the XML ones in r, ordered by their position in the response
+func mergeActions(r *AIResponse, xmlOffsets actionOffsets, fenced AIResponse, fenceOffsets actionOffsets) {
+	type execAction struct {
+		offset  int
+		command string
+		attrs   ExecCommandAttrs
+	}
+	var execs []execAction
+	for i, command := range r.ExecCommand {
+		execs = append(execs, execAction{xmlOffsets.exec[i], command, r.ExecCommandAttrs[i]})
+	}
+	for i, command := range fenced.ExecCommand {
+		execs = append(execs, execAction{fenceOffsets.exec[i], command, fenced.ExecCommandAttrs[i]})
+	}
+	slices.SortStableFunc(execs, func(a, b execAction) int { return a.offset - b.offset })
+	r.ExecCommand, r.ExecCommandAttrs = nil, nil
+	for _, exec := range execs {
+		r.ExecCommand = append(r.ExecCommand, exec.command)
+		r.ExecCommandAttrs = append(r.ExecCommandAttrs, exec.attrs)
+	}
+
+	type keysAction struct {
+		offset int
+		keys   string
+	}
+	var keys []keysAction
+	for i, k := range r.SendKeys {
+		keys = append(keys, keysAction{xmlOffsets.keys[i], k})
+	}
+	for i, k := range fenced.SendKeys {
+		keys = append(keys, keysAction{fenceOffsets.keys[i], k})
+	}
+	slices.SortStableFunc(keys, func(a, b keysAction) int { return a.offset - b.offset })
+	r.SendKeys = nil
+	for _, k := range keys {
+		r.SendKeys = append(r.SendKeys, k.keys)
+	}
+
+	if fenced.PasteMultilineContent != "" {
+		r.PasteMultilineContent = fenced.PasteMultilineContent
+	}
+}
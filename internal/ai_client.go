@@ -1,11 +1,13 @@
 package internal
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -15,10 +17,28 @@ import (
 	"github.com/alvinunreal/tmuxai/logger"
 )
 
-// AiClient represents an AI client for interacting with OpenAI-compatible APIs including Azure OpenAI
+// AI providers, see AiClient.provider for how one is picked
+const (
+	providerAzureOpenAI = "azure_openai"
+	providerAnthropic   = "anthropic"
+	providerOllama      = "ollama"
+	providerOpenRouter  = "openrouter"
+)
+
+const (
+	// defaultChatPath is appended to the OpenRouter/OpenAI compatible base_url
+	defaultChatPath = "/chat/completions"
+	// defaultAzureChatPath is appended to the Azure api_base, with placeholders filled from config
+	defaultAzureChatPath = "/openai/deployments/{deployment}/chat/completions?api-version={api_version}"
+)
+
+// AiClient represents an AI client for interacting with OpenAI-compatible APIs including Azure OpenAI, the Anthropic API and Ollama
 type AiClient struct {
 	config *config.Config
 	client *http.Client
+
+	// token usage the provider reported for the last completion, nil when it didn't
+	lastUsage *Usage
 }
 
 // Message represents a chat message
@@ -31,6 +51,7 @@ type Message struct {
 type ChatCompletionRequest struct {
 	Model    string    `json:"model,omitempty"`
 	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream,omitempty"`
 }
 
 // ChatCompletionChoice represents a choice in the chat completion response
@@ -45,18 +66,96 @@ type ChatCompletionResponse struct {
 	Object  string                 `json:"object"`
 	Created int64                  `json:"created"`
 	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *apiUsage              `json:"usage"`
+}
+
+// ChatCompletionStreamChunk is one server-sent event of a streamed chat completion
+type ChatCompletionStreamChunk struct {
+	Choices []struct {
+		Delta Message `json:"delta"`
+	} `json:"choices"`
+	// OpenRouter reports usage in the last chunk, Anthropic in message_start and message_delta
+	Usage *apiUsage `json:"usage"`
+	// Anthropic sends text in content_block_delta events instead
+	Type  string `json:"type"`
+	Delta struct {
+		Text string `json:"text"`
+	} `json:"delta"`
+	Message struct {
+		Usage *apiUsage `json:"usage"`
+	} `json:"message"`
 }
 
 func NewAiClient(cfg *config.Config) *AiClient {
 	return &AiClient{
 		config: cfg,
-		client: &http.Client{},
+		client: newHTTPClient(cfg.HTTP),
+	}
+}
+
+// newHTTPClient builds the single keep-alive client reused for every request of an AiClient
+func newHTTPClient(cfg config.HTTPConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	if cfg.IdleConnTimeoutSec > 0 {
+		transport.IdleConnTimeout = time.Duration(cfg.IdleConnTimeoutSec) * time.Second
+	}
+	if cfg.KeepAliveSec > 0 {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: time.Duration(cfg.KeepAliveSec) * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.TimeoutSec) * time.Second,
+	}
+}
+
+// provider returns the provider requests go to, the first configured one in this order:
+// Azure OpenAI (api_key set), Anthropic (api_key set), Ollama (model set), and otherwise
+// OpenRouter or any OpenAI compatible API at openrouter.base_url
+func (c *AiClient) provider() string {
+	switch {
+	case c.config.AzureOpenAI.APIKey != "":
+		return providerAzureOpenAI
+	case c.config.Anthropic.APIKey != "":
+		return providerAnthropic
+	case c.config.Ollama.Model != "":
+		return providerOllama
+	default:
+		return providerOpenRouter
 	}
 }
 
 // GetResponseFromChatMessages gets a response from the AI based on chat messages
 func (c *AiClient) GetResponseFromChatMessages(ctx context.Context, chatMessages []ChatMessage, model string) (string, error) {
-	// Convert chat messages to AI client format
+	aiMessages := toAiMessages(chatMessages)
+	logger.Info("Sending %d messages to AI", len(aiMessages))
+
+	// Get response from AI
+	response, err := c.ChatCompletion(ctx, aiMessages, model)
+	if err != nil {
+		return "", err
+	}
+
+	return response, nil
+}
+
+// StreamResponseFromChatMessages gets a response from the AI based on chat messages, passing each piece to onDelta as it arrives
+func (c *AiClient) StreamResponseFromChatMessages(ctx context.Context, chatMessages []ChatMessage, model string, onDelta func(delta string)) (string, error) {
+	aiMessages := toAiMessages(chatMessages)
+	logger.Info("Streaming %d messages to AI", len(aiMessages))
+	return c.ChatCompletionStream(ctx, aiMessages, model, onDelta)
+}
+
+// toAiMessages converts chat messages to the AI client format, a leading non-user message is the system prompt
+func toAiMessages(chatMessages []ChatMessage) []Message {
 	aiMessages := []Message{}
 
 	for i, msg := range chatMessages {
@@ -75,60 +174,127 @@ func (c *AiClient) GetResponseFromChatMessages(ctx context.Context, chatMessages
 			Content: msg.Content,
 		})
 	}
+	return aiMessages
+}
 
-	logger.Info("Sending %d messages to AI", len(aiMessages))
+// ChatCompletion sends a chat completion request to the OpenRouter API
+func (c *AiClient) ChatCompletion(ctx context.Context, messages []Message, model string) (string, error) {
+	c.lastUsage = nil
+	switch c.provider() {
+	case providerAnthropic:
+		return c.anthropicCompletion(ctx, messages, model)
+	case providerOllama:
+		return c.ollamaCompletion(ctx, messages, model)
+	}
 
-	// Get response from AI
-	response, err := c.ChatCompletion(ctx, aiMessages, model)
+	req, url, err := c.newChatRequest(ctx, ChatCompletionRequest{Model: model, Messages: messages})
 	if err != nil {
 		return "", err
 	}
 
-	return response, nil
-}
+	// Log the request details for debugging before sending
+	logger.Debug("Sending API request to: %s with model: %s", url, model)
 
-// ChatCompletion sends a chat completion request to the OpenRouter API
-func (c *AiClient) ChatCompletion(ctx context.Context, messages []Message, model string) (string, error) {
-	reqBody := ChatCompletionRequest{
-		Model:    model,
-		Messages: messages,
+	// Send the request
+	resp, err := c.do(req)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return "", fmt.Errorf("request canceled: %w", ctx.Err())
+		}
+		logger.Error("Failed to send request: %v", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Read the response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response: %v", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Log the raw response for debugging
+	logger.Debug("API response status: %d, response size: %d bytes", resp.StatusCode, len(body))
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("API returned error: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("API returned error: %s", c.redactLog(string(body)))
+	}
+
+	// Parse the response
+	var completionResp ChatCompletionResponse
+	if err := json.Unmarshal(body, &completionResp); err != nil {
+		logger.Error("Failed to unmarshal response: %v, body: %s", err, c.redactLog(string(body)))
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if completionResp.Usage != nil {
+		usage := completionResp.Usage.normalize()
+		c.lastUsage = &usage
+	}
+
+	// Return the response content
+	if len(completionResp.Choices) > 0 {
+		responseContent := completionResp.Choices[0].Message.Content
+		logger.Debug("Received AI response (%d characters): %s", len(responseContent), c.redactLog(responseContent))
+		return responseContent, nil
 	}
 
+	// Enhanced error for no completion choices
+	logger.Error("No completion choices returned. Raw response: %s", c.redactLog(string(body)))
+	return "", fmt.Errorf("no completion choices returned (model: %s, status: %d)", model, resp.StatusCode)
+}
+
+// newChatRequest builds the chat completion request for the configured OpenRouter or Azure OpenAI endpoint
+func (c *AiClient) newChatRequest(ctx context.Context, reqBody ChatCompletionRequest) (*http.Request, string, error) {
 	// determine endpoint and headers based on configuration
 	var url string
 	var apiKeyHeader string
 	var apiKey string
+	var extraHeaders map[string]string
 
 	if c.config.AzureOpenAI.APIKey != "" {
 		// Use Azure OpenAI endpoint
 		base := strings.TrimSuffix(c.config.AzureOpenAI.APIBase, "/")
-		url = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
-			base,
-			c.config.AzureOpenAI.DeploymentName,
-			c.config.AzureOpenAI.APIVersion)
+		path := c.config.AzureOpenAI.ChatPath
+		if path == "" {
+			path = defaultAzureChatPath
+		}
+		path = strings.NewReplacer(
+			"{deployment}", c.config.AzureOpenAI.DeploymentName,
+			"{api_version}", c.config.AzureOpenAI.APIVersion,
+		).Replace(path)
+		url = base + "/" + strings.TrimPrefix(path, "/")
 		apiKeyHeader = "api-key"
 		apiKey = c.config.AzureOpenAI.APIKey
+		extraHeaders = c.config.AzureOpenAI.Headers
 
 		// Azure endpoint doesn't expect model in body
 		reqBody.Model = ""
 	} else {
 		// default OpenRouter/OpenAI compatible endpoint
 		baseURL := strings.TrimSuffix(c.config.OpenRouter.BaseURL, "/")
-		url = baseURL + "/chat/completions"
+		path := c.config.OpenRouter.ChatPath
+		if path == "" {
+			path = defaultChatPath
+		}
+		url = baseURL + "/" + strings.TrimPrefix(path, "/")
 		apiKeyHeader = "Authorization"
 		apiKey = "Bearer " + c.config.OpenRouter.APIKey
+		extraHeaders = c.config.OpenRouter.Headers
 	}
 
 	reqJSON, err := json.Marshal(reqBody)
 	if err != nil {
 		logger.Error("Failed to marshal request: %v", err)
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, url, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqJSON))
 	if err != nil {
 		logger.Error("Failed to create request: %v", err)
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, url, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
@@ -138,11 +304,41 @@ func (c *AiClient) ChatCompletion(ctx context.Context, messages []Message, model
 	req.Header.Set("HTTP-Referer", "https://github.com/alvinunreal/tmuxai")
 	req.Header.Set("X-Title", "TmuxAI")
 
-	// Log the request details for debugging before sending
-	logger.Debug("Sending API request to: %s with model: %s", url, model)
+	// Provider specific headers, e.g. API version headers
+	for name, value := range extraHeaders {
+		req.Header.Set(name, value)
+	}
+	return req, url, nil
+}
 
-	// Send the request
-	resp, err := c.client.Do(req)
+// ChatCompletionStream sends a chat completion request with stream enabled, calling onDelta with each
+// piece of content as it arrives, and returns the assembled response
+func (c *AiClient) ChatCompletionStream(ctx context.Context, messages []Message, model string, onDelta func(delta string)) (string, error) {
+	c.lastUsage = nil
+	var req *http.Request
+	var url string
+	var err error
+	switch c.provider() {
+	case providerOllama:
+		// Ollama streams newline delimited JSON rather than server-sent events, hand over the reply at once
+		response, err := c.ollamaCompletion(ctx, messages, model)
+		if err == nil && onDelta != nil {
+			onDelta(response)
+		}
+		return response, err
+	case providerAnthropic:
+		req, url, err = c.newAnthropicRequest(ctx, messages, model, true)
+	default:
+		req, url, err = c.newChatRequest(ctx, ChatCompletionRequest{Model: model, Messages: messages, Stream: true})
+	}
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	logger.Debug("Sending streaming API request to: %s with model: %s", url, model)
+
+	resp, err := c.do(req)
 	if err != nil {
 		if ctx.Err() == context.Canceled {
 			return "", fmt.Errorf("request canceled: %w", ctx.Err())
@@ -152,42 +348,133 @@ func (c *AiClient) ChatCompletion(ctx context.Context, messages []Message, model
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("Failed to read response: %v", err)
-		return "", fmt.Errorf("failed to read response: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.Error("API returned error: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("API returned error: %s", c.redactLog(string(body)))
 	}
 
-	// Log the raw response for debugging
-	logger.Debug("API response status: %d, response size: %d bytes", resp.StatusCode, len(body))
+	var content strings.Builder
+	var streamed apiUsage
+	reportedUsage := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// blank separators, comments like OpenRouter's ": OPENROUTER PROCESSING" keep-alives
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
 
-	// Check for errors
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("API returned error: %s", body)
-		return "", fmt.Errorf("API returned error: %s", body)
+		var chunk ChatCompletionStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logger.Error("Failed to unmarshal stream chunk: %v, data: %s", err, data)
+			return "", fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		for _, reported := range []*apiUsage{chunk.Usage, chunk.Message.Usage} {
+			if reported != nil {
+				// Anthropic's counts are running totals, take the latest rather than adding them up
+				streamed.PromptTokens = max(streamed.PromptTokens, reported.PromptTokens+reported.InputTokens)
+				streamed.CompletionTokens = max(streamed.CompletionTokens, reported.CompletionTokens+reported.OutputTokens)
+				reportedUsage = true
+			}
+		}
+		if chunk.Type == "error" {
+			logger.Error("API returned error: %s", data)
+			return "", fmt.Errorf("API returned error: %s", data)
+		}
+		delta := chunk.Delta.Text
+		// Azure sends a first chunk with content filter results and no choices
+		if len(chunk.Choices) > 0 {
+			delta = chunk.Choices[0].Delta.Content
+		}
+		if delta == "" {
+			continue
+		}
+		content.WriteString(delta)
+		if onDelta != nil {
+			onDelta(delta)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("request canceled: %w", ctx.Err())
+		}
+		logger.Error("Failed to read stream: %v", err)
+		return "", fmt.Errorf("failed to read stream: %w", err)
 	}
 
-	// Parse the response
-	var completionResp ChatCompletionResponse
-	if err := json.Unmarshal(body, &completionResp); err != nil {
-		logger.Error("Failed to unmarshal response: %v, body: %s", err, body)
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	if content.Len() == 0 {
+		return "", fmt.Errorf("no content streamed (model: %s)", model)
+	}
+	if reportedUsage {
+		usage := streamed.normalize()
+		c.lastUsage = &usage
 	}
+	logger.Debug("Received streamed AI response (%d characters): %s", content.Len(), c.redactLog(content.String()))
+	return content.String(), nil
+}
 
-	// Return the response content
-	if len(completionResp.Choices) > 0 {
-		responseContent := completionResp.Choices[0].Message.Content
-		logger.Debug("Received AI response (%d characters): %s", len(responseContent), responseContent)
-		return responseContent, nil
+// LastUsage returns the token usage reported by the provider for the last completion, if it reported any
+func (c *AiClient) LastUsage() (Usage, bool) {
+	if c.lastUsage == nil {
+		return Usage{}, false
 	}
+	return *c.lastUsage, true
+}
 
-	// Enhanced error for no completion choices
-	logger.Error("No completion choices returned. Raw response: %s", string(body))
-	return "", fmt.Errorf("no completion choices returned (model: %s, status: %d)", model, resp.StatusCode)
+// ModelContextWindows fetches each model's context window from the /models endpoint next to the chat
+// endpoint, as reported by OpenRouter in context_length. Azure deployments aren't listed there.
+func (c *AiClient) ModelContextWindows(ctx context.Context) (map[string]int, error) {
+	if c.provider() != providerOpenRouter {
+		return nil, fmt.Errorf("model context windows are only listed by OpenRouter compatible providers")
+	}
+
+	url := strings.TrimSuffix(c.config.OpenRouter.BaseURL, "/") + "/models"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.OpenRouter.APIKey)
+	for name, value := range c.config.OpenRouter.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch models: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("models endpoint returned %s", resp.Status)
+	}
+
+	var models struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	windows := make(map[string]int)
+	for _, model := range models.Data {
+		if model.ContextLength > 0 {
+			windows[model.ID] = model.ContextLength
+		}
+	}
+	return windows, nil
 }
 
-func debugChatMessages(chatMessages []ChatMessage, response string) {
+// debugChatMessages writes the sent messages and the response to a file in the debug directory,
+// with secrets masked unless redact_secrets is off
+func (m *Manager) debugChatMessages(chatMessages []ChatMessage, response string) {
 
 	timestamp := time.Now().Format("20060102-150405")
 	configDir, _ := config.GetConfigDir()
@@ -219,10 +506,10 @@ func debugChatMessages(chatMessages []ChatMessage, response string) {
 		timeStr := msg.Timestamp.Format(time.RFC3339)
 
 		_, _ = fmt.Fprintf(file, "Message %d: Role=%s, Time=%s\n", i+1, role, timeStr)
-		_, _ = fmt.Fprintf(file, "Content:\n%s\n\n", msg.Content)
+		_, _ = fmt.Fprintf(file, "Content:\n%s\n\n", m.redactForDebug(msg.Content))
 	}
 
 	_, _ = file.WriteString("==================    RECEIVED RESPONSE ==================\n\n")
-	_, _ = file.WriteString(response)
+	_, _ = file.WriteString(m.redactForDebug(response))
 	_, _ = file.WriteString("\n\n==================    END DEBUG ==================\n")
 }
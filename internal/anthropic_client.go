@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	anthropicVersion          = "2023-06-01"
+	defaultAnthropicMaxTokens = 4096
+	defaultAnthropicModel     = "claude-sonnet-4-5"
+)
+
+// anthropicRequest is the body of a request to the Anthropic Messages API,
+// the system prompt is a top-level field rather than a message
+type anthropicRequest struct {
+	Model     string    `json:"model"`
+	System    string    `json:"system,omitempty"`
+	Messages  []Message `json:"messages"`
+	MaxTokens int       `json:"max_tokens"`
+	Stream    bool      `json:"stream,omitempty"`
+}
+
+// anthropicResponse is the part of a Messages API response we read
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage *apiUsage `json:"usage"`
+}
+
+// anthropicModel returns the model for a request: an explicitly picked model like summary_model or a
+// /config set openrouter.model wins, the configured chat model holds an OpenRouter id and is replaced
+// with anthropic.model, or a default Claude model when that's unset
+func (c *AiClient) anthropicModel(model string) string {
+	if model != "" && model != c.config.OpenRouter.Model {
+		return model
+	}
+	if c.config.Anthropic.Model != "" {
+		return c.config.Anthropic.Model
+	}
+	return defaultAnthropicModel
+}
+
+// newAnthropicRequest builds a Messages API request, moving system messages to the system field
+func (c *AiClient) newAnthropicRequest(ctx context.Context, messages []Message, model string, stream bool) (*http.Request, string, error) {
+	cfg := c.config.Anthropic
+	body := anthropicRequest{Model: c.anthropicModel(model), MaxTokens: cfg.MaxTokens, Stream: stream}
+	if body.MaxTokens <= 0 {
+		body.MaxTokens = defaultAnthropicMaxTokens
+	}
+	var system []string
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	body.System = strings.Join(system, "\n\n")
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+	url := strings.TrimSuffix(baseURL, "/") + "/v1/messages"
+
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("Failed to marshal request: %v", err)
+		return nil, url, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		logger.Error("Failed to create request: %v", err)
+		return nil, url, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", cfg.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	return req, url, nil
+}
+
+// anthropicCompletion sends the messages to the Anthropic Messages API and returns the text of the reply
+func (c *AiClient) anthropicCompletion(ctx context.Context, messages []Message, model string) (string, error) {
+	req, url, err := c.newAnthropicRequest(ctx, messages, model, false)
+	if err != nil {
+		return "", err
+	}
+	logger.Debug("Sending Anthropic API request to: %s", url)
+
+	resp, err := c.do(req)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return "", fmt.Errorf("request canceled: %w", ctx.Err())
+		}
+		logger.Error("Failed to send request: %v", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response: %v", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	logger.Debug("API response status: %d, response size: %d bytes", resp.StatusCode, len(body))
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("API returned error: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("API returned error: %s", c.redactLog(string(body)))
+	}
+
+	var messageResp anthropicResponse
+	if err := json.Unmarshal(body, &messageResp); err != nil {
+		logger.Error("Failed to unmarshal response: %v, body: %s", err, c.redactLog(string(body)))
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if messageResp.Usage != nil {
+		usage := messageResp.Usage.normalize()
+		c.lastUsage = &usage
+	}
+
+	var text strings.Builder
+	for _, block := range messageResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		logger.Error("No text content returned. Raw response: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("no text content returned (model: %s, status: %d)", model, resp.StatusCode)
+	}
+	logger.Debug("Received AI response (%d characters): %s", text.Len(), c.redactLog(text.String()))
+	return text.String(), nil
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// blockCommandPattern adds a blocked command pattern for the rest of the session
+func (m *Manager) blockCommandPattern(pattern string) error {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	var added []string
+	switch current := m.SessionOverrides["blocked_command_patterns"].(type) {
+	case []string:
+		added = current
+	case []any:
+		for _, p := range current {
+			if s, ok := p.(string); ok {
+				added = append(added, s)
+			}
+		}
+	}
+	m.SessionOverrides["blocked_command_patterns"] = append(added, pattern)
+	return nil
+}
+
+// blockedCommandPattern returns the blocked_command_patterns entry command matches, if any
+func (m *Manager) blockedCommandPattern(command string) (string, bool) {
+	for _, pattern := range m.GetBlockedCommandPatterns() {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("Ignoring invalid blocked command pattern '%s': %v", pattern, err)
+			continue
+		}
+		if re.MatchString(command) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// maxRefusals caps how often one request goes back to the AI after a blocked command or disabled action
+const maxRefusals = 3
+
+// refusalLimitReached counts a refused action of the current request and reports whether the AI
+// already had its maxRefusals chances to pick another approach, ending the request if so
+func (m *Manager) refusalLimitReached() bool {
+	m.turnRefusals++
+	if m.turnRefusals <= maxRefusals {
+		return false
+	}
+	m.trace("turn ends: %d refused actions", m.turnRefusals)
+	m.Println(fmt.Sprintf("AI kept proposing refused actions, stopping after %d attempts", maxRefusals))
+	m.Status = ""
+	return true
+}
+
+// blockedCommandMessage tells the AI its command was refused
+func blockedCommandMessage(command string) string {
+	return fmt.Sprintf("The command `%s` is blocked in this environment as too dangerous and was not executed. Use a safer approach to accomplish the request, or explain to the user why it's needed.", command)
+}
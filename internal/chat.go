@@ -1,19 +1,22 @@
 package internal
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/alvinunreal/tmuxai/config"
+	"github.com/alvinunreal/tmuxai/logger"
 	"github.com/nyaosorg/go-readline-ny"
 	"github.com/nyaosorg/go-readline-ny/completion"
 	"github.com/nyaosorg/go-readline-ny/keys"
-	"github.com/nyaosorg/go-readline-ny/simplehistory"
 )
 
 // Message represents a chat message
@@ -21,6 +24,7 @@ type ChatMessage struct {
 	Content   string
 	FromUser  bool
 	Timestamp time.Time
+	Pinned    bool // kept verbatim when the history is squashed
 }
 
 type CLIInterface struct {
@@ -39,18 +43,10 @@ func NewCLIInterface(manager *Manager) *CLIInterface {
 func (c *CLIInterface) Start(initMessage string) error {
 	c.printWelcomeMessage()
 
-	// Initialize history
-	history := simplehistory.New()
-	historyFilePath := config.GetConfigFilePath("history")
-
-	// Load history from file if it exists
-	if historyData, err := os.ReadFile(historyFilePath); err == nil {
-		for _, line := range strings.Split(string(historyData), "\n") {
-			if line = strings.TrimSpace(line); line != "" {
-				history.Add(line)
-			}
-		}
-	}
+	// Initialize history, loading it from file if it exists
+	historyFilePath := c.manager.historyFilePath()
+	_ = os.MkdirAll(filepath.Dir(historyFilePath), 0o755)
+	history := loadHistory(historyFilePath)
 
 	// Initialize editor
 	editor := &readline.Editor{
@@ -66,7 +62,7 @@ func (c *CLIInterface) Start(initMessage string) error {
 
 	if initMessage != "" {
 		fmt.Printf("%s%s\n", c.manager.GetPrompt(), initMessage)
-		c.processInput(initMessage)
+		c.processLockedInput(initMessage)
 	}
 
 	ctx := context.Background()
@@ -109,21 +105,55 @@ func (c *CLIInterface) Start(initMessage string) error {
 			continue
 		}
 
-		c.processInput(input)
+		c.processLockedInput(input)
 	}
 }
 
-// printWelcomeMessage prints a welcome message
+// processLockedInput processes typed input, waiting for any prompt from the remote control API to finish
+func (c *CLIInterface) processLockedInput(input string) bool {
+	c.manager.inputMu.Lock()
+	defer c.manager.inputMu.Unlock()
+	return c.processInput(input)
+}
+
+const firstRunTips = `Tips:
+- Run /prepare to let TmuxAI track command output and exit codes in the exec pane
+- Pick a model with 'openrouter.model' in the config file or '/config set openrouter.model <model>'`
+
+// firstTurnFlagPath is the file marking that the user completed a first turn, overridable in tests
+var firstTurnFlagPath = func() string {
+	return config.GetConfigFilePath("first_turn_done")
+}
+
+// printWelcomeMessage prints the configured welcome message and, until a first turn completes, some tips
 func (c *CLIInterface) printWelcomeMessage() {
 	fmt.Println()
-	fmt.Println("Type '/help' for a list of commands, '/exit' to quit")
-	fmt.Println()
+	if c.manager.Config.WelcomeMessage != "" {
+		fmt.Println(c.manager.Config.WelcomeMessage)
+		fmt.Println()
+	}
+	if _, err := os.Stat(firstTurnFlagPath()); os.IsNotExist(err) {
+		fmt.Println(firstRunTips)
+		fmt.Println()
+	}
+}
+
+// markFirstTurnDone records that the first-run tips are no longer needed
+func markFirstTurnDone() {
+	path := firstTurnFlagPath()
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	if err := os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0o644); err != nil {
+		logger.Error("Failed to write first turn flag %s: %v", path, err)
+	}
 }
 
-func (c *CLIInterface) processInput(input string) {
+// processInput handles one line of input, returning false when a request didn't complete
+func (c *CLIInterface) processInput(input string) bool {
 	if c.manager.IsMessageSubcommand(input) {
 		c.manager.ProcessSubCommand(input)
-		return
+		return true
 	}
 
 	// Set up signal handling for Ctrl+C
@@ -133,29 +163,113 @@ func (c *CLIInterface) processInput(input string) {
 	// Set up a notification channel
 	done := make(chan struct{})
 
-	// Create a cancellable context
+	// Create a cancellable context, bounded by the turn deadline when one is configured
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if deadline := c.manager.GetTurnDeadlineSec(); deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, time.Duration(deadline)*time.Second)
+		defer cancelDeadline()
+	}
 
-	// Launch a goroutine just for handling the interrupt
+	// Launch a goroutine just for handling the interrupt and the deadline
 	go func() {
 		select {
 		case <-sigChan:
 			cancel()
 			c.manager.Status = ""
 			c.manager.WatchMode = false
+		case <-ctx.Done():
+			// stop waiting on the exec pane too, not just the AI request
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				c.manager.Status = ""
+				c.manager.WatchMode = false
+			}
 		case <-done:
 		}
 	}()
 
+	// While waiting for a reply, a bare continuation keyword just resumes the task
+	if c.manager.Status == "waiting" && c.manager.isContinuation(input) {
+		input = continuationMessage
+	}
+
 	// Run the message processing in the main thread
 	c.manager.Status = "running"
-	c.manager.ProcessUserMessage(ctx, input)
-	c.manager.Status = ""
+	c.manager.turnApprovals = nil
+	c.manager.turnRequest = input
+	c.manager.turnCommands = nil
+	c.manager.verificationRequested = false
+	c.manager.turnRefusals = 0
+	turnsBefore := c.manager.Turns
+	accomplished := c.manager.ProcessUserMessage(ctx, input)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.manager.reportTurnDeadline(c.manager.Turns - turnsBefore)
+		c.manager.Status = ""
+	}
+	c.manager.snapshotExecPane()
+	markFirstTurnDone()
+	if c.manager.Status != "waiting" {
+		c.manager.Status = ""
+	}
 
 	close(done)
 
 	signal.Stop(sigChan)
+	return accomplished
+}
+
+// runScript dispatches each non-empty line through processInput, waiting for one to finish before the next.
+// Lines starting with # are comments. With script_stop_on_failure, the first request that doesn't complete stops the batch.
+func (c *CLIInterface) runScript(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fmt.Printf("%s%s\n", c.manager.GetPrompt(), line)
+		logger.Info("Script line %d: %s", lineNo, line)
+		accomplished := c.processLockedInput(line)
+		if c.manager.Status == "waiting" {
+			// nobody is there to answer, don't carry the question over to the next line
+			c.manager.Status = ""
+			accomplished = false
+		}
+		if !accomplished && c.manager.Config.ScriptStopOnFailure {
+			return fmt.Errorf("script stopped at line %d: %s", lineNo, line)
+		}
+	}
+	return scanner.Err()
+}
+
+// reportTurnDeadline prints what a request got done before turn_deadline_sec stopped it
+func (m *Manager) reportTurnDeadline(aiTurns int) {
+	m.Println(fmt.Sprintf("Turn deadline of %ds reached after %d AI response(s), stopping.", m.GetTurnDeadlineSec(), aiTurns))
+	if len(m.turnCommands) == 0 {
+		m.Println("No commands were run before the deadline.")
+		return
+	}
+	m.Println("Commands run before the deadline:")
+	for _, command := range m.turnCommands {
+		fmt.Println("  " + command)
+	}
+}
+
+const continuationMessage = "Continue with the task, here is the current pane(s) content"
+
+// isContinuation checks if the input is one of the configured continuation keywords
+func (m *Manager) isContinuation(input string) bool {
+	normalized := strings.Trim(strings.ToLower(strings.TrimSpace(input)), ".!")
+	for _, keyword := range m.Config.ContinueKeywords {
+		if normalized == strings.ToLower(strings.TrimSpace(keyword)) {
+			return true
+		}
+	}
+	return false
 }
 
 // newCompleter creates a completion handler for command completion
@@ -178,10 +292,52 @@ func (c *CLIInterface) newCompleter() *completion.CmdCompletionOrList2 {
 				}
 			}
 
+			// Handle /save and /load session names
+			if len(field) > 0 && (field[0] == "/save" || field[0] == "/load") {
+				if len(field) == 1 || (len(field) == 2 && !strings.HasSuffix(field[1], " ")) {
+					names := savedSessionNames()
+					return names, names
+				}
+			}
+
+			// Handle /squash subcommands
+			if len(field) > 0 && field[0] == "/squash" {
+				if len(field) == 1 || (len(field) == 2 && !strings.HasSuffix(field[1], " ")) {
+					return []string{"now", "threshold"}, []string{"now", "threshold"}
+				}
+			}
+
 			// Handle /prepare subcommands
 			if len(field) > 0 && field[0] == "/prepare" {
 				if len(field) == 1 || (len(field) == 2 && !strings.HasSuffix(field[1], " ")) {
-					return []string{"bash", "zsh", "fish"}, []string{"bash", "zsh", "fish"}
+					shells := []string{"bash", "zsh", "fish", "nu", "pwsh"}
+					return shells, shells
+				}
+			}
+
+			// Handle /mode subcommands
+			if len(field) > 0 && field[0] == "/mode" {
+				if len(field) == 1 || (len(field) == 2 && !strings.HasSuffix(field[1], " ")) {
+					return []string{"observe", "prepared"}, []string{"observe", "prepared"}
+				} else if strings.TrimSpace(field[1]) == "prepared" && (len(field) == 2 || (len(field) == 3 && !strings.HasSuffix(field[2], " "))) {
+					shells := []string{"bash", "zsh", "fish", "nu", "pwsh"}
+					return shells, shells
+				}
+			}
+
+			// Handle /pane subcommands and pane ids
+			if len(field) > 0 && field[0] == "/pane" {
+				if len(field) == 1 || (len(field) == 2 && !strings.HasSuffix(field[1], " ")) {
+					return []string{"list", "select"}, []string{"list", "select"}
+				} else if strings.TrimSpace(field[1]) == "select" && (len(field) == 2 || (len(field) == 3 && !strings.HasSuffix(field[2], " "))) {
+					var ids []string
+					panes, _ := c.manager.GetTmuxPanes()
+					for _, pane := range panes {
+						if !pane.IsTmuxAiPane {
+							ids = append(ids, pane.Id)
+						}
+					}
+					return ids, ids
 				}
 			}
 			return nil, nil
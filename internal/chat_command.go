@@ -3,9 +3,13 @@ package internal
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/alvinunreal/tmuxai/config"
 	"github.com/alvinunreal/tmuxai/logger"
@@ -17,10 +21,33 @@ const helpMessage = `Available commands:
 - /clear: Clear the chat history
 - /reset: Reset the chat history
 - /prepare: Prepare the pane for TmuxAI automation
+- /mode [observe|prepared [shell]]: Show the mode or switch between observe and prepared mode, keeping the chat history
 - /watch <prompt>: Start watch mode
-- /squash: Summarize the chat history
+- /squash [now|threshold <tokens>]: Show how close the history is to squashing, squash now or change the threshold
 - /exit: Exit the application
-- /persona [name]: List available personas or switch to the specified one`
+- /persona [name]: List available personas or switch to the specified one
+- /pin [index]: List messages or pin one so squashing keeps it
+- /unpin <index>: Let a pinned message be squashed again
+- /undo: Remove the last exchange with the AI from the chat history
+- /save [name]: Save the chat history, exec history and session settings to resume later
+- /load [name]: Restore a session saved with /save
+- /export [path]: Write the chat history to a Markdown file, by default tmuxai-<time>.md in the current directory
+- /import-history <path>: Add the conversation in a Markdown or "User:"/"Assistant:" text file to the chat history
+- /window [target|current]: Show or change the tmux window whose panes are used
+- /pane [list|select <id>]: List the panes or switch the exec pane to another one
+- /raw: Show the raw text of the last AI response
+- /stats: Show session statistics
+- /env: Show the shell, OS, working directory and prepared state detected for the exec pane
+- /usage: Show token usage and estimated cost for this session
+- /reload: Re-read the config file
+- /last [n]: Show the last n exchanges (default 3)
+- /confirm [exec|keys|paste] [on|off]: Show or change which actions need confirmation
+- /diffpane-since: Show exec pane changes since the last AI turn
+- /trace: Print every decision of the agent loop for the next request
+- /fullcapture: Send the exec pane's whole scrollback with the next message
+- /capture [label|show <label> [feed]]: List captures, store the exec pane content under a label, or show one and optionally send it with the next message
+- /jobs: List processes running in the exec pane
+- /kill <pid>: Terminate a process running in the exec pane`
 
 var commands = []string{
 	"/help",
@@ -30,9 +57,32 @@ var commands = []string{
 	"/info",
 	"/watch",
 	"/prepare",
+	"/mode",
 	"/config",
 	"/squash",
 	"/persona",
+	"/pin",
+	"/unpin",
+	"/undo",
+	"/save",
+	"/load",
+	"/export",
+	"/import-history",
+	"/window",
+	"/pane",
+	"/raw",
+	"/stats",
+	"/env",
+	"/usage",
+	"/reload",
+	"/last",
+	"/confirm",
+	"/diffpane-since",
+	"/trace",
+	"/fullcapture",
+	"/capture",
+	"/jobs",
+	"/kill",
 }
 
 // checks if the given content is a command
@@ -68,54 +118,13 @@ func (m *Manager) ProcessSubCommand(command string) {
 		return
 
 	case prefixMatch(commandPrefix, "/prepare"):
-		supportedShells := []string{"bash", "zsh", "fish"}
-		m.InitExecPane()
-
-		// Check if exec pane is a subshell
-		if m.ExecPane.IsSubShell {
-			if len(parts) > 1 {
-				shell := parts[1]
-				isSupported := false
-				for _, supportedShell := range supportedShells {
-					if shell == supportedShell {
-						isSupported = true
-						break
-					}
-				}
-				if !isSupported {
-					m.Println(fmt.Sprintf("Shell '%s' is not supported. Supported shells are: %s", shell, strings.Join(supportedShells, ", ")))
-					return
-				}
-				m.PrepareExecPaneWithShell(shell)
-			} else {
-				m.Println("Shell detection is not supported on subshells.")
-				m.Println("Please specify the shell manually: /prepare bash, /prepare zsh, or /prepare fish")
-				return
-			}
-		} else {
-			if len(parts) > 1 {
-				shell := parts[1]
-				isSupported := false
-				for _, supportedShell := range supportedShells {
-					if shell == supportedShell {
-						isSupported = true
-						break
-					}
-				}
-
-				if !isSupported {
-					m.Println(fmt.Sprintf("Shell '%s' is not supported. Supported shells are: %s", shell, strings.Join(supportedShells, ", ")))
-					return
-				}
-				m.PrepareExecPaneWithShell(shell)
-			} else {
-				m.PrepareExecPane()
-			}
+		shell := ""
+		if len(parts) > 1 {
+			shell = parts[1]
+		}
+		if !m.prepareExecPaneCommand(shell) {
+			return
 		}
-
-		// for latency over ssh connections
-		time.Sleep(500 * time.Millisecond)
-		m.ExecPane.Refresh(m.GetMaxCaptureLines())
 		m.Messages = []ChatMessage{}
 
 		fmt.Println(m.ExecPane.String())
@@ -128,6 +137,10 @@ func (m *Manager) ProcessSubCommand(command string) {
 
 		return
 
+	case prefixMatch(commandPrefix, "/mode"):
+		m.switchMode(parts[1:])
+		return
+
 	case prefixMatch(commandPrefix, "/clear"):
 		m.Messages = []ChatMessage{}
 		_ = system.TmuxClearPane(m.PaneId)
@@ -138,6 +151,10 @@ func (m *Manager) ProcessSubCommand(command string) {
 		m.Messages = []ChatMessage{}
 		_ = system.TmuxClearPane(m.PaneId)
 		_ = system.TmuxClearPane(m.ExecPane.Id)
+		m.quietOutputs = nil
+		m.pendingQuietResults = nil
+		m.commandVars = nil
+		delete(m.SessionOverrides, alwaysAllowKey)
 		return
 
 	case prefixMatch(commandPrefix, "/exit"):
@@ -146,21 +163,28 @@ func (m *Manager) ProcessSubCommand(command string) {
 		return
 
 	case prefixMatch(commandPrefix, "/squash"):
-		m.squashHistory()
+		switch {
+		case len(parts) == 1:
+			m.printSquashStatus()
+		case parts[1] == "now":
+			m.squashNow()
+		case parts[1] == "threshold" && len(parts) == 3:
+			threshold, err := strconv.Atoi(parts[2])
+			if err != nil || threshold <= 0 {
+				m.Println("Threshold must be a positive number of tokens")
+				return
+			}
+			m.SessionOverrides["squash_threshold"] = threshold
+			m.Println(fmt.Sprintf("Squash threshold set to %d tokens for this session", threshold))
+		default:
+			m.Println("Usage: /squash [now|threshold <tokens>]")
+		}
 		return
 
 	case prefixMatch(commandPrefix, "/watch") || commandPrefix == "/w":
 		parts := strings.Fields(command)
 		if len(parts) > 1 {
-			watchDesc := strings.Join(parts[1:], " ")
-			startWatch := `
-1. Find out if there is new content in the pane based on chat history.
-2. Comment only considering the new content in this pane output.
-
-Watch for: ` + watchDesc
-			m.Status = "running"
-			m.WatchMode = true
-			m.startWatchMode(startWatch)
+			m.watch(strings.Join(parts[1:], " "))
 			return
 		}
 		m.Println("Usage: /watch <description>")
@@ -174,6 +198,141 @@ Watch for: ` + watchDesc
 		}
 		return
 
+	case prefixMatch(commandPrefix, "/pin"):
+		if len(parts) < 2 {
+			m.listMessagesForPin()
+			return
+		}
+		m.setPinned(parts[1], true)
+		return
+
+	case prefixMatch(commandPrefix, "/unpin"):
+		if len(parts) < 2 {
+			m.Println("Usage: /unpin <index>")
+			return
+		}
+		m.setPinned(parts[1], false)
+		return
+
+	case prefixMatch(commandPrefix, "/undo"):
+		m.undoLastExchange()
+		return
+
+	case prefixMatch(commandPrefix, "/export"):
+		// keep the path's case
+		args := strings.Fields(command)
+		m.exportHistory(strings.Join(args[1:], " "))
+		return
+
+	case prefixMatch(commandPrefix, "/import-history"):
+		// keep the path's case
+		args := strings.Fields(command)
+		if len(args) < 2 {
+			m.Println("Usage: /import-history <path>")
+			return
+		}
+		m.importHistory(strings.Join(args[1:], " "))
+		return
+
+	case prefixMatch(commandPrefix, "/raw"):
+		if m.LastRawResponse == "" {
+			m.Println("No AI response yet")
+			return
+		}
+		fmt.Println(m.LastRawResponse)
+		return
+
+	case prefixMatch(commandPrefix, "/stats"):
+		m.formatStats()
+		return
+
+	case prefixMatch(commandPrefix, "/env"):
+		m.formatEnv()
+		return
+
+	case prefixMatch(commandPrefix, "/usage"):
+		m.printUsage()
+		return
+
+	case prefixMatch(commandPrefix, "/reload"):
+		if err := m.reloadConfig(); err != nil {
+			m.Println(fmt.Sprintf("Failed to reload config: %v", err))
+			return
+		}
+		m.Println("Config reloaded")
+		return
+
+	case prefixMatch(commandPrefix, "/last"):
+		n := 3
+		if len(parts) > 1 {
+			parsed, err := strconv.Atoi(parts[1])
+			if err != nil || parsed < 1 {
+				m.Println("Usage: /last [n]")
+				return
+			}
+			n = parsed
+		}
+		m.printLastExchanges(n)
+		return
+
+	case prefixMatch(commandPrefix, "/diffpane-since"):
+		m.diffPaneSinceLastTurn()
+		return
+
+	case prefixMatch(commandPrefix, "/trace"):
+		m.traceNext = true
+		m.Println("Tracing the next request")
+		return
+
+	case prefixMatch(commandPrefix, "/fullcapture"):
+		m.FullCaptureNext = true
+		m.Println("The exec pane's full scrollback will be captured for the next message")
+		return
+
+	case prefixMatch(commandPrefix, "/capture"):
+		switch {
+		case len(parts) < 2:
+			m.listCaptures()
+		case parts[1] == "show":
+			if len(parts) < 3 {
+				m.Println("Usage: /capture show <label> [feed]")
+				return
+			}
+			m.showCapture(parts[2], len(parts) > 3 && parts[3] == "feed")
+		default:
+			m.capturePane(parts[1])
+		}
+		return
+
+	case prefixMatch(commandPrefix, "/jobs"):
+		m.listJobs()
+		return
+
+	case prefixMatch(commandPrefix, "/kill"):
+		if len(parts) < 2 {
+			m.Println("Usage: /kill <pid>")
+			return
+		}
+		m.killJob(parts[1])
+		return
+
+	case prefixMatch(commandPrefix, "/window"):
+		args := strings.Fields(command)
+		if len(args) < 2 {
+			if m.WindowTarget == "" {
+				m.Println("Using panes from the current window")
+			} else {
+				m.Println(fmt.Sprintf("Using panes from window %s", m.WindowTarget))
+			}
+			return
+		}
+		m.setWindowTarget(args[1])
+		return
+
+	case prefixMatch(commandPrefix, "/pane"):
+		m.paneCommand(strings.Fields(command)[1:])
+		return
+
 	case prefixMatch(commandPrefix, "/config"):
 		// Helper function to check if a key is allowed
 		isKeyAllowed := func(key string) bool {
@@ -192,16 +351,69 @@ Watch for: ` + watchDesc
 				m.Println(fmt.Sprintf("Cannot set '%s'. Only these keys are allowed: %s", key, strings.Join(AllowedConfigKeys, ", ")))
 				return
 			}
-			value := strings.Join(parts[3:], " ")
+			// keep the value as typed, a model name or regex may need its case and whitespace
+			value := argsAfter(command, 3)
+			if key == "max_session_cost_usd" {
+				model := m.GetOpenRouterModel()
+				if limit, err := strconv.ParseFloat(value, 64); err == nil && limit > 0 {
+					if _, ok := m.modelPricing(model); !ok {
+						m.Println(fmt.Sprintf("Not setting a cost cap, there is no pricing for %s to enforce it with. Add pricing.%s or pricing.default to the config first.", model, model))
+						return
+					}
+				}
+			}
+			if key == "blocked_command_patterns" {
+				if err := m.blockCommandPattern(value); err != nil {
+					m.Println(err.Error())
+				} else {
+					m.Println("Blocked commands matching " + value + " for this session")
+				}
+				return
+			}
 			m.SessionOverrides[key] = config.TryInferType(key, value)
 			m.Println(fmt.Sprintf("Set %s = %v", key, m.SessionOverrides[key]))
 			return
+		} else if len(parts) >= 2 && parts[1] == "get" {
+			if len(parts) >= 3 {
+				key := parts[2]
+				value, ok := m.GetConfigValue(key)
+				if !ok {
+					m.Println(fmt.Sprintf("Unknown config key '%s'", key))
+					return
+				}
+				m.Println(fmt.Sprintf("%s = %v", key, value))
+				return
+			}
+			m.formatConfigKeys()
+			return
 		} else {
 			code, _ := system.HighlightCode("yaml", m.FormatConfig())
 			fmt.Println(code)
 			return
 		}
 
+	case prefixMatch(commandPrefix, "/save"):
+		args := strings.Fields(command)
+		name := defaultSessionName
+		if len(args) > 1 {
+			name = args[1]
+		}
+		m.saveSession(name)
+		return
+
+	case prefixMatch(commandPrefix, "/load"):
+		args := strings.Fields(command)
+		name := defaultSessionName
+		if len(args) > 1 {
+			name = args[1]
+		}
+		m.loadSession(name)
+		return
+
+	case prefixMatch(commandPrefix, "/confirm"):
+		m.setConfirm(parts[1:])
+		return
+
 	default:
 		m.Println(fmt.Sprintf("Unknown command: %s. Type '/help' to see available commands.", command))
 		return
@@ -213,6 +425,283 @@ func prefixMatch(command, target string) bool {
 	return strings.HasPrefix(target, command)
 }
 
+// setWindowTarget scopes pane capture and exec pane selection to the given tmux window
+func (m *Manager) setWindowTarget(target string) {
+	if target == "current" {
+		m.WindowTarget = ""
+	} else {
+		if _, err := system.TmuxPanesDetails(target); err != nil {
+			m.Println(fmt.Sprintf("Window '%s' not found: %v", target, err))
+			return
+		}
+		m.WindowTarget = target
+	}
+
+	// Re-pick the exec pane if the current one is outside the new window
+	panes, _ := m.GetTmuxPanes()
+	inWindow := false
+	for _, pane := range panes {
+		if pane.Id == m.ExecPane.Id {
+			inWindow = true
+			break
+		}
+	}
+	if !inWindow {
+		m.InitExecPane()
+	}
+
+	if m.WindowTarget == "" {
+		m.Println(fmt.Sprintf("Using panes from the current window, exec pane %s", m.ExecPane.Id))
+	} else {
+		m.Println(fmt.Sprintf("Using panes from window %s, exec pane %s", m.WindowTarget, m.ExecPane.Id))
+	}
+}
+
+var (
+	paneContextRegex = regexp.MustCompile(`(?s)<current_tmux_window_state>.*?</current_tmux_window_state>`)
+	paneEnvRegex     = regexp.MustCompile(`(?m)^Keep in mind, you are working within the shell: .*$`)
+)
+
+// lastExchangeMaxLen bounds each side of an exchange printed by /last
+const lastExchangeMaxLen = 200
+
+// compactMessage strips the injected pane context and collapses a message to one bounded line
+func compactMessage(content string) string {
+	content = paneContextRegex.ReplaceAllString(content, "")
+	content = paneEnvRegex.ReplaceAllString(content, "")
+	content = strings.Join(strings.Fields(content), " ")
+	runes := []rune(content)
+	if len(runes) > lastExchangeMaxLen {
+		content = string(runes[:lastExchangeMaxLen-3]) + "..."
+	}
+	return content
+}
+
+// printLastExchanges prints the last n user/assistant exchanges compactly
+func (m *Manager) printLastExchanges(n int) {
+	type exchange struct{ user, assistant string }
+	var exchanges []exchange
+	for _, msg := range m.Messages {
+		if msg.FromUser {
+			exchanges = append(exchanges, exchange{user: compactMessage(msg.Content)})
+		} else if len(exchanges) > 0 {
+			exchanges[len(exchanges)-1].assistant = compactMessage(msg.Content)
+		}
+	}
+
+	if len(exchanges) == 0 {
+		m.Println("No messages yet")
+		return
+	}
+	if n < len(exchanges) {
+		exchanges = exchanges[len(exchanges)-n:]
+	}
+	for _, e := range exchanges {
+		fmt.Printf("you: %s\n", e.user)
+		if e.assistant != "" {
+			fmt.Printf("ai:  %s\n", e.assistant)
+		}
+	}
+}
+
+// undoLastExchange removes the last user message and the AI response to it from the chat history,
+// and the last exec history entry when it's a command from that response
+func (m *Manager) undoLastExchange() {
+	last := len(m.Messages) - 1
+	if last < 0 {
+		m.Println("Nothing to undo, the chat history is empty")
+		return
+	}
+	start := last
+	if !m.Messages[last].FromUser && last > 0 && m.Messages[last-1].FromUser {
+		start = last - 1
+	}
+	removed := m.Messages[start:]
+
+	if n := len(m.ExecHistory); n > 0 && !removed[len(removed)-1].FromUser {
+		if r, err := m.parseAIResponse(removed[len(removed)-1].Content); err == nil && slices.Contains(r.ExecCommand, m.ExecHistory[n-1].Command) {
+			m.ExecHistory = m.ExecHistory[:n-1]
+		}
+	}
+	m.Messages = m.Messages[:start]
+
+	for _, msg := range removed {
+		role := "ai: "
+		if msg.FromUser {
+			role = "you:"
+		}
+		m.Println(fmt.Sprintf("Removed %s %s", role, compactMessage(msg.Content)))
+	}
+}
+
+// listMessagesForPin prints each chat message with the index /pin and /unpin take
+func (m *Manager) listMessagesForPin() {
+	if len(m.Messages) == 0 {
+		m.Println("No messages yet")
+		return
+	}
+	for i, msg := range m.Messages {
+		role := "ai: "
+		if msg.FromUser {
+			role = "you:"
+		}
+		marker := " "
+		if msg.Pinned {
+			marker = "*"
+		}
+		fmt.Printf("%s%3d %s %s\n", marker, i+1, role, compactMessage(msg.Content))
+	}
+}
+
+// setPinned pins or unpins the message at the given 1-based index
+func (m *Manager) setPinned(arg string, pinned bool) {
+	index, err := strconv.Atoi(arg)
+	if err != nil || index < 1 || index > len(m.Messages) {
+		m.Println(fmt.Sprintf("Invalid message index: %s (see /pin for the list)", arg))
+		return
+	}
+	m.Messages[index-1].Pinned = pinned
+	if pinned {
+		m.Println(fmt.Sprintf("Pinned message %d, squashing will keep it", index))
+	} else {
+		m.Println(fmt.Sprintf("Unpinned message %d", index))
+	}
+}
+
+// confirmSettings maps /confirm action names to their config keys
+var confirmSettings = []struct {
+	action string
+	key    string
+}{
+	{"exec", "exec_confirm"},
+	{"keys", "send_keys_confirm"},
+	{"paste", "paste_multiline_confirm"},
+}
+
+// setConfirm shows or toggles confirmation for an action type via session overrides
+func (m *Manager) setConfirm(args []string) {
+	state := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	current := map[string]bool{
+		"exec":  m.GetExecConfirm(),
+		"keys":  m.GetSendKeysConfirm(),
+		"paste": m.GetPasteMultilineConfirm(),
+	}
+
+	if len(args) == 0 {
+		for _, setting := range confirmSettings {
+			m.Println(fmt.Sprintf("%-6s %s", setting.action, state(current[setting.action])))
+		}
+		return
+	}
+
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		m.Println("Usage: /confirm [exec|keys|paste] [on|off]")
+		return
+	}
+
+	for _, setting := range confirmSettings {
+		if setting.action == args[0] {
+			m.SessionOverrides[setting.key] = args[1] == "on"
+			m.Println(fmt.Sprintf("Confirmation for %s is %s", setting.action, args[1]))
+			return
+		}
+	}
+	m.Println(fmt.Sprintf("Unknown action '%s'. Use exec, keys or paste", args[0]))
+}
+
+// snapshotExecPane stores the exec pane content for /diffpane-since
+func (m *Manager) snapshotExecPane() {
+	if m.ExecPane == nil || m.ExecPane.Id == "" {
+		return
+	}
+	content, err := system.TmuxCapturePane(m.ExecPane.Id, m.GetMaxCaptureLines())
+	if err != nil {
+		logger.Error("Failed to snapshot exec pane: %v", err)
+		return
+	}
+	m.LastTurnSnapshot = m.redactSecrets(content)
+}
+
+// diffPaneSinceLastTurn prints what changed in the exec pane since the last turn's snapshot
+func (m *Manager) diffPaneSinceLastTurn() {
+	if m.LastTurnSnapshot == "" {
+		m.Println("No exec pane snapshot yet, it is taken at the end of each AI turn")
+		return
+	}
+	current, err := system.TmuxCapturePane(m.ExecPane.Id, m.GetMaxCaptureLines())
+	if err != nil {
+		m.Println(fmt.Sprintf("Failed to capture exec pane: %v", err))
+		return
+	}
+
+	diff := diffLines(m.LastTurnSnapshot, current)
+	if len(diff) == 0 {
+		m.Println("No changes in the exec pane since the last turn")
+		return
+	}
+	code, _ := system.HighlightCode("diff", strings.Join(diff, "\n"))
+	fmt.Println(code)
+}
+
+// execPaneJobs returns the processes running under the exec pane's shell
+func (m *Manager) execPaneJobs() ([]system.ProcessInfo, error) {
+	if m.ExecPane == nil || m.ExecPane.Id == "" {
+		return nil, fmt.Errorf("no exec pane")
+	}
+	panes, err := system.TmuxPanesDetails(m.ExecPane.Id)
+	if err != nil || len(panes) == 0 {
+		return nil, fmt.Errorf("failed to get exec pane details: %v", err)
+	}
+	return system.PaneJobs(panes[0].CurrentPid)
+}
+
+// listJobs prints the processes running in the exec pane
+func (m *Manager) listJobs() {
+	jobs, err := m.execPaneJobs()
+	if err != nil {
+		m.Println(fmt.Sprintf("Unable to list jobs: %v", err))
+		return
+	}
+	if len(jobs) == 0 {
+		m.Println("No processes running in the exec pane")
+		return
+	}
+	for _, job := range jobs {
+		m.Println(fmt.Sprintf("%d  %s", job.Pid, job.Command))
+	}
+}
+
+// killJob terminates a process, refusing pids that don't belong to the exec pane
+func (m *Manager) killJob(arg string) {
+	pid, err := strconv.Atoi(arg)
+	if err != nil {
+		m.Println(fmt.Sprintf("Invalid pid '%s'", arg))
+		return
+	}
+	jobs, err := m.execPaneJobs()
+	if err != nil {
+		m.Println(fmt.Sprintf("Unable to list jobs: %v", err))
+		return
+	}
+	for _, job := range jobs {
+		if job.Pid != pid {
+			continue
+		}
+		if err := system.KillProcess(pid); err != nil {
+			m.Println(fmt.Sprintf("Failed to kill %d: %v", pid, err))
+			return
+		}
+		m.Println(fmt.Sprintf("Sent SIGTERM to %d (%s)", pid, job.Command))
+		return
+	}
+	m.Println(fmt.Sprintf("Process %d is not running in the exec pane. See /jobs", pid))
+}
+
 // listPersonas lists all available personas
 func (m *Manager) listPersonas() {
 	m.Println("Available personas:")
@@ -244,6 +733,98 @@ func (m *Manager) switchPersona(name string) {
 	}
 }
 
+// formatConfigKeys prints every allowed config key with its effective value
+func (m *Manager) formatConfigKeys() {
+	formatter := system.NewInfoFormatter()
+	labelWidth := 0
+	for _, key := range AllowedConfigKeys {
+		if len(key) > labelWidth {
+			labelWidth = len(key)
+		}
+	}
+
+	fmt.Println(formatter.FormatSection("\nConfiguration"))
+	for _, key := range AllowedConfigKeys {
+		value, _ := m.GetConfigValue(key)
+		fmt.Print(formatter.LabelColor.Sprintf("%-*s", labelWidth, key))
+		fmt.Print("  ")
+		fmt.Println(value)
+	}
+}
+
+// formatEnv prints what was detected about the exec pane's environment, the same details the AI gets
+func (m *Manager) formatEnv() {
+	formatter := system.NewInfoFormatter()
+	const labelWidth = 18
+	formatLine := func(key string, value any) {
+		fmt.Print(formatter.LabelColor.Sprintf("%-*s", labelWidth, key))
+		fmt.Print("  ")
+		fmt.Println(value)
+	}
+	orUnknown := func(value string) string {
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	}
+
+	cwd, err := system.TmuxPaneCurrentPath(m.ExecPane.Id)
+	if err != nil {
+		logger.Debug("Exec pane working directory unavailable: %v", err)
+	}
+	paneOS := m.ExecPane.OS
+	if paneOS == "" {
+		paneOS = m.OS
+	}
+
+	fmt.Println(formatter.FormatSection("\nExec Pane"))
+	formatLine("Pane", orUnknown(m.ExecPane.Id))
+	formatLine("Shell", orUnknown(m.ExecPane.Shell))
+	formatLine("Command", orUnknown(m.ExecPane.CurrentCommand))
+	formatLine("OS", orUnknown(paneOS))
+	formatLine("Distro", orUnknown(m.ExecPane.Distro))
+	formatLine("Working Dir", orUnknown(cwd))
+	formatLine("Prepared", m.ExecPane.IsPrepared)
+	formatLine("Subshell", m.ExecPane.IsSubShell)
+}
+
+// formatStats prints local session statistics
+func (m *Manager) formatStats() {
+	formatter := system.NewInfoFormatter()
+	const labelWidth = 18
+	formatLine := func(key string, value any) {
+		fmt.Print(formatter.LabelColor.Sprintf("%-*s", labelWidth, key))
+		fmt.Print("  ")
+		fmt.Println(value)
+	}
+
+	succeeded, failed := 0, 0
+	for _, history := range m.ExecHistory {
+		switch {
+		case history.Code == 0:
+			succeeded++
+		case history.Code > 0:
+			failed++
+		}
+	}
+
+	elapsed := time.Duration(0)
+	if !m.StartedAt.IsZero() {
+		elapsed = time.Since(m.StartedAt).Round(time.Second)
+	}
+
+	fmt.Println(formatter.FormatSection("\nSession"))
+	formatLine("Elapsed", elapsed)
+	formatLine("Turns", m.Turns)
+	formatLine("Commands", len(m.ExecHistory))
+	formatLine("Succeeded", succeeded)
+	formatLine("Failed", failed)
+	formatLine("Tokens", m.Usage.TotalTokens)
+	formatLine("Prompt Tokens", m.Usage.PromptTokens)
+	formatLine("Output Tokens", m.Usage.CompletionTokens)
+	formatLine("Cost~", fmt.Sprintf("$%.4f", m.SessionCost()))
+}
+
 // formats system information and tmux details into a readable string
 func (m *Manager) formatInfo() {
 	formatter := system.NewInfoFormatter()
@@ -264,7 +845,7 @@ func (m *Manager) formatInfo() {
 	formatLine("Messages", len(m.Messages))
 	var totalTokens int
 	for _, msg := range m.Messages {
-		totalTokens += system.EstimateTokenCount(msg.Content)
+		totalTokens += m.countTokens(msg.Content)
 	}
 
 	usagePercent := 0.0
@@ -276,6 +857,7 @@ func (m *Manager) formatInfo() {
 	fmt.Printf("%s\n", fmt.Sprintf("%d tokens", totalTokens))
 	fmt.Printf("%-*s  %s\n", labelWidth, "", formatter.FormatProgressBar(usagePercent, 10))
 	formatLine("Max Size", fmt.Sprintf("%d tokens", m.GetMaxContextSize()))
+	formatLine("Tokenizer", m.activeTokenizer())
 
 	// Display tmux panes section
 	fmt.Println()
@@ -283,7 +865,20 @@ func (m *Manager) formatInfo() {
 
 	panes, _ := m.GetTmuxPanes()
 	for _, pane := range panes {
-		pane.Refresh(m.GetMaxCaptureLines())
+		m.refreshPane(&pane, m.GetMaxCaptureLines())
 		fmt.Println(pane.FormatInfo(formatter))
 	}
 }
+
+// argsAfter returns the rest of command after its first n words, as typed
+func argsAfter(command string, n int) string {
+	rest := strings.TrimSpace(command)
+	for i := 0; i < n && rest != ""; i++ {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end == -1 {
+			return ""
+		}
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return rest
+}
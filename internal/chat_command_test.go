@@ -1,8 +1,15 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alvinunreal/tmuxai/config"
 	"github.com/alvinunreal/tmuxai/system"
@@ -12,7 +19,7 @@ import (
 // Test /prepare command behavior with subshell
 func TestProcessSubCommand_PrepareSubshell(t *testing.T) {
 	manager := &Manager{
-		Config:           &config.Config{MaxCaptureLines: 1000},
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
 		SessionOverrides: make(map[string]any),
 		Messages:         []ChatMessage{},
 		ExecPane: &system.TmuxPaneDetails{
@@ -87,7 +94,7 @@ func TestProcessSubCommand_PrepareSubshell(t *testing.T) {
 // Test /prepare command behavior with normal shell (not subshell)
 func TestProcessSubCommand_PrepareNormalShell(t *testing.T) {
 	manager := &Manager{
-		Config:           &config.Config{MaxCaptureLines: 1000},
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
 		SessionOverrides: make(map[string]any),
 		Messages:         []ChatMessage{},
 		ExecPane: &system.TmuxPaneDetails{
@@ -167,3 +174,530 @@ func TestIsMessageSubcommand(t *testing.T) {
 		assert.Equal(t, tc.expected, result, tc.desc)
 	}
 }
+
+// captureOutput runs fn and returns everything it wrote to stdout
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	original := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = original }()
+
+	done := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		done <- buf.String()
+	}()
+
+	fn()
+	_ = w.Close()
+	return <-done
+}
+
+// Test /config get without a key dumps every allowed key
+func TestProcessSubCommand_ConfigGetAll(t *testing.T) {
+	manager := &Manager{
+		Config: &config.Config{
+			MaxCaptureLines: 200,
+			MaxContextSize:  100000,
+			WaitInterval:    5,
+			ExecConfirm:     true,
+			OpenRouter:      config.OpenRouterConfig{Model: "test-model"},
+		},
+		SessionOverrides: map[string]any{"wait_interval": 10},
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/config get")
+	})
+
+	for _, key := range AllowedConfigKeys {
+		assert.Contains(t, output, key, "Output should list every allowed key")
+	}
+	assert.Regexp(t, `max_capture_lines\s+200`, output)
+	assert.Regexp(t, `wait_interval\s+10`, output, "Session override should be shown as effective value")
+	assert.Regexp(t, `openrouter.model\s+test-model`, output)
+
+	output = captureOutput(t, func() {
+		manager.ProcessSubCommand("/config get max_context_size")
+	})
+	assert.Contains(t, output, "max_context_size = 100000")
+}
+
+// Test /window scopes pane capture to the given window target
+func TestProcessSubCommand_Window(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]any),
+		PaneId:           "%0",
+		ExecPane:         &system.TmuxPaneDetails{Id: "%1"},
+	}
+
+	originalTmuxCurrentPaneId := system.TmuxCurrentPaneId
+	originalTmuxPanesDetails := system.TmuxPanesDetails
+	defer func() {
+		system.TmuxCurrentPaneId = originalTmuxCurrentPaneId
+		system.TmuxPanesDetails = originalTmuxPanesDetails
+	}()
+
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "%0", nil
+	}
+	var targets []string
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		targets = append(targets, windowTarget)
+		return []system.TmuxPaneDetails{{Id: "%7"}}, nil
+	}
+
+	manager.ProcessSubCommand("/window Work:2")
+
+	assert.Equal(t, "Work:2", manager.WindowTarget, "Window target should keep its case")
+	assert.Equal(t, "%7", manager.ExecPane.Id, "Exec pane should be re-picked from the new window")
+
+	targets = nil
+	_, _ = manager.GetTmuxPanes()
+	assert.Equal(t, []string{"Work:2"}, targets, "Panes should be listed from the scoped window")
+
+	manager.ProcessSubCommand("/window current")
+	assert.Equal(t, "", manager.WindowTarget)
+}
+
+// Test /stats aggregates exec history and usage
+func TestProcessSubCommand_Stats(t *testing.T) {
+	manager := &Manager{
+		Config:           config.DefaultConfig(),
+		SessionOverrides: make(map[string]any),
+		Turns:            4,
+		Usage:            Usage{PromptTokens: 1000, CompletionTokens: 234, TotalTokens: 1234},
+		StartedAt:        time.Now().Add(-90 * time.Second),
+		ExecHistory: []CommandExecHistory{
+			{Command: "ls", Code: 0},
+			{Command: "make", Code: 2},
+			{Command: "go test", Code: 0},
+			{Command: "sleep 100", Code: -1},
+		},
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/stats")
+	})
+
+	assert.Regexp(t, `Turns\s+4`, output)
+	assert.Regexp(t, `Commands\s+4`, output)
+	assert.Regexp(t, `Succeeded\s+2`, output)
+	assert.Regexp(t, `Failed\s+1`, output)
+	assert.Regexp(t, `Tokens\s+1234`, output)
+	assert.Regexp(t, `Elapsed\s+1m3\ds`, output)
+}
+
+func TestProcessSubCommand_Reload(t *testing.T) {
+	original := loadConfig
+	defer func() { loadConfig = original }()
+
+	initial := config.DefaultConfig()
+	initial.OpenRouter.Model = "old-model"
+	client := NewAiClient(initial)
+	manager := &Manager{
+		Config:           initial,
+		AiClient:         client,
+		CurrentPersona:   "pair_programmer",
+		SessionOverrides: map[string]any{"max_capture_lines": 50},
+		Messages:         []ChatMessage{{Content: "hello", FromUser: true}},
+	}
+
+	loadConfig = func() (*config.Config, error) {
+		cfg := config.DefaultConfig()
+		cfg.OpenRouter.Model = "new-model"
+		return cfg, nil
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/reload")
+	})
+
+	assert.Contains(t, output, "Config reloaded")
+	assert.Equal(t, "new-model", manager.GetOpenRouterModel())
+	assert.NotSame(t, client, manager.AiClient, "provider change should rebuild the AI client")
+	assert.Equal(t, 50, manager.GetMaxCaptureLines())
+	assert.Len(t, manager.Messages, 1)
+	assert.Equal(t, "pair_programmer", manager.CurrentPersona)
+
+	// without a provider change the client is kept but reads the new settings
+	rebuilt := manager.AiClient
+	loadConfig = func() (*config.Config, error) {
+		cfg := config.DefaultConfig()
+		cfg.OpenRouter.Model = "new-model"
+		cfg.RedactSecrets = !manager.Config.RedactSecrets
+		return cfg, nil
+	}
+	captureOutput(t, func() {
+		manager.ProcessSubCommand("/reload")
+	})
+	assert.Same(t, rebuilt, manager.AiClient)
+	assert.Same(t, manager.Config, manager.AiClient.config, "redact_secrets and the like apply after a reload")
+}
+
+func TestProcessSubCommand_DiffPaneSince(t *testing.T) {
+	server := newMockAiServer(t, "<RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+
+	originalHighlight := system.HighlightCode
+	defer func() { system.HighlightCode = originalHighlight }()
+	system.HighlightCode = func(language string, code string) (string, error) {
+		return code, nil
+	}
+
+	capture := "$ make\nbuilding...\n"
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return capture, nil
+	}
+
+	cli := NewCLIInterface(manager)
+	cli.processInput("build it")
+	assert.Equal(t, "$ make\nbuilding...\n", manager.LastTurnSnapshot)
+
+	capture = "$ make\nbuilding...\nerror: missing header\n$ "
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/diffpane-since")
+	})
+	assert.Contains(t, output, "+ error: missing header\n+ $ ")
+	assert.NotContains(t, output, "building")
+}
+
+func TestPrintWelcomeMessage(t *testing.T) {
+	original := firstTurnFlagPath
+	defer func() { firstTurnFlagPath = original }()
+	flagPath := filepath.Join(t.TempDir(), "first_turn_done")
+	firstTurnFlagPath = func() string { return flagPath }
+
+	cfg := config.DefaultConfig()
+	cfg.WelcomeMessage = "Welcome back, happy hacking"
+	cli := NewCLIInterface(&Manager{Config: cfg})
+
+	output := captureOutput(t, cli.printWelcomeMessage)
+	assert.Contains(t, output, "Welcome back, happy hacking")
+	assert.Contains(t, output, "/prepare", "Tips are shown before the first turn")
+
+	markFirstTurnDone()
+	output = captureOutput(t, cli.printWelcomeMessage)
+	assert.Contains(t, output, "Welcome back, happy hacking")
+	assert.NotContains(t, output, "Tips:")
+
+	cfg.WelcomeMessage = ""
+	output = captureOutput(t, cli.printWelcomeMessage)
+	assert.Equal(t, "\n", output)
+}
+
+func TestGetPrompt_PromptFormat(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.OpenRouter.Model = "test-model"
+	manager := &Manager{
+		Config:           cfg,
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{Shell: "zsh"},
+	}
+	assert.Contains(t, manager.GetPrompt(), "TmuxAI", "Without prompt_format the default prompt is kept")
+
+	cfg.PromptFormat = "{model} [{mode}/{shell}]{state}> "
+	assert.Equal(t, "test-model [observe/zsh]> ", manager.GetPrompt())
+
+	manager.ExecPane.IsPrepared = true
+	manager.Status = "running"
+	manager.SessionOverrides["openrouter.model"] = "other-model"
+	assert.Equal(t, "other-model [prepared/zsh]▶> ", manager.GetPrompt())
+
+	manager.WatchMode = true
+	assert.Equal(t, "other-model [watch/zsh]∞> ", manager.GetPrompt())
+}
+
+func TestProcessSubCommand_Confirm(t *testing.T) {
+	manager := &Manager{
+		Config:           config.DefaultConfig(),
+		SessionOverrides: make(map[string]any),
+	}
+
+	assert.True(t, manager.GetExecConfirm())
+	manager.ProcessSubCommand("/confirm exec off")
+	assert.False(t, manager.GetExecConfirm())
+	assert.True(t, manager.GetSendKeysConfirm(), "Other actions are untouched")
+
+	manager.ProcessSubCommand("/confirm paste off")
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/confirm")
+	})
+	assert.Regexp(t, `exec\s+off`, output)
+	assert.Regexp(t, `keys\s+on`, output)
+	assert.Regexp(t, `paste\s+off`, output)
+}
+
+func TestProcessSubCommand_Last(t *testing.T) {
+	pane := "<current_tmux_window_state>\n<tmuxai_exec_pane>\n - Id: %1\n</tmuxai_exec_pane>\n</current_tmux_window_state>\n\nKeep in mind, you are working within the shell: bash and OS: linux\n\n"
+	manager := &Manager{
+		Config:           config.DefaultConfig(),
+		SessionOverrides: make(map[string]any),
+		Messages: []ChatMessage{
+			{Content: pane + "first question", FromUser: true},
+			{Content: "first answer", FromUser: false},
+			{Content: pane + "second question", FromUser: true},
+			{Content: "second answer <ExecCommand>ls</ExecCommand>", FromUser: false},
+			{Content: pane + "third question", FromUser: true},
+			{Content: "third answer", FromUser: false},
+		},
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/last 2")
+	})
+
+	assert.Equal(t, "you: second question\nai:  second answer <ExecCommand>ls</ExecCommand>\nyou: third question\nai:  third answer\n", output)
+
+	// long messages are cut on characters, not bytes
+	long := compactMessage(strings.Repeat("é", lastExchangeMaxLen+10))
+	assert.True(t, utf8.ValidString(long))
+	assert.Equal(t, strings.Repeat("é", lastExchangeMaxLen-3)+"...", long)
+}
+
+func TestProcessSubCommand_ImportHistory(t *testing.T) {
+	manager := &Manager{Config: config.DefaultConfig(), Messages: []ChatMessage{}}
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/import-history " + filepath.Join("testdata", "import", "session.md"))
+	})
+	assert.Contains(t, output, "Imported 3 messages")
+
+	if assert.Len(t, manager.Messages, 3) {
+		assert.Equal(t, ChatMessage{Content: "Why does the build fail?", FromUser: true,
+			Timestamp: time.Date(2026, 10, 1, 9, 30, 0, 0, time.Local)}, manager.Messages[0])
+		assert.False(t, manager.Messages[1].FromUser)
+		assert.Equal(t, "The linker can't find libssl. Let's check it's installed:\n\n<ExecCommand>ldconfig -p | grep libssl</ExecCommand>", manager.Messages[1].Content)
+		assert.Equal(t, "It's not there", manager.Messages[2].Content)
+	}
+}
+
+func TestParseImportedHistory_Plain(t *testing.T) {
+	messages, err := parseImportedHistory(strings.NewReader("notes before\nUser: list files\nAssistant: Listing.\n<ExecCommand>ls</ExecCommand>\nuser: thanks\n"))
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 3) {
+		assert.True(t, messages[0].FromUser)
+		assert.Equal(t, "list files", messages[0].Content)
+		assert.Equal(t, "Listing.\n<ExecCommand>ls</ExecCommand>", messages[1].Content)
+		assert.Equal(t, "thanks", messages[2].Content)
+	}
+
+	_, err = parseImportedHistory(strings.NewReader("just some notes\n"))
+	assert.Error(t, err)
+
+	// headings and prefixes inside a fenced block are part of the message
+	messages, err = parseImportedHistory(strings.NewReader("## User\nformat this\n```md\n## Assistant\nUser: quoted\n```\n## Assistant\nDone.\n"))
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "format this\n```md\n## Assistant\nUser: quoted\n```", messages[0].Content)
+		assert.Equal(t, "Done.", messages[1].Content)
+	}
+}
+
+func TestProcessSubCommand_Export(t *testing.T) {
+	asked := time.Date(2026, 10, 1, 9, 30, 0, 0, time.Local)
+	manager := &Manager{Config: config.DefaultConfig(), Messages: []ChatMessage{
+		{Content: "<current_tmux_window_state>\n\x1b[31merror\x1b[0m: libssl not found\n</current_tmux_window_state>\n\nWhy does the build fail?", FromUser: true, Timestamp: asked},
+		{Content: "The linker can't find libssl.\n<ExecCommand desc=\"find libssl\">ldconfig -p | grep libssl</ExecCommand>", Timestamp: asked.Add(4 * time.Second)},
+	}}
+	path := filepath.Join(t.TempDir(), "Session.md")
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/export " + path) })
+	assert.Contains(t, output, "Exported 2 messages to "+path)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	exported := string(data)
+	assert.Equal(t, "# TmuxAI session\n\n"+
+		"## User (2026-10-01 09:30:00)\n\n```xml\n<current_tmux_window_state>\nerror: libssl not found\n</current_tmux_window_state>\n```\n\nWhy does the build fail?\n\n"+
+		"## Assistant (2026-10-01 09:30:04)\n\nThe linker can't find libssl.\n\n```sh\nldconfig -p | grep libssl\n```\n", exported)
+
+	// the export reads back with /import-history
+	messages, err := parseImportedHistory(strings.NewReader(exported))
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, asked, messages[0].Timestamp)
+		assert.False(t, messages[1].FromUser)
+	}
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/export " + filepath.Join(path, "nested.md")) })
+	assert.Contains(t, output, "Failed to export to")
+}
+
+func TestProcessSubCommand_SaveLoad(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	originalTmuxPanesDetails := system.TmuxPanesDetails
+	defer func() { system.TmuxPanesDetails = originalTmuxPanesDetails }()
+	livePanes := map[string]bool{"%3": true}
+	system.TmuxPanesDetails = func(target string) ([]system.TmuxPaneDetails, error) {
+		if !livePanes[target] {
+			return nil, fmt.Errorf("can't find pane: %s", target)
+		}
+		return []system.TmuxPaneDetails{{Id: target, CurrentCommand: "zsh"}}, nil
+	}
+
+	saved := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	manager := &Manager{
+		Config:           config.DefaultConfig(),
+		Messages:         []ChatMessage{{Content: "list files", FromUser: true, Timestamp: saved}, {Content: "<ExecCommand>ls</ExecCommand>", Timestamp: saved}},
+		ExecHistory:      []CommandExecHistory{{Command: "ls", Output: "main.go", Code: 0}},
+		ExecPane:         &system.TmuxPaneDetails{Id: "%3", Shell: "zsh", IsPrepared: true},
+		SessionOverrides: map[string]any{"max_capture_lines": 50, "openrouter.model": "Big/Model", "squash_threshold": 9000},
+	}
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/save Work-1") })
+	assert.Contains(t, output, "Saved session 'Work-1' (2 messages)")
+	assert.Equal(t, []string{"Work-1"}, savedSessionNames())
+
+	restored := &Manager{
+		Config:           config.DefaultConfig(),
+		Messages:         []ChatMessage{},
+		ExecPane:         &system.TmuxPaneDetails{Id: "%1"},
+		SessionOverrides: map[string]any{},
+	}
+	output = captureOutput(t, func() { restored.ProcessSubCommand("/load Work-1") })
+	assert.Contains(t, output, "Loaded session 'Work-1'")
+	assert.Equal(t, manager.Messages[0].Content, restored.Messages[0].Content)
+	assert.True(t, saved.Equal(restored.Messages[0].Timestamp))
+	assert.Equal(t, manager.ExecHistory, restored.ExecHistory)
+	assert.Equal(t, 50, restored.GetMaxCaptureLines(), "Whole numbers are restored as ints")
+	assert.Equal(t, 9000, restored.GetSquashThreshold())
+	assert.Equal(t, "Big/Model", restored.GetOpenRouterModel())
+	assert.Equal(t, "%3", restored.ExecPane.Id)
+	assert.True(t, restored.ExecPane.IsPrepared)
+
+	// the saved exec pane was closed in the meantime
+	delete(livePanes, "%3")
+	restored.ExecPane = &system.TmuxPaneDetails{Id: "%1"}
+	output = captureOutput(t, func() { restored.ProcessSubCommand("/load Work-1") })
+	assert.Contains(t, output, "Warning: the saved exec pane %3 no longer exists, keeping exec pane %1")
+	assert.Equal(t, "%1", restored.ExecPane.Id)
+
+	output = captureOutput(t, func() { restored.ProcessSubCommand("/load missing") })
+	assert.Contains(t, output, "No saved session 'missing'")
+	output = captureOutput(t, func() { restored.ProcessSubCommand("/save ../escape") })
+	assert.Contains(t, output, "invalid session name")
+}
+
+func TestProcessSubCommand_Env(t *testing.T) {
+	originalTmuxPaneCurrentPath := system.TmuxPaneCurrentPath
+	defer func() { system.TmuxPaneCurrentPath = originalTmuxPaneCurrentPath }()
+	system.TmuxPaneCurrentPath = func(paneId string) (string, error) {
+		return "/home/user/project", nil
+	}
+
+	manager := &Manager{
+		Config:           config.DefaultConfig(),
+		SessionOverrides: map[string]any{},
+		OS:               "darwin/arm64",
+		ExecPane:         &system.TmuxPaneDetails{Id: "%4", Shell: "fish", OS: "linux/amd64", Distro: "Ubuntu 24.04 LTS (ubuntu)", IsPrepared: true},
+	}
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/env") })
+
+	assert.Regexp(t, `Pane\s+%4`, output)
+	assert.Regexp(t, `Shell\s+fish`, output)
+	assert.Regexp(t, `OS\s+linux/amd64`, output)
+	assert.Regexp(t, `Distro\s+Ubuntu 24.04 LTS \(ubuntu\)`, output)
+	assert.Regexp(t, `Working Dir\s+/home/user/project`, output)
+	assert.Regexp(t, `Prepared\s+true`, output)
+}
+
+// Test /undo removes the last exchange and the command it ran, and is a no-op on an empty history
+func TestProcessSubCommand_Undo(t *testing.T) {
+	manager := &Manager{Config: config.DefaultConfig(), Messages: []ChatMessage{
+		{Content: "list files", FromUser: true},
+		{Content: "Listing. <ExecCommand>ls</ExecCommand>"},
+		{Content: "now remove the build dir", FromUser: true},
+		{Content: "Removing it. <ExecCommand>rm -rf buidl</ExecCommand>"},
+	}, ExecHistory: []CommandExecHistory{{Command: "ls"}, {Command: "rm -rf buidl", Code: 0}}}
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/undo") })
+	assert.Len(t, manager.Messages, 2)
+	assert.Equal(t, "list files", manager.Messages[0].Content)
+	assert.Equal(t, []CommandExecHistory{{Command: "ls"}}, manager.ExecHistory)
+	assert.Contains(t, output, "Removed you: now remove the build dir")
+	assert.Contains(t, output, "Removed ai:  Removing it.")
+
+	// the exec history entry stays when the command isn't from the removed response
+	manager.ExecHistory = []CommandExecHistory{{Command: "make"}}
+	captureOutput(t, func() { manager.ProcessSubCommand("/undo") })
+	assert.Empty(t, manager.Messages)
+	assert.Len(t, manager.ExecHistory, 1)
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/undo") })
+	assert.Contains(t, output, "Nothing to undo, the chat history is empty")
+	assert.Empty(t, manager.Messages)
+}
+
+// Test /mode switches between observe and prepared mode without clearing the chat history
+func TestProcessSubCommand_Mode(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]any),
+		Messages:         []ChatMessage{{Content: "why does the build fail?", FromUser: true}, {Content: "Let me check."}},
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane", CurrentCommand: "bash"},
+	}
+
+	restoreTmuxMocks(t)
+
+	prompt := "user@host:~$ "
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		switch {
+		case strings.Contains(command, "export PS1="):
+			prompt = "user@host:~[10:00][0]» "
+		case strings.Contains(command, "$env.PROMPT_COMMAND = {||"):
+			prompt = "user@host:~[10:00][0]» "
+		case strings.HasPrefix(command, "PS1=${TMUXAI_PS1-$PS1}"), command == nuPromptRestoreCommand:
+			prompt = "user@host:~$ "
+		}
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return prompt, nil
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "main-pane", nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "main-pane"}, {Id: "test-pane", CurrentCommand: "bash"}}, nil
+	}
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/mode") })
+	assert.Contains(t, output, "Mode: observe")
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/mode prepared bash") })
+	assert.True(t, manager.ExecPane.IsPrepared)
+	assert.Contains(t, output, "Mode: prepared (bash)")
+	if assert.NotEmpty(t, commandsSent) {
+		assert.Contains(t, commandsSent[0], "TMUXAI_PS1=${TMUXAI_PS1-$PS1}; export PS1=", "The shell's own prompt should be kept")
+	}
+	assert.Len(t, manager.Messages, 2, "Switching modes shouldn't clear the chat history")
+
+	commandsSent = nil
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/mode observe") })
+	assert.Equal(t, []string{"PS1=${TMUXAI_PS1-$PS1}; unset TMUXAI_PS1"}, commandsSent)
+	assert.False(t, manager.ExecPane.IsPrepared)
+	assert.Contains(t, output, "Mode: observe")
+	assert.Len(t, manager.Messages, 2)
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/mode prepared tcsh") })
+	assert.Contains(t, output, "Shell 'tcsh' is not supported")
+
+	// nushell's prompt closures are saved and restored too
+	commandsSent = nil
+	captureOutput(t, func() { manager.ProcessSubCommand("/mode prepared nu") })
+	assert.True(t, manager.ExecPane.IsPrepared)
+	if assert.NotEmpty(t, commandsSent) {
+		assert.True(t, strings.HasPrefix(commandsSent[0], nuPromptSaveCommand))
+	}
+	commandsSent = nil
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/mode observe") })
+	assert.Equal(t, []string{nuPromptRestoreCommand}, commandsSent)
+	assert.Contains(t, output, "Mode: observe")
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// commandLogPath returns command_log_file with a leading ~ expanded, or "" when disabled
+func (m *Manager) commandLogPath() string {
+	path := m.Config.CommandLogFile
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// logExecutedCommand appends an executed command to command_log_file so the session can be replayed
+// as a shell script. The first command of each turn is preceded by a comment with the user's request.
+func (m *Manager) logExecutedCommand(command string) {
+	path := m.commandLogPath()
+	if path == "" {
+		m.auditEntry(command)
+		return
+	}
+
+	var entry strings.Builder
+	if len(m.turnCommands) == 0 {
+		entry.WriteString(fmt.Sprintf("\n# %s %s\n", time.Now().Format("2006-01-02 15:04:05"), compactMessage(m.turnRequest)))
+	}
+	if audit := m.auditEntry(command); audit != "" {
+		entry.WriteString("# " + audit + "\n")
+	}
+	entry.WriteString(command + "\n")
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logger.Error("Failed to open command log %s: %v", path, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString(entry.String()); err != nil {
+		logger.Error("Failed to write command log %s: %v", path, err)
+	}
+}
+
+// auditEntry returns the audit line for a command confirmation approved without a prompt, because it
+// matched whitelist_patterns or was approved for the session, prints and logs it, when audit_approved_commands
+// is on. It returns "" for any other command.
+func (m *Manager) auditEntry(command string) string {
+	approved := m.approvedWithoutPrompt
+	m.approvedWithoutPrompt = ""
+	if !m.Config.AuditApprovedCommands || approved == "" {
+		return ""
+	}
+	if approved == approvedByWhitelist {
+		m.Println("Approved by whitelist: " + command)
+	} else {
+		m.Println("Approved for this session: " + command)
+	}
+	entry := fmt.Sprintf("audit %s turn %d %s: %s", time.Now().Format(time.RFC3339), m.Turns, approved, m.redactForDebug(command))
+	logger.Info("%s", entry)
+	return entry
+}
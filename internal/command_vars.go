@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// commandVarRef matches ${name} references to outputs stored with <ExecCommand var="name">
+var commandVarRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// commandVarName is what a var attribute must look like to be referenced later
+var commandVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// storeCommandVar keeps the output of a command under name for this session.
+// Names of environment variables like HOME or PATH are refused, ${HOME} keeps meaning the shell's.
+func (m *Manager) storeCommandVar(name string, output string) bool {
+	if !commandVarName.MatchString(name) {
+		return false
+	}
+	if _, isEnv := os.LookupEnv(name); isEnv {
+		return false
+	}
+	if m.commandVars == nil {
+		m.commandVars = make(map[string]string)
+	}
+	m.commandVars[name] = strings.TrimSpace(output)
+	return true
+}
+
+// substituteCommandVars replaces ${name} with stored outputs, references to anything else, e.g. shell variables, stay as they are.
+// Each line of the output becomes one quoted word, so nothing in it is run or expanded by the shell.
+func (m *Manager) substituteCommandVars(command string) string {
+	if len(m.commandVars) == 0 {
+		return command
+	}
+	return commandVarRef.ReplaceAllStringFunc(command, func(ref string) string {
+		name := commandVarRef.FindStringSubmatch(ref)[1]
+		if value, ok := m.commandVars[name]; ok {
+			var words []string
+			for _, line := range strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n") {
+				if line != "" {
+					words = append(words, shellQuote(m.ExecPane.Shell, line))
+				}
+			}
+			return strings.Join(words, " ")
+		}
+		return ref
+	})
+}
+
+// shellQuote single-quotes s for shell, PowerShell escapes quotes by doubling them, the others by closing the quote
+func shellQuote(shell string, s string) string {
+	if shell == "pwsh" {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// commandVarsContext lists the stored variables for the next message to the AI
+func (m *Manager) commandVarsContext() string {
+	if len(m.commandVars) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(m.commandVars))
+	for name := range m.commandVars {
+		names = append(names, "${"+name+"}")
+	}
+	sort.Strings(names)
+	return "Stored command outputs you can reference in commands: " + strings.Join(names, ", ")
+}
@@ -1,9 +1,13 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
 )
 
 // AllowedConfigKeys defines the list of configuration keys that users are allowed to modify
@@ -11,10 +15,18 @@ var AllowedConfigKeys = []string{
 	"max_capture_lines",
 	"max_context_size",
 	"wait_interval",
+	"turn_deadline_sec",
 	"send_keys_confirm",
 	"paste_multiline_confirm",
 	"exec_confirm",
 	"openrouter.model",
+	"max_session_cost_usd",
+	"strip_pane_context",
+	"confirm_once_per_type",
+	"require_verification",
+	"exec_prompt_regex",
+	"summary_model",
+	"blocked_command_patterns",
 }
 
 // GetMaxCaptureLines returns the max capture lines value with session override if present
@@ -27,16 +39,56 @@ func (m *Manager) GetMaxCaptureLines() int {
 	return m.Config.MaxCaptureLines
 }
 
-// GetMaxContextSize returns the max context size value with session override if present
+// GetMaxContextSize returns the max context size value with session override if present,
+// lowered to the active model's context window when that is smaller
 func (m *Manager) GetMaxContextSize() int {
 	if override, exists := m.SessionOverrides["max_context_size"]; exists {
 		if val, ok := override.(int); ok {
 			return val
 		}
 	}
+	if window := m.modelContextWindow(m.GetOpenRouterModel()); window > 0 && window < m.Config.MaxContextSize {
+		return window
+	}
 	return m.Config.MaxContextSize
 }
 
+// modelContextWindow returns the model's context window from model_context_windows,
+// or as fetched from the provider, 0 when unknown
+func (m *Manager) modelContextWindow(model string) int {
+	if window, ok := m.Config.ModelContextWindows[model]; ok {
+		return window
+	}
+	m.contextWindowsMu.Lock()
+	defer m.contextWindowsMu.Unlock()
+	return m.ModelContextWindows[model]
+}
+
+// fetchModelContextWindows asks the provider for the models' context windows, off the startup path
+func (m *Manager) fetchModelContextWindows(client *AiClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	windows, err := client.ModelContextWindows(ctx)
+	if err != nil {
+		logger.Debug("Model context windows unavailable: %v", err)
+		return
+	}
+	m.contextWindowsMu.Lock()
+	m.ModelContextWindows = windows
+	m.contextWindowsMu.Unlock()
+}
+
+// GetSquashThreshold returns the context size in tokens above which history is squashed,
+// 80% of max_context_size unless changed with /squash threshold
+func (m *Manager) GetSquashThreshold() int {
+	if override, exists := m.SessionOverrides["squash_threshold"]; exists {
+		if val, ok := override.(int); ok {
+			return val
+		}
+	}
+	return int(float64(m.GetMaxContextSize()) * 0.8)
+}
+
 // GetWaitInterval returns the wait interval value with session override if present
 func (m *Manager) GetWaitInterval() int {
 	if override, exists := m.SessionOverrides["wait_interval"]; exists {
@@ -47,6 +99,16 @@ func (m *Manager) GetWaitInterval() int {
 	return m.Config.WaitInterval
 }
 
+// GetTurnDeadlineSec returns the per-request deadline in seconds with session override if present
+func (m *Manager) GetTurnDeadlineSec() int {
+	if override, exists := m.SessionOverrides["turn_deadline_sec"]; exists {
+		if val, ok := override.(int); ok {
+			return val
+		}
+	}
+	return m.Config.TurnDeadlineSec
+}
+
 func (m *Manager) GetSendKeysConfirm() bool {
 	if override, exists := m.SessionOverrides["send_keys_confirm"]; exists {
 		if val, ok := override.(bool); ok {
@@ -74,6 +136,56 @@ func (m *Manager) GetExecConfirm() bool {
 	return m.Config.ExecConfirm
 }
 
+func (m *Manager) GetStripPaneContext() bool {
+	if override, exists := m.SessionOverrides["strip_pane_context"]; exists {
+		if val, ok := override.(bool); ok {
+			return val
+		}
+	}
+	return m.Config.StripPaneContext
+}
+
+func (m *Manager) GetConfirmOncePerType() bool {
+	if override, exists := m.SessionOverrides["confirm_once_per_type"]; exists {
+		if val, ok := override.(bool); ok {
+			return val
+		}
+	}
+	return m.Config.ConfirmOncePerType
+}
+
+func (m *Manager) GetRequireVerification() bool {
+	if override, exists := m.SessionOverrides["require_verification"]; exists {
+		if val, ok := override.(bool); ok {
+			return val
+		}
+	}
+	return m.Config.RequireVerification
+}
+
+// GetExecPromptRegex returns the exec pane prompt pattern with session override if present, empty means the default
+func (m *Manager) GetExecPromptRegex() string {
+	if override, exists := m.SessionOverrides["exec_prompt_regex"]; exists {
+		if val, ok := override.(string); ok {
+			return val
+		}
+	}
+	return m.Config.ExecPromptRegex
+}
+
+// GetSummaryModel returns the model squashing summarizes the history with, the chat model unless summary_model is set
+func (m *Manager) GetSummaryModel() string {
+	if override, exists := m.SessionOverrides["summary_model"]; exists {
+		if val, ok := override.(string); ok && val != "" {
+			return val
+		}
+	}
+	if m.Config.SummaryModel != "" {
+		return m.Config.SummaryModel
+	}
+	return m.GetOpenRouterModel()
+}
+
 func (m *Manager) GetOpenRouterModel() string {
 	if override, exists := m.SessionOverrides["openrouter.model"]; exists {
 		if val, ok := override.(string); ok {
@@ -83,6 +195,74 @@ func (m *Manager) GetOpenRouterModel() string {
 	return m.Config.OpenRouter.Model
 }
 
+func (m *Manager) GetMaxSessionCostUSD() float64 {
+	if override, exists := m.SessionOverrides["max_session_cost_usd"]; exists {
+		switch val := override.(type) {
+		case float64:
+			return val
+		case int:
+			return float64(val)
+		}
+	}
+	return m.Config.MaxSessionCostUSD
+}
+
+// GetBlockedCommandPatterns returns blocked_command_patterns with the patterns added this session
+func (m *Manager) GetBlockedCommandPatterns() []string {
+	patterns := m.Config.BlockedCmdPatterns
+	switch added := m.SessionOverrides["blocked_command_patterns"].(type) {
+	case []string:
+		patterns = append(patterns[:len(patterns):len(patterns)], added...)
+	case []any:
+		// restored from a saved session
+		for _, pattern := range added {
+			if s, ok := pattern.(string); ok {
+				patterns = append(patterns[:len(patterns):len(patterns)], s)
+			}
+		}
+	case string:
+		patterns = append(patterns[:len(patterns):len(patterns)], added)
+	}
+	return patterns
+}
+
+// GetConfigValue returns the effective value of a dot-notation config key with session override if present
+func (m *Manager) GetConfigValue(key string) (any, bool) {
+	if override, exists := m.SessionOverrides[key]; exists {
+		return override, true
+	}
+
+	val := reflect.ValueOf(m.Config).Elem()
+	for _, part := range strings.Split(key, ".") {
+		if val.Kind() != reflect.Struct {
+			return nil, false
+		}
+		found := false
+		for i := 0; i < val.NumField(); i++ {
+			tag := val.Type().Field(i).Tag.Get("mapstructure")
+			if tag == "" {
+				tag = strings.ToLower(val.Type().Field(i).Name)
+			}
+			if tag == part {
+				val = val.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	if val.Kind() == reflect.Struct {
+		return nil, false
+	}
+	if val.Kind() == reflect.String && strings.Contains(key, "api_key") {
+		return maskAPIKey(val.String()), true
+	}
+	return val.Interface(), true
+}
+
 // FormatConfig returns a nicely formatted string of all config values with session overrides applied
 func (m *Manager) FormatConfig() string {
 	var result strings.Builder
@@ -136,6 +316,8 @@ func formatConfigValue(sb *strings.Builder, prefix string, val reflect.Value, ov
 			valueStr = fmt.Sprintf("%t", field.Bool())
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			valueStr = fmt.Sprintf("%d", field.Int())
+		case reflect.Float32, reflect.Float64:
+			valueStr = fmt.Sprintf("%g", field.Float())
 		case reflect.Slice, reflect.Array:
 			valueStr = fmt.Sprintf("%v", field.Interface())
 		default:
@@ -3,25 +3,102 @@ package internal
 import (
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/alvinunreal/tmuxai/logger"
 	"github.com/chzyer/readline"
+	"github.com/eiannone/keyboard"
 	"github.com/fatih/color"
 )
 
+var lineNumberColor = color.New(color.FgHiBlack)
+
+// Decisions a confirmation hotkey can map to
+const (
+	confirmYes    = "yes"
+	confirmNo     = "no"
+	confirmEdit   = "edit"
+	confirmCancel = "cancel"
+	confirmAlways = "always"
+	confirmSkip   = "skip"
+)
+
+// defaultConfirmKeys is the single key map used when confirm_keys isn't set
+var defaultConfirmKeys = map[string]string{"y": confirmYes, "n": confirmNo, "a": confirmAlways, "e": confirmEdit, "s": confirmSkip}
+
+// Reasons a command runs without a prompt, kept for audit_approved_commands
+const (
+	approvedByWhitelist = "whitelisted"
+	approvedForSession  = "approved for the session"
+)
+
+// alwaysAllowKey is the session override holding the commands approved with "always" this session
+const alwaysAllowKey = "always_allow_commands"
+
 func (m *Manager) confirmedToExecFn(command string, prompt string, edit bool) (bool, string) {
-	isSafe, _ := m.whitelistCheck(command)
+	// stored outputs substituted into command can't get it approved
+	approveAs := command
+	if m.approvalCommand != "" {
+		approveAs = m.approvalCommand
+	}
+	isSafe, _ := m.whitelistCheck(approveAs)
 	if isSafe {
+		m.approvedWithoutPrompt = approvedByWhitelist
+		return true, command
+	}
+	if m.offerAlways && m.alwaysAllowed(approveAs) {
+		m.approvedWithoutPrompt = approvedForSession
 		return true, command
 	}
+	if m.remoteRequest {
+		m.Println("Declined, remote requests only run whitelisted commands: " + command)
+		return false, ""
+	}
 
 	promptColor := color.New(color.FgCyan, color.Bold)
 
-	var promptText string
+	choices := "[Y]es/No/Skip"
+	if m.offerAlways {
+		choices += "/Always"
+	}
 	if edit {
-		promptText = fmt.Sprintf("%s [Y]es/No/Edit: ", prompt)
-	} else {
-		promptText = fmt.Sprintf("%s [Y]es/No: ", prompt)
+		choices += "/Edit"
+	}
+	promptText := fmt.Sprintf("%s %s: ", prompt, choices)
+
+	if m.Config.ConfirmSingleKey {
+		fmt.Print(promptColor.Sprint(promptText))
+		char, key, err := keyboard.GetSingleKey()
+		if err == nil {
+			fmt.Println(string(char))
+			switch m.confirmKeyDecision(char, key) {
+			case confirmYes:
+				return true, command
+			case confirmNo:
+				return false, ""
+			case confirmSkip:
+				m.confirmSkipped = true
+				return false, ""
+			case confirmCancel:
+				m.Status = ""
+				return false, ""
+			case confirmEdit:
+				if edit {
+					return m.editCommand(command)
+				}
+			case confirmAlways:
+				if m.offerAlways {
+					m.allowAlways(approveAs)
+					return true, command
+				}
+			}
+			// any other key is retry confirmation
+			return m.confirmedToExecFn(command, prompt, edit)
+		}
+		// raw mode isn't available (e.g. not a tty), fall back to line input
+		fmt.Println()
+		logger.Debug("Single key confirmation unavailable, falling back to line input: %v", err)
 	}
 
 	// Use readline for initial confirmation to properly handle Ctrl+C
@@ -59,45 +136,137 @@ func (m *Manager) confirmedToExecFn(command string, prompt string, edit bool) (b
 	case "y", "yes", "ok", "sure":
 		return true, command
 	case "e", "edit":
-		// Allow user to edit the command using readline for better editing experience
-		editConfig := &readline.Config{
-			Prompt:          "Edit command: ",
-			InterruptPrompt: "^C",
-			EOFPrompt:       "exit",
+		return m.editCommand(command)
+	case "a", "always":
+		if !m.offerAlways {
+			return m.confirmedToExecFn(command, prompt, edit)
 		}
+		m.allowAlways(approveAs)
+		return true, command
+	case "n", "no", "cancel":
+		return false, ""
+	case "s", "skip":
+		m.confirmSkipped = true
+		return false, ""
+	default:
+		// any other input is retry confirmation
+		return m.confirmedToExecFn(command, prompt, edit)
+	}
+}
 
-		editRl, editErr := readline.NewEx(editConfig)
-		if editErr != nil {
-			fmt.Printf("Error initializing readline for edit: %v\n", editErr)
-			return false, ""
+// confirmAction asks for confirmation of an action, unless confirm_once_per_type
+// and an action of the same type was already approved in the current turn
+func (m *Manager) confirmAction(actionType string, command string, prompt string, edit bool) (bool, string) {
+	m.approvedWithoutPrompt = ""
+	if m.GetConfirmOncePerType() && m.turnApprovals[actionType] {
+		logger.Debug("Action type %s already approved this turn", actionType)
+		return true, command
+	}
+
+	// only commands can be approved for the rest of the session
+	m.offerAlways = actionType == "exec"
+	m.confirmSkipped = false
+	isSafe, command := m.confirmedToExec(command, prompt, edit)
+	m.offerAlways = false
+	if isSafe && m.GetConfirmOncePerType() {
+		if m.turnApprovals == nil {
+			m.turnApprovals = make(map[string]bool)
 		}
-		defer func() { _ = editRl.Close() }()
+		m.turnApprovals[actionType] = true
+	}
+	return isSafe, command
+}
 
-		// Use ReadlineWithDefault to prefill the command
-		editedCommand, editErr := editRl.ReadlineWithDefault(command)
-		if editErr != nil {
-			if editErr == readline.ErrInterrupt {
-				m.Status = ""
-				return false, ""
-			}
+// normalizeAllowedCommand collapses whitespace so reformatted repeats of a command still match
+func normalizeAllowedCommand(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
 
-			fmt.Printf("Error reading edited command: %v\n", editErr)
-			return false, ""
+// alwaysAllowedCommands returns the commands approved with "always", also when restored from a saved session
+func (m *Manager) alwaysAllowedCommands() []string {
+	switch allowed := m.SessionOverrides[alwaysAllowKey].(type) {
+	case []string:
+		return allowed
+	case []any:
+		commands := make([]string, 0, len(allowed))
+		for _, command := range allowed {
+			if s, ok := command.(string); ok {
+				commands = append(commands, s)
+			}
 		}
+		return commands
+	}
+	return nil
+}
+
+// alwaysAllowed reports whether command was approved with "always" this session
+func (m *Manager) alwaysAllowed(command string) bool {
+	return slices.Contains(m.alwaysAllowedCommands(), normalizeAllowedCommand(command))
+}
+
+// allowAlways skips the confirmation of command for the rest of the session
+func (m *Manager) allowAlways(command string) {
+	if m.SessionOverrides == nil {
+		m.SessionOverrides = make(map[string]any)
+	}
+	m.SessionOverrides[alwaysAllowKey] = append(m.alwaysAllowedCommands(), normalizeAllowedCommand(command))
+}
+
+// editCommand lets the user edit the command using readline for better editing experience
+func (m *Manager) editCommand(command string) (bool, string) {
+	editConfig := &readline.Config{
+		Prompt:          "Edit command: ",
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	}
+
+	editRl, editErr := readline.NewEx(editConfig)
+	if editErr != nil {
+		fmt.Printf("Error initializing readline for edit: %v\n", editErr)
+		return false, ""
+	}
+	defer func() { _ = editRl.Close() }()
 
-		editedCommand = strings.TrimSpace(editedCommand)
-		if editedCommand != "" {
-			return true, editedCommand
-		} else {
-			// empty command
+	// Use ReadlineWithDefault to prefill the command
+	editedCommand, editErr := editRl.ReadlineWithDefault(command)
+	if editErr != nil {
+		if editErr == readline.ErrInterrupt {
+			m.Status = ""
 			return false, ""
 		}
-	case "n", "no", "cancel":
+
+		fmt.Printf("Error reading edited command: %v\n", editErr)
+		return false, ""
+	}
+
+	editedCommand = strings.TrimSpace(editedCommand)
+	if editedCommand == "" {
+		// empty command
 		return false, ""
-	default:
-		// any other input is retry confirmation
-		return m.confirmedToExecFn(command, prompt, edit)
 	}
+	return true, editedCommand
+}
+
+// confirmKeyDecision maps a single keystroke to a confirmation decision using the configured key map,
+// which replaces the default one. Enter confirms, Escape declines and Ctrl+C cancels the whole request.
+func (m *Manager) confirmKeyDecision(char rune, key keyboard.Key) string {
+	switch key {
+	case keyboard.KeyEnter:
+		return confirmYes
+	case keyboard.KeyEsc:
+		return confirmNo
+	case keyboard.KeyCtrlC:
+		return confirmCancel
+	}
+
+	keys := m.Config.ConfirmKeys
+	if len(keys) == 0 {
+		keys = defaultConfirmKeys
+	}
+	if decision, ok := keys[strings.ToLower(string(char))]; ok {
+		return strings.ToLower(decision)
+	}
+	return ""
 }
 
 func (m *Manager) whitelistCheck(command string) (bool, error) {
@@ -135,3 +304,17 @@ func (m *Manager) whitelistCheck(command string) (bool, error) {
 
 	return true, nil
 }
+
+// pastePreview numbers the lines of content to paste, so a paste into an editor can be checked line by line,
+// and returns the preview with the line count
+func pastePreview(content string) (string, int) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	width := len(fmt.Sprint(len(lines)))
+	var b strings.Builder
+	for i, line := range lines {
+		b.WriteString(lineNumberColor.Sprintf("%*d │ ", width, i+1))
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(fmt.Sprintf("%d lines, %d bytes", len(lines), len(content)))
+	return b.String(), len(lines)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/alvinunreal/tmuxai/system"
+	"github.com/chzyer/readline"
+	"github.com/eiannone/keyboard"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test single key confirmation mapping with default and custom key maps
+func TestConfirmKeyDecision(t *testing.T) {
+	manager := &Manager{Config: config.DefaultConfig()}
+
+	testCases := []struct {
+		char     rune
+		key      keyboard.Key
+		expected string
+		desc     string
+	}{
+		{'y', 0, confirmYes, "y should confirm"},
+		{'Y', 0, confirmYes, "Uppercase Y should confirm"},
+		{'n', 0, confirmNo, "n should decline"},
+		{'e', 0, confirmEdit, "e should edit"},
+		{'a', 0, confirmAlways, "a should approve for the session"},
+		{'s', 0, confirmSkip, "s should skip"},
+		{0, keyboard.KeyEnter, confirmYes, "Enter should confirm"},
+		{0, keyboard.KeyEsc, confirmNo, "Escape should decline"},
+		{0, keyboard.KeyCtrlC, confirmCancel, "Ctrl+C should cancel"},
+		{'x', 0, "", "Unmapped key should retry"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, manager.confirmKeyDecision(tc.char, tc.key), tc.desc)
+	}
+
+	// Custom key map
+	manager.Config.ConfirmKeys = map[string]string{"j": "yes", "s": "no", "k": "EDIT"}
+	assert.Equal(t, confirmYes, manager.confirmKeyDecision('j', 0))
+	assert.Equal(t, confirmNo, manager.confirmKeyDecision('s', 0))
+	assert.Equal(t, confirmEdit, manager.confirmKeyDecision('k', 0))
+	assert.Equal(t, "", manager.confirmKeyDecision('y', 0), "Keys outside the custom map should retry")
+	assert.Equal(t, "", manager.confirmKeyDecision('a', 0), "The custom map replaces the default keys")
+}
+
+// Test answering "always" approves the same command for the rest of the session, until /reset
+func TestConfirmAction_Always(t *testing.T) {
+	manager := &Manager{Config: config.DefaultConfig(), SessionOverrides: map[string]interface{}{}}
+	prompts := 0
+	inputs := []string{"always\n", "n\n"}
+	origStdin := readline.Stdin
+	defer func() { readline.Stdin = origStdin }()
+	// each call gets the next answer, counted as a prompt once readline consumed it
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		input := strings.NewReader(inputs[prompts])
+		readline.Stdin = io.NopCloser(input)
+		ok, command := manager.confirmedToExecFn(command, prompt, edit)
+		if input.Len() == 0 {
+			prompts++
+		}
+		return ok, command
+	}
+
+	ok, command := manager.confirmAction("exec", "ls  -la", "Execute this command?", true)
+	assert.True(t, ok)
+	assert.Equal(t, "ls  -la", command)
+	assert.Equal(t, 1, prompts)
+
+	// the same command, reformatted, isn't prompted again
+	ok, _ = manager.confirmAction("exec", "ls -la", "Execute this command?", true)
+	assert.True(t, ok)
+	assert.Equal(t, 1, prompts, "an always approved command shouldn't be prompted")
+
+	// other commands and other action types still are
+	ok, _ = manager.confirmAction("exec", "rm -rf build", "Execute this command?", true)
+	assert.False(t, ok)
+	assert.Equal(t, 2, prompts)
+
+	manager.ExecPane = &system.TmuxPaneDetails{}
+	manager.ProcessSubCommand("/reset")
+	assert.False(t, manager.alwaysAllowed("ls -la"), "/reset should clear the allowlist")
+}
+
+// Test the whitelist matches the command as the AI wrote it, not the one with stored outputs substituted
+func TestConfirmAction_WhitelistBeforeSubstitution(t *testing.T) {
+	manager := &Manager{Config: config.DefaultConfig(), SessionOverrides: map[string]interface{}{}}
+	manager.Config.WhitelistPatterns = []string{`^ls \$\{files\}$`, `^cat notes\.txt$`}
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		readline.Stdin = io.NopCloser(strings.NewReader("n\n"))
+		return manager.confirmedToExecFn(command, prompt, edit)
+	}
+	origStdin := readline.Stdin
+	defer func() { readline.Stdin = origStdin }()
+
+	manager.approvalCommand = "ls ${files}"
+	ok, command := manager.confirmAction("exec", "ls 'a.go' 'b.go'", "Execute this command?", true)
+	assert.True(t, ok)
+	assert.Equal(t, "ls 'a.go' 'b.go'", command, "The substituted command is the one executed")
+
+	// a stored output that happens to match the whitelist doesn't approve the command
+	manager.approvalCommand = "${cmd}"
+	ok, _ = manager.confirmAction("exec", "cat notes.txt", "Execute this command?", true)
+	assert.False(t, ok)
+}
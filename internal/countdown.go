@@ -38,8 +38,20 @@ func (m *Manager) Countdown(seconds int) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	render := func() {
+		if m.statusLineEnabled() {
+			detail := fmt.Sprintf("%d", remaining)
+			if paused {
+				detail += " (paused)"
+			}
+			m.showActivity(activityCountdown, detail)
+			return
+		}
+		renderCountdown(remaining, seconds, paused, highlightColor, dimColor, pauseColor)
+	}
+
 	// Initial render
-	renderCountdown(remaining, seconds, paused, highlightColor, dimColor, pauseColor)
+	render()
 
 	for remaining > 0 {
 		select {
@@ -47,11 +59,11 @@ func (m *Manager) Countdown(seconds int) {
 			switch key {
 			case keyboard.KeySpace: // Space key
 				paused = !paused
-				renderCountdown(remaining, seconds, paused, highlightColor, dimColor, pauseColor)
+				render()
 			case keyboard.KeyEnter: // Enter key
 				// Just continue execution without exiting the function
 				remaining = 0 // Set remaining to 0 to end the countdown loop
-				renderCountdown(remaining, seconds, paused, highlightColor, dimColor, pauseColor)
+				render()
 			case keyboard.KeyCtrlC: // Ctrl+C
 				m.Status = ""
 				m.WatchMode = false
@@ -60,7 +72,7 @@ func (m *Manager) Countdown(seconds int) {
 		case <-ticker.C:
 			if !paused {
 				remaining--
-				renderCountdown(remaining, seconds, paused, highlightColor, dimColor, pauseColor)
+				render()
 			}
 		}
 	}
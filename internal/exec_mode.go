@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// preparableShells are the shells /prepare and /mode prepared can set up a prompt for
+var preparableShells = []string{"bash", "zsh", "fish", "nu", "nushell", "pwsh"}
+
+// prepareExecPaneCommand prepares the exec pane for shell, or the detected shell when empty,
+// and reports whether it got to preparing it
+func (m *Manager) prepareExecPaneCommand(shell string) bool {
+	m.InitExecPane()
+
+	if shell != "" && !slices.Contains(preparableShells, shell) {
+		m.Println(fmt.Sprintf("Shell '%s' is not supported. Supported shells are: %s", shell, strings.Join(preparableShells, ", ")))
+		return false
+	}
+	if m.reusePreparedExecPane() && (shell == "" || m.preparedShell == "" || shell == m.preparedShell) {
+		if shell != "" {
+			m.preparedShell = shell
+		}
+		m.Println("The exec pane is already prepared, keeping its prompt and scrollback")
+		return true
+	}
+	switch {
+	case shell != "":
+		m.PrepareExecPaneWithShell(shell)
+	case m.ExecPane.IsSubShell:
+		m.Println("Shell detection is not supported on subshells.")
+		m.Println("Please specify the shell manually: /prepare bash, /prepare zsh, /prepare fish, /prepare nu or /prepare pwsh")
+		return false
+	default:
+		m.PrepareExecPane()
+	}
+
+	// for latency over ssh connections
+	time.Sleep(500 * time.Millisecond)
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+	return true
+}
+
+// currentMode describes the mode the exec pane is operated in
+func (m *Manager) currentMode() string {
+	mode := "observe"
+	if m.ExecPane.IsPrepared {
+		mode = "prepared"
+		if shell := m.preparedShell; shell != "" {
+			mode += " (" + shell + ")"
+		}
+	}
+	if m.WatchMode {
+		mode += ", watching"
+	}
+	return mode
+}
+
+// switchMode handles /mode: show the mode, or switch between observe and prepared keeping the chat history
+func (m *Manager) switchMode(args []string) {
+	if len(args) == 0 {
+		m.Println("Mode: " + m.currentMode())
+		return
+	}
+
+	switch args[0] {
+	case "observe":
+		if !m.ExecPane.IsPrepared {
+			m.Println("Already in observe mode")
+			return
+		}
+		if err := m.UnprepareExecPane(); err != nil {
+			m.Println(err.Error())
+			return
+		}
+	case "prepared":
+		shell := ""
+		if len(args) > 1 {
+			shell = args[1]
+		}
+		if !m.prepareExecPaneCommand(shell) {
+			return
+		}
+		m.parseExecPaneCommandHistory()
+	default:
+		m.Println("Usage: /mode [observe|prepared [shell]]")
+		return
+	}
+	m.Println("Mode: " + m.currentMode())
+}
@@ -2,14 +2,19 @@ package internal
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"maps"
+	"math/rand/v2"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alvinunreal/tmuxai/logger"
 	"github.com/alvinunreal/tmuxai/system"
+	"github.com/chzyer/readline"
 )
 
 // GetAvailablePane finds an available pane or creates a new one if none are available
@@ -28,14 +33,161 @@ func (m *Manager) GetAvailablePane() system.TmuxPaneDetails {
 func (m *Manager) InitExecPane() {
 	availablePane := m.GetAvailablePane()
 	if availablePane.Id == "" {
-		_, _ = system.TmuxCreateNewPane(m.PaneId)
+		target := m.PaneId
+		if m.WindowTarget != "" {
+			target = m.WindowTarget
+		}
+		_, _ = system.TmuxCreateNewPane(target)
 		availablePane = m.GetAvailablePane()
 	}
 	m.ExecPane = &availablePane
 }
 
+// SetExecPane uses the pane given by id (%3) or target (session:window.pane) as the exec pane,
+// watching the panes of its window when that isn't the current one
+func (m *Manager) SetExecPane(target string) error {
+	paneId, window, err := system.TmuxResolvePane(target)
+	if err != nil {
+		return fmt.Errorf("pane %s not found: %w", target, err)
+	}
+	if paneId == m.PaneId {
+		return fmt.Errorf("pane %s is the TmuxAI pane itself, pick another pane to run commands in", target)
+	}
+
+	previousTarget := m.WindowTarget
+	if current, err := system.TmuxCurrentWindowTarget(); err != nil || window != current {
+		m.WindowTarget = window
+	}
+	panes, _ := m.GetTmuxPanes()
+	for _, pane := range panes {
+		if pane.Id == paneId {
+			m.ExecPane = &pane
+			logger.Info("Exec pane set to %s (%s)", paneId, target)
+			return nil
+		}
+	}
+	m.WindowTarget = previousTarget
+	return fmt.Errorf("pane %s not found in window %s", target, window)
+}
+
+// ensureExecPane re-picks the exec pane when the configured one no longer exists
+func (m *Manager) ensureExecPane() {
+	panes, _ := m.GetTmuxPanes()
+	if len(panes) == 0 {
+		// can't tell whether the pane is gone, leave it alone
+		return
+	}
+
+	var candidates []system.TmuxPaneDetails
+	for _, pane := range panes {
+		if pane.Id == m.ExecPane.Id {
+			// the user may have switched shells since the last turn
+			m.checkShellChange(pane.CurrentCommand, false)
+			return
+		}
+		if !pane.IsTmuxAiPane {
+			candidates = append(candidates, pane)
+		}
+	}
+
+	previousId := m.ExecPane.Id
+	var selected system.TmuxPaneDetails
+	switch {
+	case len(candidates) == 0:
+		m.InitExecPane()
+		m.Println(fmt.Sprintf("Exec pane %s is gone, created new exec pane %s", previousId, m.ExecPane.Id))
+		return
+	case len(candidates) == 1:
+		selected = candidates[0]
+	default:
+		var active []system.TmuxPaneDetails
+		for _, pane := range candidates {
+			if pane.IsActive == 1 {
+				active = append(active, pane)
+			}
+		}
+		if len(active) == 1 {
+			selected = active[0]
+		} else {
+			selected = m.choosePane(candidates)
+		}
+	}
+
+	selected.IsTmuxAiExecPane = true
+	m.ExecPane = &selected
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+	logger.Info("Exec pane %s is gone, switched to %s", previousId, m.ExecPane.Id)
+	m.Println(fmt.Sprintf("Exec pane %s is gone, switched to pane %s", previousId, m.ExecPane.Id))
+}
+
+// choosePane asks the user which of the candidate panes to use, defaulting to the first one
+func (m *Manager) choosePane(candidates []system.TmuxPaneDetails) system.TmuxPaneDetails {
+	if m.remoteRequest {
+		return candidates[0]
+	}
+	m.Println("Exec pane is gone, choose a new one:")
+	for i, pane := range candidates {
+		m.Println(fmt.Sprintf("%d) %s %s", i+1, pane.Id, pane.CurrentCommand))
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "Pane number [1]: ",
+		InterruptPrompt: "^C",
+	})
+	if err != nil {
+		return candidates[0]
+	}
+	defer func() { _ = rl.Close() }()
+
+	input, err := rl.Readline()
+	if err != nil {
+		return candidates[0]
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return candidates[0]
+	}
+	return candidates[choice-1]
+}
+
+// distroCommand prints the distribution of the host the exec pane runs on, works in bash, zsh and fish
+const distroCommand = "cat /etc/os-release 2>/dev/null || uname -sr"
+
+// nuDistroCommand is distroCommand for nushell, which has neither 2>/dev/null nor ||
+const nuDistroCommand = "if ('/etc/os-release' | path exists) { open --raw /etc/os-release | print } else { ^uname -sr }"
+
+// pwshDistroCommand is distroCommand for PowerShell, which may be running on Windows
+const pwshDistroCommand = "if (Test-Path /etc/os-release) { Get-Content /etc/os-release } else { [Environment]::OSVersion.VersionString }"
+
+// promptSaveCommands keep the shell's own prompt before preparing replaces it, the first preparation wins
+var promptSaveCommands = map[string]string{
+	"bash":    `TMUXAI_PS1=${TMUXAI_PS1-$PS1}; `,
+	"zsh":     `TMUXAI_PROMPT=${TMUXAI_PROMPT-$PROMPT}; `,
+	"fish":    `functions -q __tmuxai_fish_prompt; or functions -c fish_prompt __tmuxai_fish_prompt; `,
+	"pwsh":    `if (-not $global:TmuxaiPrompt) { $global:TmuxaiPrompt = $function:prompt }; `,
+	"nu":      nuPromptSaveCommand,
+	"nushell": nuPromptSaveCommand,
+}
+
+// nushell keeps its prompt in closures, saved and restored along with the indicator
+const (
+	nuPromptSaveCommand    = `if 'TMUXAI_PROMPT_COMMAND' not-in ($env | columns) { $env.TMUXAI_PROMPT_COMMAND = $env.PROMPT_COMMAND?; $env.TMUXAI_PROMPT_COMMAND_RIGHT = $env.PROMPT_COMMAND_RIGHT?; $env.TMUXAI_PROMPT_INDICATOR = $env.PROMPT_INDICATOR? }; `
+	nuPromptRestoreCommand = `if 'TMUXAI_PROMPT_COMMAND' in ($env | columns) { $env.PROMPT_COMMAND = $env.TMUXAI_PROMPT_COMMAND; $env.PROMPT_COMMAND_RIGHT = $env.TMUXAI_PROMPT_COMMAND_RIGHT; $env.PROMPT_INDICATOR = $env.TMUXAI_PROMPT_INDICATOR; hide-env TMUXAI_PROMPT_COMMAND TMUXAI_PROMPT_COMMAND_RIGHT TMUXAI_PROMPT_INDICATOR }`
+)
+
+// promptRestoreCommands bring back the prompt saved by promptSaveCommands, leaving prepared mode.
+// A pane prepared before prompts were saved, e.g. reused from an older run, keeps its prompt.
+var promptRestoreCommands = map[string]string{
+	"bash":    `PS1=${TMUXAI_PS1-$PS1}; unset TMUXAI_PS1`,
+	"zsh":     `PROMPT=${TMUXAI_PROMPT-$PROMPT}; unset TMUXAI_PROMPT`,
+	"fish":    `functions -q __tmuxai_fish_prompt; and functions -e fish_prompt; and functions -c __tmuxai_fish_prompt fish_prompt; and functions -e __tmuxai_fish_prompt`,
+	"pwsh":    `if ($global:TmuxaiPrompt) { $function:prompt = $global:TmuxaiPrompt; Remove-Variable -Scope global TmuxaiPrompt }`,
+	"nu":      nuPromptRestoreCommand,
+	"nushell": nuPromptRestoreCommand,
+}
+
 func (m *Manager) PrepareExecPaneWithShell(shell string) {
-	m.ExecPane.Refresh(m.GetMaxCaptureLines())
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
 	if m.ExecPane.IsPrepared && m.ExecPane.Shell != "" {
 		return
 	}
@@ -48,39 +200,275 @@ func (m *Manager) PrepareExecPaneWithShell(shell string) {
 		ps1Command = `export PS1='\u@\h:\w[\A][$?]» '`
 	case "fish":
 		ps1Command = `function fish_prompt; set -l s $status; printf '%s@%s:%s[%s][%d]» ' $USER (hostname -s) (prompt_pwd) (date +"%H:%M") $s; end`
+	case "pwsh":
+		ps1Command = `function prompt { $s = if ($?) { $true } elseif ($LASTEXITCODE) { $LASTEXITCODE } else { $false }; "$([Environment]::UserName)@$([Environment]::MachineName):$($PWD.Path)[$(Get-Date -Format HH:mm)][$s]» " }`
+	case "nu", "nushell":
+		ps1Command = `$env.PROMPT_COMMAND = {|| $"($env.USER)@(^hostname -s | str trim):($env.PWD | str replace $env.HOME '~')[(date now | format date '%H:%M')][($env.LAST_EXIT_CODE)]" }; $env.PROMPT_COMMAND_RIGHT = {|| "" }; $env.PROMPT_INDICATOR = {|| "» " }`
 	default:
 		errMsg := fmt.Sprintf("Shell '%s' in pane %s is recognized but not yet supported for PS1 modification.", shell, m.ExecPane.Id)
 		logger.Info(errMsg)
 		return
 	}
 
-	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, ps1Command, true)
-	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-l", false)
+	// old prompts in the scrollback look like sentinels to the first parse, wipe them first.
+	// tmux clears the history itself, a command typed into the pane would land in the shell history.
+	if m.Config.PrepareResetHistory {
+		_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-l", false)
+		// the cleared screen scrolls into the history, wait for it before clearing that
+		time.Sleep(100 * time.Millisecond)
+		_ = system.TmuxClearPane(m.ExecPane.Id)
+	}
+
+	// detect the distribution in the same line, the pane may be on another host than tmuxai
+	detect := distroCommand
+	switch shell {
+	case "nu", "nushell":
+		detect = nuDistroCommand
+	case "pwsh":
+		detect = pwshDistroCommand
+	}
+	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, promptSaveCommands[shell]+ps1Command+"; "+detect, true)
+	m.preparedShell = shell
+	time.Sleep(500 * time.Millisecond)
+	if content, err := system.TmuxCapturePane(m.ExecPane.Id, 50); err == nil {
+		m.ExecPane.Distro = system.ParseDistro(content)
+		logger.Debug("Exec pane distro: %s", m.ExecPane.Distro)
+	}
+	if m.Config.PrepareClearScreen {
+		_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-l", false)
+	}
 }
 
 func (m *Manager) PrepareExecPane() {
 	m.PrepareExecPaneWithShell(m.ExecPane.CurrentCommand)
 }
 
+// reusePreparedExecPane marks the exec pane prepared when it still shows the prompt a previous run set up,
+// so it isn't prepared again, which would send the prompt commands and clear its scrollback
+func (m *Manager) reusePreparedExecPane() bool {
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+	if match, _ := m.findExecPrompt(m.ExecPane.LastLine); match == nil {
+		return false
+	}
+	m.ExecPane.IsPrepared = true
+	if m.preparedShell == "" && system.IsShellCommand(m.ExecPane.Shell) {
+		m.preparedShell = m.ExecPane.Shell
+	}
+	logger.Info("Exec pane %s already shows the prepared prompt, reusing it", m.ExecPane.Id)
+	return true
+}
+
+// UnprepareExecPane restores the exec pane's own prompt, switching back to observe mode
+func (m *Manager) UnprepareExecPane() error {
+	shell := m.preparedShell
+	if shell == "" {
+		shell = m.ExecPane.Shell
+	}
+	restore, ok := promptRestoreCommands[shell]
+	if !ok {
+		return fmt.Errorf("restoring the prompt of %s isn't supported, restart the shell to leave prepared mode", shell)
+	}
+	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, restore, true)
+	if m.Config.PrepareClearScreen {
+		_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-l", false)
+	}
+	m.preparedShell = ""
+	time.Sleep(500 * time.Millisecond)
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+	return nil
+}
+
+// defaultCompletionMarker is echoed after a command in POSIX shells, %s is the mark
+const defaultCompletionMarker = `__TMUXAI_DONE_%s_$?__`
+
+// nuCompletionMarker interpolates the exit code, nushell has no $?
+const nuCompletionMarker = `$"__TMUXAI_DONE_%s_($env.LAST_EXIT_CODE)__"`
+
+// completionMarkers are the markers of shells that don't understand defaultCompletionMarker.
+// PowerShell's $? is a boolean, it reports the native exit code or 1 instead.
+var completionMarkers = map[string]string{
+	"fish":    `__TMUXAI_DONE_%s_$status__`,
+	"nu":      nuCompletionMarker,
+	"nushell": nuCompletionMarker,
+	"pwsh":    `"__TMUXAI_DONE_%s_$(if ($?) { 0 } elseif ($LASTEXITCODE) { $LASTEXITCODE } else { 1 })__"`,
+}
+
+var (
+	// completionMarkerCommandRegex matches the echoed command line, with an optional shell prompt before it
+	completionMarkerCommandRegex = regexp.MustCompile(completionMarkerCommandPattern())
+	// completionMarkerDoneRegex matches the marker printed once the command finished, with its exit code
+	completionMarkerDoneRegex = regexp.MustCompile(`^__TMUXAI_DONE_(?:\d+_)?(\d+)__$`)
+)
+
+// completionMarkerCommandPattern matches a command followed by the marker of any shell, with or without a mark
+func completionMarkerCommandPattern() string {
+	var markers []string
+	for _, marker := range append([]string{defaultCompletionMarker}, slices.Sorted(maps.Values(completionMarkers))...) {
+		before, after, _ := strings.Cut(marker, "%s_")
+		markers = append(markers, regexp.QuoteMeta(before)+`(?:\d+_)?`+regexp.QuoteMeta(after))
+	}
+	return `^(?:.*?[$#%>»] )?(.*); echo (?:` + strings.Join(markers, "|") + `)$`
+}
+
+// withCompletionMarker appends an echo of the exit code so completion can be detected without a prepared prompt.
+// The mark tells this run's marker apart from earlier runs of the same command still in the scrollback.
+func withCompletionMarker(shell string, command string, mark string) string {
+	marker, ok := completionMarkers[shell]
+	if !ok {
+		marker = defaultCompletionMarker
+	}
+	return command + "; echo " + fmt.Sprintf(marker, mark)
+}
+
+// newExecPaneMark returns a mark for the completion marker of the next command
+func newExecPaneMark() string {
+	return strconv.Itoa(100000 + rand.IntN(900000))
+}
+
+// contentSinceMark drops everything before the command line whose completion marker carries mark,
+// returning nothing until that line shows up, and content unchanged when there is no mark or
+// the command line scrolled out of the capture after it finished
+func contentSinceMark(content string, mark string) string {
+	if mark == "" {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.Contains(lines[i], "; echo ") && strings.Contains(lines[i], "__TMUXAI_DONE_"+mark+"_") {
+			return strings.Join(lines[i:], "\n")
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "__TMUXAI_DONE_"+mark+"_") {
+			return content
+		}
+	}
+	return ""
+}
+
+// ExecWaitMarker sends a command with a completion marker and waits up to the wait interval for it to finish
+func (m *Manager) ExecWaitMarker(command string) (CommandExecHistory, error) {
+	// only parse what the command adds, an earlier run of the same command may still be in the scrollback
+	m.execPaneMark = newExecPaneMark()
+	defer func() { m.execPaneMark = "" }()
+
+	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, withCompletionMarker(m.ExecPane.Shell, command, m.execPaneMark), true)
+
+	deadline := time.Now().Add(time.Duration(m.GetWaitInterval()) * time.Second)
+	for m.Status != "" {
+		time.Sleep(500 * time.Millisecond)
+		m.parseExecPaneCommandHistory()
+		if n := len(m.ExecHistory); n > 0 && m.ExecHistory[n-1].Command == command && m.ExecHistory[n-1].Code >= 0 {
+			return m.ExecHistory[n-1], nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	return CommandExecHistory{Command: command, Code: -1}, fmt.Errorf("command did not finish within the wait interval")
+}
+
+// isolateCommand wraps a command so it runs in a subshell of the given shell,
+// keeping cd, export and similar side effects out of the interactive shell
+func isolateCommand(shell string, command string) string {
+	switch shell {
+	case "fish":
+		return "fish -c '" + strings.ReplaceAll(strings.ReplaceAll(command, `\`, `\\`), "'", `\'`) + "'"
+	case "pwsh":
+		// parentheses only group an expression in PowerShell, Set-Location would still leak
+		return "pwsh -NoProfile -Command '" + strings.ReplaceAll(command, "'", "''") + "'"
+	case "nu", "nushell":
+		// nushell strings have no escapes, a raw string needs one more # than any '# in the command
+		hashes := "#"
+		for strings.Contains(command, "'"+hashes) {
+			hashes += "#"
+		}
+		return "nu -c r" + hashes + "'" + command + "'" + hashes
+	}
+	return "( " + command + " )"
+}
+
+// ErrInteractiveProgram is returned by ExecWaitCapture when a full-screen program took over the exec pane
+var ErrInteractiveProgram = errors.New("interactive program is running in the exec pane")
+
+// ErrCommandStillRunning is returned by ExecWaitCapture when stream_command_output is on and
+// the command hasn't finished within stream_command_output_sec, so the AI can see its progress
+var ErrCommandStillRunning = errors.New("command is still running in the exec pane")
+
+// minStreamCmdOutputSec bounds how often the output of a running command is sent to the AI
+const minStreamCmdOutputSec = 2
+
+// streamCommandOutputInterval returns how long ExecWaitCapture waits before reporting a running command, 0 when off
+func (m *Manager) streamCommandOutputInterval() time.Duration {
+	if !m.Config.StreamCmdOutput {
+		return 0
+	}
+	return time.Duration(max(m.Config.StreamCmdOutputSec, minStreamCmdOutputSec)) * time.Second
+}
+
 func (m *Manager) ExecWaitCapture(command string) (CommandExecHistory, error) {
 	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, command, true)
 
 	// wait for keys to be sent, duo to sometimes ssh latency
 	time.Sleep(500 * time.Millisecond)
 
-	m.ExecPane.Refresh(m.GetMaxCaptureLines())
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
 
 	animChars := []string{"⋯", "⋱", "⋮", "⋰"}
 	animIndex := 0
-	for !strings.HasSuffix(m.ExecPane.LastLine, "]»") && m.Status != "" {
-		fmt.Printf("\r%s%s ", m.GetPrompt(), animChars[animIndex])
+	streamInterval := m.streamCommandOutputInterval()
+	started := time.Now()
+	// the prompt line stays last until the command prints something, only new prompts are answered
+	answeredPrompts := 0
+	for !m.atExecPrompt() && m.Status != "" {
+		if streamInterval > 0 && time.Since(started) >= streamInterval {
+			fmt.Print("\r\033[K")
+			logger.Info("Command still running after %s, reporting its progress: %s", streamInterval, command)
+			return CommandExecHistory{
+				Command: command,
+				Code:    -1,
+				Output:  m.redactSecrets(m.outputSincePrompt(m.ExecPane.Content)),
+			}, ErrCommandStillRunning
+		}
+		// the prompt never shows while a full-screen program owns the terminal
+		if alternateOn, _ := system.TmuxPaneAlternateOn(m.ExecPane.Id); alternateOn {
+			fmt.Print("\r\033[K")
+			logger.Info("Exec pane switched to the alternate screen while running: %s", command)
+			return CommandExecHistory{
+				Command: command,
+				Code:    -1,
+				Output:  "Interactive program is running and has taken over the terminal",
+			}, ErrInteractiveProgram
+		}
+		if isSudoPrompt(m.ExecPane.LastLine) {
+			if prompts := countSudoPrompts(m.outputSincePrompt(m.ExecPane.Content)); prompts > answeredPrompts {
+				answeredPrompts = prompts
+				fmt.Print("\r\033[K")
+				m.answerSudoPrompt(m.ExecPane.LastLine)
+				time.Sleep(500 * time.Millisecond)
+				m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+				continue
+			}
+		}
+		if m.statusLineEnabled() {
+			m.showActivity(activityWaiting, "")
+		} else {
+			fmt.Printf("\r%s%s ", m.GetPrompt(), animChars[animIndex])
+		}
 		animIndex = (animIndex + 1) % len(animChars)
 		time.Sleep(500 * time.Millisecond)
-		m.ExecPane.Refresh(m.GetMaxCaptureLines())
+		m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
 	}
 	fmt.Print("\r\033[K")
 
 	m.parseExecPaneCommandHistory()
+	if len(m.ExecHistory) == 0 && m.Status != "" {
+		// slow output may not have flushed yet, let the pane settle and capture once more
+		settle := time.Duration(m.Config.ParseRetrySettleMs) * time.Millisecond
+		logger.Debug("Failed to parse command history, recapturing after %s", settle)
+		time.Sleep(settle)
+		m.parseExecPaneCommandHistory()
+	}
 	if len(m.ExecHistory) == 0 {
 		logger.Error("Failed to parse command history from exec pane")
 		return CommandExecHistory{}, fmt.Errorf("failed to parse command history from exec pane")
@@ -90,50 +478,170 @@ func (m *Manager) ExecWaitCapture(command string) (CommandExecHistory, error) {
 	return cmd, nil
 }
 
+// parseStatusCode reads the exit code from a prompt, mapping PowerShell's True/False to 0/1
+func parseStatusCode(code string) (int, error) {
+	switch strings.ToLower(code) {
+	case "true":
+		return 0, nil
+	case "false":
+		return 1, nil
+	}
+	return strconv.Atoi(code)
+}
+
+// defaultExecPromptRegex matches the prompt set up by /prepare, user@host:path[HH:MM][code]».
+// It isn't anchored at column 0, output without a trailing newline leaves the prompt mid-line, see findExecPrompt.
+// The path may contain [, the first [HH:MM][code]» ends it. ` ?` allows zero or one space after ».
+// PowerShell reports success as True/False instead of a number, see parseStatusCode
+const defaultExecPromptRegex = `[^\s@]+@[^\s:]+:.*?\[(?P<time>\d{1,2}:\d{2})\]\[(?P<code>\d+|(?i:true|false))\]» ?(?P<command>.*)$`
+
+// execPromptRegex returns the compiled exec_prompt_regex, recompiled only when the setting changes.
+// A pattern that doesn't compile or lacks the code and command groups falls back to the default.
+func (m *Manager) execPromptRegex() *regexp.Regexp {
+	source := m.GetExecPromptRegex()
+	if m.promptRegex != nil && source == m.promptRegexSource {
+		return m.promptRegex
+	}
+
+	m.promptRegexSource = source
+	m.promptRegex = regexp.MustCompile(defaultExecPromptRegex)
+	if source == "" {
+		return m.promptRegex
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		logger.Warn("Invalid exec_prompt_regex %q, using the default: %v", source, err)
+		return m.promptRegex
+	}
+	if re.SubexpIndex("code") < 0 || re.SubexpIndex("command") < 0 {
+		logger.Warn("exec_prompt_regex %q needs (?P<code>...) and (?P<command>...) groups, using the default", source)
+		return m.promptRegex
+	}
+	m.promptRegex = re
+	return re
+}
+
+// refreshPane captures pane again and decides whether it's prepared with exec_prompt_regex,
+// Refresh itself only knows the default prompt's » suffix
+func (m *Manager) refreshPane(pane *system.TmuxPaneDetails, maxLines int) {
+	pane.Refresh(maxLines)
+	match, _ := m.findExecPrompt(pane.LastLine)
+	pane.IsPrepared = match != nil
+}
+
+// findExecPrompt returns the exec prompt's submatches in line and the output preceding it on the same line,
+// which a command printing no trailing newline leaves there. A prompt after nothing but indentation
+// is prompt-looking text printed by a command, not a prompt.
+func (m *Manager) findExecPrompt(line string) ([]string, string) {
+	re := m.execPromptRegex()
+	loc := re.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return nil, ""
+	}
+	before := line[:loc[0]]
+	if before != "" && strings.TrimSpace(before) == "" {
+		return nil, ""
+	}
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = line[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return match, before
+}
+
+// outputSincePrompt returns the lines after the last prompt in the content, the output of a running command
+func (m *Manager) outputSincePrompt(content string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if match, _ := m.findExecPrompt(lines[i]); match != nil {
+			return strings.Join(lines[i+1:], "\n")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// atExecPrompt reports whether the exec pane's last line is a prompt waiting for a command
+func (m *Manager) atExecPrompt() bool {
+	if m.GetExecPromptRegex() == "" {
+		return strings.HasSuffix(m.ExecPane.LastLine, "]»")
+	}
+	match, _ := m.findExecPrompt(m.ExecPane.LastLine)
+	return match != nil && strings.TrimSpace(match[m.execPromptRegex().SubexpIndex("command")]) == ""
+}
+
 func (m *Manager) parseExecPaneCommandHistory() {
 	m.parseExecPaneCommandHistoryWithContent("")
 }
 
 func (m *Manager) parseExecPaneCommandHistoryWithContent(testContent string) {
 	if testContent == "" {
-		m.ExecPane.Refresh(m.GetMaxCaptureLines())
+		m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
 	} else {
-		m.ExecPane.Content = testContent
+		m.ExecPane.Content = system.SanitizeUTF8(testContent)
 	}
 
+	content := contentSinceMark(m.ExecPane.Content, m.execPaneMark)
+
 	var history []CommandExecHistory
 
 	var currentCommand *CommandExecHistory
 	var outputBuilder strings.Builder
 
-	// Regex: Capture status code (group 1), optionally capture command (group 2)
+	// Regex: Capture status code (group "code"), optionally capture command (group "command")
 	// Making the command part optional handles prompts that only show status (like the last line).
-	// ` ?` allows zero or one space after »
-	promptRegex := regexp.MustCompile(`.*\[(\d+)\]» ?(.*)$`)
+	promptRegex := m.execPromptRegex()
+	codeGroup := promptRegex.SubexpIndex("code")
+	commandGroup := promptRegex.SubexpIndex("command")
 
-	scanner := bufio.NewScanner(strings.NewReader(m.ExecPane.Content))
+	scanner := bufio.NewScanner(strings.NewReader(content))
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		match := promptRegex.FindStringSubmatch(line)
 
-		if len(match) >= 2 { // We need at least the status code match[1]
+		// --- Completion marker appended to commands sent to unprepared panes ---
+		if markerMatch := completionMarkerCommandRegex.FindStringSubmatch(line); markerMatch != nil {
+			if currentCommand != nil {
+				currentCommand.Output = strings.TrimSuffix(outputBuilder.String(), "\n")
+				history = append(history, *currentCommand)
+				outputBuilder.Reset()
+			}
+			currentCommand = &CommandExecHistory{Command: strings.TrimSpace(markerMatch[1]), Code: -1}
+			continue
+		}
+		if doneMatch := completionMarkerDoneRegex.FindStringSubmatch(line); doneMatch != nil {
+			if currentCommand != nil {
+				currentCommand.Code, _ = strconv.Atoi(doneMatch[1])
+				currentCommand.Output = strings.TrimSuffix(outputBuilder.String(), "\n")
+				history = append(history, *currentCommand)
+				outputBuilder.Reset()
+				currentCommand = nil
+			}
+			continue
+		}
+
+		match, before := m.findExecPrompt(line)
+
+		if match != nil {
 			// --- Found a prompt line ---
 			// This prompt line *terminates* the previous command block
 			// and provides its status code. It might also start a new command block.
-
-			statusCodeStr := match[1]
-			commandStr := "" // Default if only status code found (like the last line)
-			if len(match) > 2 {
-				commandStr = strings.TrimSpace(match[2]) // Command for the *next* block
+			// Output printed without a trailing newline precedes the prompt on the same line.
+			if currentCommand != nil && before != "" {
+				outputBuilder.WriteString(before)
+				outputBuilder.WriteString("\n")
 			}
 
+			statusCodeStr := match[codeGroup]
+			commandStr := strings.TrimSpace(match[commandGroup]) // Command for the *next* block, empty on the last line
+
 			// 1. Finalize the PREVIOUS command block (if one was active)
 			if currentCommand != nil {
 				// Parse the status code found on *this* line - it belongs to the *previous* command
-				statusCode, err := strconv.Atoi(statusCodeStr)
+				statusCode, err := parseStatusCode(statusCodeStr)
 				if err != nil {
-					// This shouldn't happen with \d+ regex but check anyway
+					// This shouldn't happen with the prompt regex but check anyway
 					fmt.Printf("Warning: Could not parse status code '%s' for previous command on line: %s\n", statusCodeStr, line)
 					currentCommand.Code = -1 // Indicate parsing error
 				} else {
@@ -186,6 +694,10 @@ func (m *Manager) parseExecPaneCommandHistoryWithContent(testContent string) {
 		logger.Error("error reading input: %v", err)
 	}
 
+	for i := range history {
+		history[i].Output = m.redactSecrets(history[i].Output)
+	}
+
 	// Update the manager's command history
 	m.ExecHistory = history
 }
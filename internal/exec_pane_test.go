@@ -2,8 +2,11 @@ package internal
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alvinunreal/tmuxai/config"
 	"github.com/alvinunreal/tmuxai/system"
@@ -112,7 +115,7 @@ user@localhost:~[00:00][0]» `
 // Test PrepareExecPaneWithShell for different shells
 func TestPrepareExecPaneWithShell(t *testing.T) {
 	manager := &Manager{
-		Config:           &config.Config{MaxCaptureLines: 1000},
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
 		SessionOverrides: make(map[string]interface{}),
 		ExecPane: &system.TmuxPaneDetails{
 			Id:         "test-pane",
@@ -165,6 +168,105 @@ func TestPrepareExecPaneWithShell(t *testing.T) {
 	assert.Len(t, commandsSent, 0, "Should not send commands for unsupported shell")
 }
 
+// Test PrepareExecPaneWithShell for nushell
+func TestPrepareExecPaneWithShell_Nushell(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane: &system.TmuxPaneDetails{
+			Id:         "test-pane",
+			IsPrepared: false,
+			Shell:      "",
+		},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "", nil
+	}
+
+	manager.PrepareExecPaneWithShell("nu")
+	assert.Len(t, commandsSent, 2, "Should send 2 commands for nu")
+	assert.Contains(t, commandsSent[0], "$env.PROMPT_COMMAND =", "Should set PROMPT_COMMAND for nu")
+	assert.Contains(t, commandsSent[0], "$env.PROMPT_COMMAND_RIGHT =")
+	assert.Contains(t, commandsSent[0], "[($env.LAST_EXIT_CODE)]")
+	assert.Contains(t, commandsSent[0], `"» "`)
+	assert.NotContains(t, commandsSent[0], "2>/dev/null", "Nushell has no POSIX redirections")
+	assert.Equal(t, "C-l", commandsSent[1], "Should clear screen")
+
+	commandsSent = []string{}
+	manager.PrepareExecPaneWithShell("nushell")
+	assert.Len(t, commandsSent, 2, "nushell is an alias of nu")
+
+	// the rendered prompt parses like the other shells
+	manager.parseExecPaneCommandHistoryWithContent("alice@box:~/src[09:41][0]» ls\nREADME.md\nalice@box:~/src[09:41][0]» ")
+	if assert.Len(t, manager.ExecHistory, 1) {
+		assert.Equal(t, "ls", manager.ExecHistory[0].Command)
+		assert.Equal(t, 0, manager.ExecHistory[0].Code)
+	}
+}
+
+// Test PrepareExecPaneWithShell for PowerShell and parsing its True/False status
+func TestPrepareExecPaneWithShell_Pwsh(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane"},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "", nil
+	}
+
+	manager.PrepareExecPaneWithShell("pwsh")
+	assert.Len(t, commandsSent, 2, "Should send 2 commands for pwsh")
+	assert.Contains(t, commandsSent[0], "function prompt", "Should override the prompt function")
+	assert.Contains(t, commandsSent[0], "$LASTEXITCODE")
+	assert.Equal(t, "C-l", commandsSent[1], "Should clear screen")
+
+	testContent := `alice@BOX:/home/alice[09:41][True]» Get-ChildItem
+README.md
+alice@BOX:/home/alice[09:41][True]» Get-Item missing
+Get-Item: Cannot find path '/home/alice/missing' because it does not exist.
+alice@BOX:/home/alice[09:42][False]» git fetch nowhere
+fatal: 'nowhere' does not appear to be a git repository
+alice@BOX:/home/alice[09:42][128]» `
+
+	manager.parseExecPaneCommandHistoryWithContent(testContent)
+	if assert.Len(t, manager.ExecHistory, 3) {
+		assert.Equal(t, "Get-ChildItem", manager.ExecHistory[0].Command)
+		assert.Equal(t, "README.md", manager.ExecHistory[0].Output)
+		assert.Equal(t, 0, manager.ExecHistory[0].Code)
+		assert.Equal(t, "Get-Item missing", manager.ExecHistory[1].Command)
+		assert.Equal(t, 1, manager.ExecHistory[1].Code)
+		assert.Equal(t, "git fetch nowhere", manager.ExecHistory[2].Command)
+		assert.Equal(t, 128, manager.ExecHistory[2].Code)
+	}
+}
+
 // Test prompt regex with error cases that should be handled gracefully
 func TestParseExecPaneCommandHistory_ErrorHandling(t *testing.T) {
 	manager := &Manager{
@@ -261,6 +363,50 @@ user@remote-server:~$ `, nil
 	assert.True(t, refreshCount > 1, "Should have attempted multiple refreshes before giving up")
 }
 
+// Test ExecWaitCapture recaptures once after settling before giving up on parsing
+func TestExecWaitCapture_RecaptureAfterSettle(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000, ParseRetrySettleMs: 50},
+		SessionOverrides: make(map[string]interface{}),
+		Status:           "running",
+		ExecPane:         &system.TmuxPaneDetails{Id: "exec-pane"},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		return nil
+	}
+
+	captures := 0
+	var lastCaptureAt time.Time
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		captures++
+		lastCaptureAt = time.Now()
+		if captures <= 2 {
+			// the prompt is redrawn but the command line and output haven't flushed yet
+			return "user@hostname:~[14:30][0]» ", nil
+		}
+		return "user@hostname:~[14:30][0]» make\nok\nuser@hostname:~[14:31][0]» ", nil
+	}
+
+	start := time.Now()
+	result, err := manager.ExecWaitCapture("make")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "make", result.Command)
+	assert.Equal(t, "ok", result.Output)
+	assert.Equal(t, 0, result.Code)
+	assert.Equal(t, 3, captures, "Should recapture exactly once after the first parse failure")
+	assert.GreaterOrEqual(t, lastCaptureAt.Sub(start), 550*time.Millisecond, "Final recapture should wait for the settle time")
+}
+
 // Test ExecWaitCapture with successful command execution and proper prompt
 func TestExecWaitCapture_SuccessfulExecution(t *testing.T) {
 	manager := &Manager{
@@ -306,3 +452,639 @@ user@hostname:~[14:31][0]» `, nil
 	assert.Equal(t, "test successful", result.Output)
 	assert.Equal(t, "echo \"test successful\"", commandSent, "Should have sent the correct command")
 }
+
+// Test the exec pane is re-picked when the configured one was closed
+func TestEnsureExecPane_ReselectsSingleCandidate(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{Id: "%5"},
+	}
+
+	originalTmuxCapture := system.TmuxCapturePane
+	originalTmuxCurrentPaneId := system.TmuxCurrentPaneId
+	originalTmuxPanesDetails := system.TmuxPanesDetails
+	defer func() {
+		system.TmuxCapturePane = originalTmuxCapture
+		system.TmuxCurrentPaneId = originalTmuxCurrentPaneId
+		system.TmuxPanesDetails = originalTmuxPanesDetails
+	}()
+
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "user@host:~$ ", nil
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "%0", nil
+	}
+	// %5 was closed, only the tmuxai pane and one other pane remain
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{
+			{Id: "%0", IsActive: 1},
+			{Id: "%2", CurrentCommand: "bash"},
+		}, nil
+	}
+
+	manager.ensureExecPane()
+
+	assert.Equal(t, "%2", manager.ExecPane.Id, "Should auto-select the only remaining candidate")
+
+	// Existing exec pane is kept as-is
+	manager.ExecPane = &system.TmuxPaneDetails{Id: "%2", Content: "kept"}
+	manager.ensureExecPane()
+	assert.Equal(t, "kept", manager.ExecPane.Content, "Should not touch an exec pane that still exists")
+}
+
+// Test PrepareExecPaneWithShell keeps the screen when prepare_clear_screen is off
+func TestPrepareExecPaneWithShell_NoClearScreen(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: false},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane"},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "", nil
+	}
+
+	manager.PrepareExecPaneWithShell("bash")
+	assert.Len(t, commandsSent, 1, "Should only send the prompt command")
+	assert.Contains(t, commandsSent[0], "PS1=")
+	assert.NotContains(t, commandsSent, "C-l")
+}
+
+// Test sentinel-looking text in commands and output isn't treated as a prompt
+func TestParseExecPaneCommandHistory_EscapedSentinel(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+	}
+
+	manager.ExecPane = &system.TmuxPaneDetails{}
+	testContent := `user@hostname:~[14:30][0]» echo "fake[12:00][0]» prompt"
+fake[12:00][0]» prompt
+  indented@host:~[12:00][3]» not a prompt either
+user@hostname:~[14:31][0]» `
+
+	manager.parseExecPaneCommandHistoryWithContent(testContent)
+
+	assert.Len(t, manager.ExecHistory, 1, "Sentinel inside output should not start a new command")
+	assert.Equal(t, `echo "fake[12:00][0]» prompt"`, manager.ExecHistory[0].Command)
+	assert.Equal(t, 0, manager.ExecHistory[0].Code)
+	assert.Equal(t, "fake[12:00][0]» prompt\n  indented@host:~[12:00][3]» not a prompt either", manager.ExecHistory[0].Output)
+}
+
+// Test a prompt following output without a trailing newline, and a path containing [, still end the command
+func TestParseExecPaneCommandHistory_MidLinePrompt(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+
+	testContent := `user@hostname:~/src[old][14:30][0]» printf 'no newline'
+no newlineuser@hostname:~/src[old][14:30][0]» printf 'one two'; false
+one twouser@hostname:~/src[old][14:31][1]» `
+
+	manager.parseExecPaneCommandHistoryWithContent(testContent)
+
+	if assert.Len(t, manager.ExecHistory, 2) {
+		assert.Equal(t, "printf 'no newline'", manager.ExecHistory[0].Command)
+		assert.Equal(t, 0, manager.ExecHistory[0].Code)
+		assert.Equal(t, "no ", manager.ExecHistory[0].Output, "Output up to the last word before the prompt is kept")
+		assert.Equal(t, "printf 'one two'; false", manager.ExecHistory[1].Command)
+		assert.Equal(t, 1, manager.ExecHistory[1].Code)
+	}
+}
+
+// Test ExecWaitCapture returns promptly when a full-screen program takes over the pane
+func TestExecWaitCapture_InteractiveProgram(t *testing.T) {
+	originalSend := system.TmuxSendCommandToPane
+	originalCapture := system.TmuxCapturePane
+	originalAlternateOn := system.TmuxPaneAlternateOn
+	defer func() {
+		system.TmuxSendCommandToPane = originalSend
+		system.TmuxCapturePane = originalCapture
+		system.TmuxPaneAlternateOn = originalAlternateOn
+	}()
+
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "~\n~\n\"notes.md\" 0L, 0B", nil
+	}
+	system.TmuxPaneAlternateOn = func(paneId string) (bool, error) {
+		return true, nil
+	}
+
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		Status:           "running",
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane"},
+	}
+
+	done := make(chan struct{})
+	var result CommandExecHistory
+	var err error
+	go func() {
+		result, err = manager.ExecWaitCapture("vim notes.md")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("ExecWaitCapture kept waiting for a prompt behind an interactive program")
+	}
+
+	assert.ErrorIs(t, err, ErrInteractiveProgram)
+	assert.Equal(t, "vim notes.md", result.Command)
+	assert.Equal(t, -1, result.Code)
+	assert.Contains(t, result.Output, "Interactive program")
+}
+
+// Test a multi-byte character split at the capture boundary doesn't break parsing
+func TestParseExecPaneCommandHistory_PartialUTF8(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+
+	// "\xe2\x9c" is the start of "✓" (e2 9c 93) cut off mid-sequence
+	testContent := "\x9c\x93 done\nuser@hostname:~[14:30][0]» echo caf\xc3\n\xe2\x9c\nuser@hostname:~[14:31][0]» "
+
+	manager.parseExecPaneCommandHistoryWithContent(testContent)
+
+	assert.True(t, utf8.ValidString(manager.ExecPane.Content), "Content should be valid UTF-8")
+	assert.Len(t, manager.ExecHistory, 1)
+	assert.Equal(t, "echo caf", manager.ExecHistory[0].Command)
+	assert.Equal(t, 0, manager.ExecHistory[0].Code)
+	assert.True(t, utf8.ValidString(manager.ExecHistory[0].Output))
+}
+
+// Test the completion marker gives command boundaries and exit codes without a prepared prompt
+func TestParseExecPaneCommandHistory_CompletionMarker(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+
+	testContent := `Last login: Mon Jan 15 10:00:00
+user@remote:~$ ls /missing; echo __TMUXAI_DONE_$?__
+ls: cannot access '/missing': No such file or directory
+__TMUXAI_DONE_2__
+user@remote:~$ echo hi; echo __TMUXAI_DONE_$?__
+hi
+__TMUXAI_DONE_0__
+user@remote:~$ `
+
+	manager.parseExecPaneCommandHistoryWithContent(testContent)
+
+	assert.Len(t, manager.ExecHistory, 2)
+	assert.Equal(t, "ls /missing", manager.ExecHistory[0].Command)
+	assert.Equal(t, "ls: cannot access '/missing': No such file or directory", manager.ExecHistory[0].Output)
+	assert.Equal(t, 2, manager.ExecHistory[0].Code)
+	assert.Equal(t, "echo hi", manager.ExecHistory[1].Command)
+	assert.Equal(t, "hi", manager.ExecHistory[1].Output)
+	assert.Equal(t, 0, manager.ExecHistory[1].Code)
+
+	assert.Equal(t, "make; echo __TMUXAI_DONE_123456_$status__", withCompletionMarker("fish", "make", "123456"))
+}
+
+// Test nushell and PowerShell get a completion marker they can run, which parses back to the exit code
+func TestWithCompletionMarker_NuAndPwsh(t *testing.T) {
+	nu := withCompletionMarker("nu", "ls /missing", "123456")
+	assert.Equal(t, `ls /missing; echo $"__TMUXAI_DONE_123456_($env.LAST_EXIT_CODE)__"`, nu)
+	pwsh := withCompletionMarker("pwsh", "Get-Item /missing", "123456")
+	assert.Equal(t, `Get-Item /missing; echo "__TMUXAI_DONE_123456_$(if ($?) { 0 } elseif ($LASTEXITCODE) { $LASTEXITCODE } else { 1 })__"`, pwsh)
+
+	for _, tc := range []struct {
+		prompt, commandLine, command string
+	}{
+		{"~> ", nu, "ls /missing"},
+		{"PS /home/user> ", pwsh, "Get-Item /missing"},
+	} {
+		manager := &Manager{
+			Config:           &config.Config{MaxCaptureLines: 1000},
+			SessionOverrides: make(map[string]interface{}),
+			ExecPane:         &system.TmuxPaneDetails{},
+			execPaneMark:     "123456",
+		}
+		manager.parseExecPaneCommandHistoryWithContent(tc.prompt + tc.commandLine + "\nnot found\n__TMUXAI_DONE_123456_1__\n" + tc.prompt)
+		if assert.Len(t, manager.ExecHistory, 1, tc.command) {
+			assert.Equal(t, tc.command, manager.ExecHistory[0].Command)
+			assert.Equal(t, "not found", manager.ExecHistory[0].Output)
+			assert.Equal(t, 1, manager.ExecHistory[0].Code)
+		}
+	}
+}
+
+// Test only output from the marked command line on is attributed to the new command
+func TestParseExecPaneCommandHistory_SinceMark(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+
+	// the same command with the same output ran twice before
+	run := func(mark string, output string, code int) string {
+		return fmt.Sprintf("user@remote:~$ make test; echo __TMUXAI_DONE_%s_$?__\n%s\n__TMUXAI_DONE_%s_%d__\n", mark, output, mark, code)
+	}
+	old := run("111111", "ok", 0) + run("222222", "ok", 0)
+	typed := old + "user@remote:~$ make test; echo __TMUXAI_DONE_333333_$?__"
+	after := old + run("333333", "ok", 0) + "user@remote:~$ "
+
+	manager.parseExecPaneCommandHistoryWithContent(after)
+	assert.Len(t, manager.ExecHistory, 3, "Without a mark the old runs are parsed too")
+
+	manager.execPaneMark = "333333"
+	manager.parseExecPaneCommandHistoryWithContent(old + "user@remote:~$ ")
+	assert.Empty(t, manager.ExecHistory, "Old runs aren't taken for the new one before it shows up")
+	manager.parseExecPaneCommandHistoryWithContent(typed)
+	if assert.Len(t, manager.ExecHistory, 1) {
+		assert.Equal(t, -1, manager.ExecHistory[0].Code, "Still running")
+	}
+	manager.parseExecPaneCommandHistoryWithContent(after)
+	if assert.Len(t, manager.ExecHistory, 1) {
+		assert.Equal(t, "make test", manager.ExecHistory[0].Command)
+		assert.Equal(t, "ok", manager.ExecHistory[0].Output)
+		assert.Equal(t, 0, manager.ExecHistory[0].Code)
+	}
+
+	// a command line that scrolled out of the capture after finishing leaves the content untouched
+	scrolled := "ok\n__TMUXAI_DONE_333333_0__\nuser@remote:~$ "
+	assert.Equal(t, scrolled, contentSinceMark(scrolled, "333333"))
+}
+
+// Test re-running a command with the same output waits for the new run instead of timing out or taking the old one
+func TestExecWaitMarker_RepeatedCommand(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, WaitInterval: 3},
+		SessionOverrides: make(map[string]interface{}),
+		Status:           "running",
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane", Shell: "bash"},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+
+	pane := "user@remote:~$ uptime; echo __TMUXAI_DONE_$?__\nup 3 days\n__TMUXAI_DONE_0__\nuser@remote:~$ "
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		mark := regexp.MustCompile(`__TMUXAI_DONE_(\d+)_`).FindStringSubmatch(command)[1]
+		pane = strings.TrimSuffix(pane, "user@remote:~$ ") + "user@remote:~$ " + command + "\nup 3 days\n__TMUXAI_DONE_" + mark + "_1__\nuser@remote:~$ "
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return pane, nil
+	}
+
+	result, err := manager.ExecWaitMarker("uptime")
+	assert.NoError(t, err)
+	assert.Equal(t, "up 3 days", result.Output)
+	assert.Equal(t, 1, result.Code, "The exit code is the new run's")
+}
+
+// Test: A sudo password prompt is answered through the secure reader and the password is redacted
+func TestExecWaitCapture_SudoPrompt(t *testing.T) {
+	originalSend := system.TmuxSendCommandToPane
+	originalSendSecret := system.TmuxSendSecretToPane
+	originalCapture := system.TmuxCapturePane
+	originalAlternateOn := system.TmuxPaneAlternateOn
+	originalReadSecret := readSecret
+	defer func() {
+		system.TmuxSendCommandToPane = originalSend
+		system.TmuxSendSecretToPane = originalSendSecret
+		system.TmuxCapturePane = originalCapture
+		system.TmuxPaneAlternateOn = originalAlternateOn
+		readSecret = originalReadSecret
+	}()
+
+	var sentSecrets []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		return nil
+	}
+	system.TmuxSendSecretToPane = func(paneId string, secret string) error {
+		sentSecrets = append(sentSecrets, secret)
+		return nil
+	}
+	system.TmuxPaneAlternateOn = func(paneId string) (bool, error) {
+		return false, nil
+	}
+	// the first password is wrong, each prompt stays the last line for a few captures after it's answered
+	captures := 0
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		captures++
+		content := "user@host:~[10:00][0]» sudo whoami\n[sudo] password for user:"
+		if len(sentSecrets) >= 1 {
+			content += "\nSorry, try again.\n[sudo] password for user:"
+		}
+		if len(sentSecrets) >= 2 && captures > 6 {
+			content += "\nhunter2 echoed back, not hunter2x\nroot\nuser@host:~[10:00][0]»"
+		}
+		return content, nil
+	}
+	var prompts []string
+	readSecret = func(prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		if len(prompts) == 1 {
+			return "wrong", nil
+		}
+		return "hunter2", nil
+	}
+
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		Status:           "running",
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane"},
+	}
+
+	output := captureOutput(t, func() {
+		_, _ = manager.ExecWaitCapture("sudo whoami")
+	})
+
+	assert.Equal(t, []string{"[sudo] password for user: ", "[sudo] password for user: "}, prompts, "Each prompt is answered once")
+	assert.Equal(t, []string{"wrong", "hunter2"}, sentSecrets)
+	assert.NotContains(t, output, "hunter2")
+	if assert.Len(t, manager.ExecHistory, 1) {
+		assert.Equal(t, "[sudo] password for user:\nSorry, try again.\n[sudo] password for user:\n[REDACTED] echoed back, not hunter2x\nroot", manager.ExecHistory[0].Output)
+	}
+	for _, msg := range manager.Messages {
+		assert.NotContains(t, msg.Content, "hunter2")
+	}
+}
+
+// Test only whole words are redacted, a short password doesn't mangle the text around it
+func TestRedactSecrets_WholeWords(t *testing.T) {
+	manager := &Manager{}
+	manager.addSecret("ab")
+	assert.Equal(t, "[REDACTED]\nabout tab ab1 [REDACTED] x", manager.redactSecrets("ab\nabout tab ab1 ab x"))
+}
+
+// Test PrepareExecPaneWithShell wipes the scrollback before setting the prompt when prepare_reset_history is on
+func TestPrepareExecPaneWithShell_ResetHistory(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareResetHistory: true},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{Id: "test-pane"},
+	}
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	originalTmuxClear := system.TmuxClearPane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+		system.TmuxClearPane = originalTmuxClear
+	}()
+
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "", nil
+	}
+	system.TmuxClearPane = func(paneId string) error {
+		commandsSent = append(commandsSent, "clear-history "+paneId)
+		return nil
+	}
+
+	manager.PrepareExecPaneWithShell("bash")
+	if assert.Len(t, commandsSent, 3) {
+		assert.Equal(t, "C-l", commandsSent[0])
+		assert.Equal(t, "clear-history test-pane", commandsSent[1])
+		assert.Contains(t, commandsSent[2], "PS1=")
+	}
+}
+
+// Test: A custom exec_prompt_regex parses prompts that /prepare didn't set up, e.g. on an SSH host
+func TestExecWaitCapture_CustomPromptRegex(t *testing.T) {
+	manager := &Manager{
+		ExecHistory:      []CommandExecHistory{},
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		Status:           "running",
+		ExecPane:         &system.TmuxPaneDetails{Id: "ssh-pane"},
+	}
+	captureOutput(t, func() {
+		manager.ProcessSubCommand(`/config set exec_prompt_regex ^\S+@\S+:\S+ \[(?P<code>\d+)\] \$ ?(?P<command>.*)$`)
+	})
+
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	defer func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+	}()
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error { return nil }
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return `user@remote-server:~ [0] $ ls missing
+ls: cannot access 'missing': No such file or directory
+user@remote-server:~ [2] $ `, nil
+	}
+
+	result, err := manager.ExecWaitCapture("ls missing")
+	assert.NoError(t, err)
+	assert.Equal(t, "ls missing", result.Command)
+	assert.Equal(t, "ls: cannot access 'missing': No such file or directory", result.Output)
+	assert.Equal(t, 2, result.Code)
+}
+
+// Test: An exec_prompt_regex that doesn't compile or lacks the required groups falls back to the default
+func TestExecPromptRegex_Fallback(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{ExecPromptRegex: `^[unclosed`},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+	assert.Equal(t, defaultExecPromptRegex, manager.execPromptRegex().String())
+
+	manager.SessionOverrides["exec_prompt_regex"] = `^(\d+)\$ (.*)$`
+	assert.Equal(t, defaultExecPromptRegex, manager.execPromptRegex().String(), "Unnamed groups aren't enough")
+
+	manager.parseExecPaneCommandHistoryWithContent(`user@hostname:~[10:00][0]» echo hi
+hi
+user@hostname:~[10:00][0]» `)
+	assert.Len(t, manager.ExecHistory, 1)
+	assert.Equal(t, "echo hi", manager.ExecHistory[0].Command)
+}
+
+func TestSetExecPane(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		PaneId:           "%0",
+		ExecPane:         &system.TmuxPaneDetails{Id: "%1"},
+	}
+
+	originalTmuxResolvePane := system.TmuxResolvePane
+	originalTmuxCurrentPaneId := system.TmuxCurrentPaneId
+	originalTmuxPanesDetails := system.TmuxPanesDetails
+	defer func() {
+		system.TmuxResolvePane = originalTmuxResolvePane
+		system.TmuxCurrentPaneId = originalTmuxCurrentPaneId
+		system.TmuxPanesDetails = originalTmuxPanesDetails
+	}()
+
+	system.TmuxResolvePane = func(target string) (string, string, error) {
+		switch target {
+		case "work:2.1", "%7":
+			return "%7", "$1:2", nil
+		case "%0":
+			return "%0", "$0:0", nil
+		}
+		return "", "", fmt.Errorf("can't find pane: %s", target)
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "%0", nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		if windowTarget == "$1:2" {
+			return []system.TmuxPaneDetails{{Id: "%6"}, {Id: "%7", CurrentCommand: "zsh"}}, nil
+		}
+		return []system.TmuxPaneDetails{{Id: "%0"}, {Id: "%1"}}, nil
+	}
+
+	// a target in another window resolves the pane and watches its window
+	assert.NoError(t, manager.SetExecPane("work:2.1"))
+	assert.Equal(t, "%7", manager.ExecPane.Id)
+	assert.Equal(t, "zsh", manager.ExecPane.CurrentCommand)
+	assert.Equal(t, "$1:2", manager.WindowTarget)
+
+	// unknown panes and the TmuxAI pane itself fail without changing the exec pane
+	err := manager.SetExecPane("%42")
+	assert.ErrorContains(t, err, "pane %42 not found")
+	err = manager.SetExecPane("%0")
+	assert.ErrorContains(t, err, "TmuxAI pane itself")
+	assert.Equal(t, "%7", manager.ExecPane.Id)
+}
+
+// Test a pane prepared by a previous run is reused at startup instead of being prepared again
+func TestReusePreparedExecPane(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000, PrepareClearScreen: true},
+		SessionOverrides: make(map[string]interface{}),
+		Messages:         []ChatMessage{},
+		ExecPane:         &system.TmuxPaneDetails{},
+	}
+
+	restoreTmuxMocks(t)
+
+	var commandsSent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		commandsSent = append(commandsSent, command)
+		return nil
+	}
+	content := "$ make\nok\nuser@host:~/src[10:42][0]» "
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return content, nil
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "main-pane", nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "main-pane"}, {Id: "test-pane", CurrentCommand: "bash"}}, nil
+	}
+
+	// startup
+	manager.InitExecPane()
+	assert.True(t, manager.reusePreparedExecPane())
+	assert.True(t, manager.ExecPane.IsPrepared)
+	assert.Equal(t, "bash", manager.preparedShell)
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/prepare") })
+	assert.Empty(t, commandsSent, "A reused pane shouldn't be prepared again")
+	assert.Contains(t, output, "The exec pane is already prepared")
+	assert.True(t, manager.ExecPane.IsPrepared)
+
+	// a pane showing its own prompt is prepared as before
+	content = "user@host:~/src$ "
+	manager.preparedShell = ""
+	manager.InitExecPane()
+	assert.False(t, manager.reusePreparedExecPane())
+	assert.False(t, manager.ExecPane.IsPrepared)
+	captureOutput(t, func() { manager.ProcessSubCommand("/prepare") })
+	if assert.NotEmpty(t, commandsSent) {
+		assert.Contains(t, commandsSent[0], "PS1=")
+	}
+}
+
+// Test /pane select switches the exec pane and detects whether the new one is prepared
+func TestProcessSubCommand_PaneSelect(t *testing.T) {
+	manager := &Manager{
+		Config:           &config.Config{MaxCaptureLines: 1000},
+		SessionOverrides: make(map[string]interface{}),
+		Messages:         []ChatMessage{},
+		PaneId:           "%0",
+		ExecPane:         &system.TmuxPaneDetails{Id: "%1", IsPrepared: true},
+		ExecHistory:      []CommandExecHistory{{Command: "ls", Code: 0}},
+		preparedShell:    "bash",
+	}
+
+	restoreTmuxMocks(t)
+
+	system.TmuxResolvePane = func(target string) (string, string, error) {
+		if target == "%1" || target == "%2" {
+			return target, "$0:0", nil
+		}
+		return "", "", fmt.Errorf("can't find pane: %s", target)
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "user@host:~/src$ ", nil
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "%0", nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{
+			{Id: "%0", CurrentCommand: "tmuxai"},
+			{Id: "%1", CurrentCommand: "bash", Title: "build"},
+			{Id: "%2", CurrentCommand: "zsh", Title: "logs"},
+		}, nil
+	}
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/pane list") })
+	assert.Contains(t, output, "* %1  bash  \"build\"  (exec pane)")
+	assert.Contains(t, output, "%2  zsh  \"logs\"")
+	assert.Contains(t, output, "(TmuxAI)")
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/pane select %2") })
+	assert.Equal(t, "%2", manager.ExecPane.Id)
+	assert.Equal(t, "zsh", manager.ExecPane.CurrentCommand)
+	assert.False(t, manager.ExecPane.IsPrepared, "The new pane shows its own prompt")
+	assert.Empty(t, manager.preparedShell)
+	assert.Empty(t, manager.ExecHistory, "Exec history belongs to the previous pane")
+	assert.Contains(t, output, "Exec pane set to %2, mode: observe")
+
+	// unknown panes leave the exec pane alone
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/pane select %9") })
+	assert.Contains(t, output, "pane %9 not found")
+	assert.Equal(t, "%2", manager.ExecPane.Id)
+}
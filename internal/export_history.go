@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// ansiEscapeRegex matches terminal color and cursor sequences, e.g. from highlighted pane content
+	ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	// exportExecRegex matches the commands in an AI response, exported as shell code blocks
+	exportExecRegex = regexp.MustCompile(`(?s)<ExecCommand(?:\s[^>]*)?>(.*?)</ExecCommand>`)
+	// exportPaneStateRegex matches the pane state sent with a user message, exported as a code block
+	exportPaneStateRegex = regexp.MustCompile(`(?s)<current_tmux_window_state>.*?</current_tmux_window_state>`)
+	// exportBlankLinesRegex matches runs of blank lines left where tags were replaced
+	exportBlankLinesRegex = regexp.MustCompile(`\n{3,}`)
+)
+
+// exportHistoryMarkdown renders the chat history as Markdown, in the format /import-history reads back
+func exportHistoryMarkdown(messages []ChatMessage) string {
+	var b strings.Builder
+	b.WriteString("# TmuxAI session\n")
+	for _, msg := range messages {
+		role := "Assistant"
+		content := exportExecRegex.ReplaceAllString(msg.Content, "\n```sh\n$1\n```\n")
+		if msg.FromUser {
+			role = "User"
+			content = exportPaneStateRegex.ReplaceAllStringFunc(msg.Content, func(state string) string {
+				return "```xml\n" + state + "\n```"
+			})
+		}
+		content = ansiEscapeRegex.ReplaceAllString(content, "")
+		b.WriteString(fmt.Sprintf("\n## %s (%s)\n\n", role, msg.Timestamp.Format(importTimestampLayout)))
+		b.WriteString(exportBlankLinesRegex.ReplaceAllString(strings.TrimSpace(content), "\n\n") + "\n")
+	}
+	return b.String()
+}
+
+// exportHistory writes the chat history as Markdown to path, or a timestamped file in the working directory
+func (m *Manager) exportHistory(path string) {
+	if len(m.Messages) == 0 {
+		m.Println("Nothing to export, the chat history is empty")
+		return
+	}
+	if path == "" {
+		path = fmt.Sprintf("tmuxai-%s.md", time.Now().Format("20060102-150405"))
+	}
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(exportHistoryMarkdown(m.Messages)), 0o600); err != nil {
+		m.Println(fmt.Sprintf("Failed to export to %s: %v", path, err))
+		return
+	}
+	m.Println(fmt.Sprintf("Exported %d messages to %s", len(m.Messages), path))
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/nyaosorg/go-readline-ny/simplehistory"
+)
+
+// projectRoot returns the nearest directory at or above dir containing .git, or dir itself
+func projectRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir
+		}
+		current = parent
+	}
+}
+
+// historyFileFor returns the history file in configDir, keyed on the project root when one is given
+func historyFileFor(configDir string, root string) string {
+	if root == "" {
+		return filepath.Join(configDir, "history")
+	}
+	sum := sha256.Sum256([]byte(root))
+	name := filepath.Base(root) + "-" + hex.EncodeToString(sum[:])[:8]
+	return filepath.Join(configDir, "history.d", name)
+}
+
+// historyFilePath returns the readline history file for this session
+func (m *Manager) historyFilePath() string {
+	configDir, _ := config.GetConfigDir()
+	if !m.Config.HistoryPerProject {
+		return historyFileFor(configDir, "")
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return historyFileFor(configDir, "")
+	}
+	return historyFileFor(configDir, projectRoot(cwd))
+}
+
+// loadHistory reads a history file into a readline history, ignoring a missing file
+func loadHistory(path string) *simplehistory.Container {
+	history := simplehistory.New()
+	if historyData, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(historyData), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				history.Add(line)
+			}
+		}
+	}
+	return history
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryFileFor_PerProject(t *testing.T) {
+	configDir := t.TempDir()
+	projects := t.TempDir()
+
+	api := filepath.Join(projects, "api")
+	web := filepath.Join(projects, "web")
+	assert.NoError(t, os.MkdirAll(filepath.Join(api, ".git"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(api, "cmd", "server"), 0o755))
+	assert.NoError(t, os.MkdirAll(web, 0o755))
+
+	assert.Equal(t, api, projectRoot(filepath.Join(api, "cmd", "server")), "Subdirectories resolve to the git root")
+	assert.Equal(t, web, projectRoot(web), "Without a git root the directory itself is used")
+
+	apiPath := historyFileFor(configDir, projectRoot(api))
+	webPath := historyFileFor(configDir, projectRoot(web))
+	assert.NotEqual(t, apiPath, webPath)
+	assert.Equal(t, filepath.Join(configDir, "history"), historyFileFor(configDir, ""))
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(apiPath), 0o755))
+	assert.NoError(t, os.WriteFile(apiPath, []byte("go test ./...\nmake build\n"), 0o644))
+	assert.NoError(t, os.WriteFile(webPath, []byte("npm run dev\n"), 0o644))
+
+	history := loadHistory(apiPath)
+	assert.Equal(t, 2, history.Len())
+	assert.Equal(t, "make build", history.At(1))
+
+	history = loadHistory(webPath)
+	assert.Equal(t, 1, history.Len())
+	assert.Equal(t, "npm run dev", history.At(0))
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// importHeadingRegex starts a Markdown block, e.g. "## User (2026-01-02 15:04:05)" or "### Assistant"
+	importHeadingRegex = regexp.MustCompile(`(?i)^#{1,3}\s*(user|you|assistant|ai|tmuxai)\s*(?:\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\))?\s*$`)
+	// importPrefixRegex starts a plain text block, e.g. "User: list files"
+	importPrefixRegex = regexp.MustCompile(`(?i)^(user|you|assistant|ai|tmuxai):\s?(.*)$`)
+)
+
+// importTimestampLayout is the timestamp format in Markdown headings
+const importTimestampLayout = "2006-01-02 15:04:05"
+
+// parseImportedHistory reads alternating user and assistant blocks, either Markdown sections under
+// "## User" / "## Assistant" headings or lines starting with "User:" / "Assistant:", continued until the next block
+func parseImportedHistory(r io.Reader) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	var current *ChatMessage
+	var content []string
+
+	flush := func() {
+		if current != nil {
+			current.Content = strings.TrimSpace(strings.Join(content, "\n"))
+			if current.Content != "" {
+				messages = append(messages, *current)
+			}
+		}
+		content = nil
+	}
+	start := func(role string, timestamp time.Time) {
+		flush()
+		role = strings.ToLower(role)
+		current = &ChatMessage{FromUser: role == "user" || role == "you", Timestamp: timestamp}
+	}
+
+	// lines inside a ``` fenced block belong to the message, even when they look like a heading or prefix
+	inFence := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		if fence {
+			inFence = !inFence
+		}
+		if inFence || fence {
+			if current != nil {
+				content = append(content, line)
+			}
+			continue
+		}
+		if match := importHeadingRegex.FindStringSubmatch(line); match != nil {
+			timestamp := time.Now()
+			if parsed, err := time.ParseInLocation(importTimestampLayout, match[2], time.Local); err == nil {
+				timestamp = parsed
+			}
+			start(match[1], timestamp)
+			continue
+		}
+		if match := importPrefixRegex.FindStringSubmatch(line); match != nil {
+			start(match[1], time.Now())
+			content = append(content, match[2])
+			continue
+		}
+		if current != nil {
+			content = append(content, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no user or assistant blocks found")
+	}
+	return messages, nil
+}
+
+// importHistory appends the conversation in the file at path to the chat history
+func (m *Manager) importHistory(path string) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		m.Println(fmt.Sprintf("Failed to open %s: %v", path, err))
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	messages, err := parseImportedHistory(f)
+	if err != nil {
+		m.Println(fmt.Sprintf("Failed to import %s: %v", path, err))
+		return
+	}
+	m.Messages = append(m.Messages, messages...)
+	m.Println(fmt.Sprintf("Imported %d messages from %s", len(messages), path))
+}
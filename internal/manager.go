@@ -1,9 +1,13 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alvinunreal/tmuxai/config"
@@ -16,6 +20,7 @@ type AIResponse struct {
 	Message                string
 	SendKeys               []string
 	ExecCommand            []string
+	ExecCommandAttrs       []ExecCommandAttrs // parallel to ExecCommand
 	PasteMultilineContent  string
 	RequestAccomplished    bool
 	ExecPaneSeemsBusy      bool
@@ -23,6 +28,17 @@ type AIResponse struct {
 	NoComment              bool
 }
 
+// ResponseHook rewrites a raw AI response before it's parsed and displayed, e.g. to redact or strip text
+type ResponseHook func(response string) string
+
+// ExecCommandAttrs holds the optional attributes the AI attached to an ExecCommand tag
+type ExecCommandAttrs struct {
+	Desc    string
+	Isolate *bool  // nil when the AI didn't ask either way
+	Quiet   bool   // only the exit code is reported back, not the output
+	Var     string // name to store the output under, referenced as ${name} in later commands
+}
+
 // Parsed only when pane is prepared
 type CommandExecHistory struct {
 	Command string
@@ -37,30 +53,122 @@ type Manager struct {
 	Status           string // running, waiting, done
 	PaneId           string
 	ExecPane         *system.TmuxPaneDetails
+	WindowTarget     string // tmux window whose panes are captured, empty means current window
 	Messages         []ChatMessage
 	ExecHistory      []CommandExecHistory
 	WatchMode        bool
 	OS               string
 	CurrentPersona   string
 	SessionOverrides map[string]interface{} // session-only config overrides
+	Usage            Usage                  // cumulative token usage for this session
+	LastRawResponse  string                 // unparsed text of the last AI response
+	Turns            int                    // number of AI completions in this session
+	StartedAt        time.Time
+	ProjectContext   string         // contents of the project instructions file, if any
+	LastTurnSnapshot string         // exec pane content captured at the end of the last turn
+	ResponseHooks    []ResponseHook // applied in order to each AI response before parsing
+	FullCaptureNext  bool           // capture the exec pane's whole scrollback on the next turn only
+	// context window of each model as reported by the provider, fetched in the background at startup
+	ModelContextWindows map[string]int
+	contextWindowsMu    sync.Mutex
+
+	// serializes typed input with prompts from the remote control API
+	inputMu sync.Mutex
+	// set while running a prompt from the remote control API, nobody is at the terminal to confirm
+	remoteRequest bool
+
+	// action types approved in the current top-level turn, used by confirm_once_per_type
+	turnApprovals map[string]bool
+	// set while confirming a command, which can be approved for the rest of the session
+	offerAlways bool
+	// the command as the AI wrote it, before ${name} substitution, matched by the whitelist and "always"
+	approvalCommand string
+	// the output filter result for the last exec history entry, so the filter runs once per command
+	lastFilteredOutput *filteredOutput
+	// why the last confirmed command ran without a prompt, e.g. approvedByWhitelist, "" when it was prompted for
+	approvedWithoutPrompt string
+	// set when the last confirmation was answered with skip, which declines only that action
+	confirmSkipped bool
+	// the current top-level request and the commands executed for it, used by
+	// turn_deadline_sec and command_log_file
+	turnRequest  string
+	turnCommands []string
+	// whether the AI was already asked to verify its RequestAccomplished this turn, see require_verification
+	verificationRequested bool
+	// blocked commands and disabled actions refused this turn, capped by maxRefusals
+	turnRefusals int
+
+	// the mark in the completion marker of a command sent without a prepared prompt, only output from its line on is parsed
+	execPaneMark string
+
+	// macOS portability hints for the commands of the last response, sent with the next message
+	portabilityHints []string
+
+	// compiled exec_prompt_regex and the pattern it was compiled from
+	promptRegex       *regexp.Regexp
+	promptRegexSource string
+
+	// exec pane content bookmarked with /capture, and the captures to send with the next message
+	captures        map[string]string
+	pendingCaptures []PaneCapture
+
+	// results of quiet="true" commands whose output is kept from the AI, and those to report with the next message
+	quietOutputs        []CommandExecHistory
+	pendingQuietResults []CommandExecHistory
+
+	// what the current or last watch mode watches for, kept by /save
+	watchGoal string
+
+	// watch mode: hash of the last polled pane content, since when it's unchanged, and whether polling the AI is paused
+	watchContentHash    string
+	watchUnchangedSince time.Time
+	watchPaused         bool
+
+	// outputs stored with <ExecCommand var="name">, substituted for ${name} in later commands
+	commandVars map[string]string
+
+	// consecutive empty responses in this request, bounded by empty_response_retries
+	emptyResponses int
+
+	// the model max_session_cost_usd was last reported unenforceable for, it has no pricing
+	unpricedCapWarned string
+
+	// the shell the exec pane was last prepared for, whose prompt /mode observe restores
+	preparedShell string
+
+	// set while recursing after a guideline failure, the retry stores only its correction
+	guidelineRetry bool
+
+	// /trace: trace the next turn, and whether the current one is traced
+	traceNext bool
+	tracing   bool
+
+	// passwords typed into the exec pane on the user's behalf, redacted from captured content
+	secrets []string
 
 	// Functions for mocking
 	confirmedToExec   func(command string, prompt string, edit bool) (bool, string)
 	getTmuxPanesInXml func(config *config.Config) string
 }
 
-// NewManager creates a new manager agent
-func NewManager(cfg *config.Config) (*Manager, error) {
-	if cfg.OpenRouter.APIKey == "" && cfg.AzureOpenAI.APIKey == "" {
-		fmt.Println("An API key is required. Set OpenRouter or Azure OpenAI credentials in the config file or environment variables.")
+// NewManager creates a new manager agent, using execPane (an id or target) as the exec pane when given
+// instead of picking or creating one
+func NewManager(cfg *config.Config, execPane string) (*Manager, error) {
+	if cfg.OpenRouter.APIKey == "" && cfg.AzureOpenAI.APIKey == "" && cfg.Anthropic.APIKey == "" && cfg.Ollama.Model == "" {
+		fmt.Println("An API key is required. Set OpenRouter, Azure OpenAI or Anthropic credentials, or an Ollama model, in the config file or environment variables.")
 		return nil, fmt.Errorf("API key required")
 	}
 
-	paneId, err := system.TmuxCurrentPaneId()
-	if err != nil {
+	if err := system.TmuxPreflight(); err != nil {
+		if errors.Is(err, system.ErrTmuxNotInstalled) {
+			fmt.Println("tmux is not installed or not in your PATH. Install tmux (e.g. 'brew install tmux' or 'apt install tmux') and try again.")
+			return nil, err
+		}
+
 		// If we're not in a tmux session, start a new session and execute the same command
-		paneId, err = system.TmuxCreateSession()
+		paneId, err := system.TmuxCreateSession()
 		if err != nil {
+			fmt.Println("Not running inside tmux and a new tmux session could not be started. Run 'tmux' first and start tmuxai from inside it.")
 			return nil, fmt.Errorf("system.TmuxCreateSession failed: %w", err)
 		}
 		args := strings.Join(os.Args[1:], " ")
@@ -76,7 +184,13 @@ func NewManager(cfg *config.Config) (*Manager, error) {
 		os.Exit(0)
 	}
 
+	paneId, err := system.TmuxCurrentPaneId()
+	if err != nil {
+		return nil, fmt.Errorf("system.TmuxCurrentPaneId failed: %w", err)
+	}
+
 	aiClient := NewAiClient(cfg)
+	cwd, _ := os.Getwd()
 	os := system.GetOSDetails()
 
 	manager := &Manager{
@@ -87,17 +201,75 @@ func NewManager(cfg *config.Config) (*Manager, error) {
 		ExecPane:         &system.TmuxPaneDetails{},
 		OS:               os,
 		SessionOverrides: make(map[string]interface{}),
+		StartedAt:        time.Now(),
 	}
 
+	go manager.fetchModelContextWindows(aiClient)
+
 	manager.confirmedToExec = manager.confirmedToExecFn
 	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
 
 	manager.CurrentPersona = manager.selectPersona()
 	logger.Debug("Selected persona: %s", manager.CurrentPersona)
+	if cwd != "" {
+		manager.loadProjectContext(cwd)
+	}
+	if execPane != "" {
+		if err := manager.useExecPane(execPane); err != nil {
+			fmt.Println("Invalid --pane: " + err.Error())
+			return nil, err
+		}
+		return manager, nil
+	}
 	manager.InitExecPane()
+	manager.reusePreparedExecPane()
 	return manager, nil
 }
 
+// loadConfig reads the config file and environment, overridable in tests
+var loadConfig = config.Load
+
+// reloadConfig re-reads the config and swaps it in, keeping chat history and session overrides
+func (m *Manager) reloadConfig() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	providerChanged := !reflect.DeepEqual(cfg.OpenRouter, m.Config.OpenRouter) ||
+		!reflect.DeepEqual(cfg.AzureOpenAI, m.Config.AzureOpenAI) ||
+		!reflect.DeepEqual(cfg.Anthropic, m.Config.Anthropic) ||
+		cfg.Ollama != m.Config.Ollama ||
+		cfg.HTTP != m.Config.HTTP
+
+	m.Config = cfg
+	if providerChanged || m.AiClient == nil {
+		m.AiClient = NewAiClient(cfg)
+		logger.Info("Provider settings changed, rebuilt AI client")
+	} else {
+		// settings like redact_secrets are read from the client's config on every request
+		m.AiClient.config = cfg
+	}
+
+	// output_filters may have changed
+	m.lastFilteredOutput = nil
+
+	if _, ok := cfg.Personas[m.CurrentPersona]; !ok {
+		m.CurrentPersona = m.selectPersona()
+	}
+
+	logger.Info("Config reloaded")
+	return nil
+}
+
+// applyResponseHooks runs the response through every ResponseHook in order
+func (m *Manager) applyResponseHooks(response string) string {
+	for _, hook := range m.ResponseHooks {
+		response = hook(response)
+	}
+	return response
+}
+
 // selectPersona selects the appropriate persona based on rules or defaults
 func (m *Manager) selectPersona() string {
 	// If there are persona rules, use the first one's persona
@@ -122,6 +294,17 @@ func (m *Manager) Start(initMessage string) error {
 	return nil
 }
 
+// RunScript processes each non-empty line of a script file in order
+func (m *Manager) RunScript(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return NewCLIInterface(m).runScript(f)
+}
+
 func (m *Manager) Println(msg string) {
 	fmt.Println(m.GetPrompt() + msg)
 }
@@ -151,6 +334,10 @@ func (m *Manager) GetPrompt() string {
 		stateSymbol = "∞"
 	}
 
+	if format := m.Config.PromptFormat; format != "" {
+		return m.expandPromptFormat(format, stateSymbol)
+	}
+
 	prompt := tmuxaiColor.Sprint("TmuxAI")
 	if stateSymbol != "" {
 		prompt += " " + stateColor.Sprint("["+stateSymbol+"]")
@@ -159,11 +346,61 @@ func (m *Manager) GetPrompt() string {
 	return prompt
 }
 
+// expandPromptFormat fills the prompt_format placeholders {model}, {shell}, {mode} and {state}
+func (m *Manager) expandPromptFormat(format string, stateSymbol string) string {
+	mode := "observe"
+	switch {
+	case m.WatchMode:
+		mode = "watch"
+	case m.ExecPane != nil && m.ExecPane.IsPrepared:
+		mode = "prepared"
+	}
+	shell := ""
+	if m.ExecPane != nil {
+		shell = m.ExecPane.Shell
+	}
+	return strings.NewReplacer(
+		"{model}", m.GetOpenRouterModel(),
+		"{shell}", shell,
+		"{mode}", mode,
+		"{state}", stateSymbol,
+	).Replace(format)
+}
+
+// isPlainAnswer reports whether the response is only text, with no actions or flags
+func (ai *AIResponse) isPlainAnswer() bool {
+	return len(ai.ExecCommand) == 0 && len(ai.SendKeys) == 0 && ai.PasteMultilineContent == "" &&
+		!ai.RequestAccomplished && !ai.ExecPaneSeemsBusy && !ai.WaitingForUserResponse && !ai.NoComment
+}
+
+// isRefusal reports whether a plain answer contains one of the configured refusal markers
+func (m *Manager) isRefusal(r AIResponse) bool {
+	if m.Config == nil || !r.isPlainAnswer() {
+		return false
+	}
+	message := strings.ToLower(strings.ReplaceAll(r.Message, "’", "'"))
+	for _, marker := range m.Config.RefusalMarkers {
+		if marker != "" && strings.Contains(message, strings.ToLower(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecAttrs returns the attributes of the i-th ExecCommand, or zero attributes if none were parsed
+func (ai *AIResponse) ExecAttrs(i int) ExecCommandAttrs {
+	if i < len(ai.ExecCommandAttrs) {
+		return ai.ExecCommandAttrs[i]
+	}
+	return ExecCommandAttrs{}
+}
+
 func (ai *AIResponse) String() string {
 	return fmt.Sprintf(`
 	Message: %s
 	SendKeys: %v
 	ExecCommand: %v
+	ExecCommandAttrs: %+v
 	PasteMultilineContent: %s
 	RequestAccomplished: %v
 	ExecPaneSeemsBusy: %v
@@ -173,6 +410,7 @@ func (ai *AIResponse) String() string {
 		ai.Message,
 		ai.SendKeys,
 		ai.ExecCommand,
+		ai.ExecCommandAttrs,
 		ai.PasteMultilineContent,
 		ai.RequestAccomplished,
 		ai.ExecPaneSeemsBusy,
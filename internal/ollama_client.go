@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+const defaultOllamaBaseURL = "http://localhost:11434"
+
+// ollamaChatRequest is the body of a request to Ollama's /api/chat
+type ollamaChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+// ollamaChatResponse is the part of an /api/chat response we read
+type ollamaChatResponse struct {
+	Message         Message `json:"message"`
+	Error           string  `json:"error"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+// ollamaModel returns the model for a request: an explicitly picked model like summary_model wins,
+// the configured chat model holds an OpenRouter id and is replaced with ollama.model
+func (c *AiClient) ollamaModel(model string) string {
+	if model != "" && model != c.config.OpenRouter.Model {
+		return model
+	}
+	return c.config.Ollama.Model
+}
+
+// ollamaCompletion sends the messages to a local Ollama server, no API key needed
+func (c *AiClient) ollamaCompletion(ctx context.Context, messages []Message, model string) (string, error) {
+	cfg := c.config.Ollama
+	model = c.ollamaModel(model)
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultOllamaBaseURL
+	}
+	url := strings.TrimSuffix(baseURL, "/") + "/api/chat"
+
+	reqJSON, err := json.Marshal(ollamaChatRequest{Model: model, Messages: messages, Stream: false})
+	if err != nil {
+		logger.Error("Failed to marshal request: %v", err)
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		logger.Error("Failed to create request: %v", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	logger.Debug("Sending Ollama API request to: %s with model: %s", url, model)
+
+	resp, err := c.do(req)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return "", fmt.Errorf("request canceled: %w", ctx.Err())
+		}
+		logger.Error("Failed to send request: %v", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response: %v", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	logger.Debug("API response status: %d, response size: %d bytes", resp.StatusCode, len(body))
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("API returned error: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("API returned error: %s", c.redactLog(string(body)))
+	}
+
+	var chatResp ollamaChatResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		logger.Error("Failed to unmarshal response: %v, body: %s", err, c.redactLog(string(body)))
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if chatResp.Error != "" {
+		return "", fmt.Errorf("API returned error: %s", chatResp.Error)
+	}
+	if chatResp.PromptEvalCount > 0 || chatResp.EvalCount > 0 {
+		usage := apiUsage{PromptTokens: chatResp.PromptEvalCount, CompletionTokens: chatResp.EvalCount}.normalize()
+		c.lastUsage = &usage
+	}
+	if chatResp.Message.Content == "" {
+		logger.Error("No message content returned. Raw response: %s", c.redactLog(string(body)))
+		return "", fmt.Errorf("no message content returned (model: %s, status: %d)", model, resp.StatusCode)
+	}
+	logger.Debug("Received AI response (%d characters): %s", len(chatResp.Message.Content), c.redactLog(chatResp.Message.Content))
+	return chatResp.Message.Content, nil
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// outputFilterTimeout bounds how long an external output filter may run
+const outputFilterTimeout = 10 * time.Second
+
+// runOutputFilter pipes input through the filter command via the shell and returns its stdout, overridable in tests
+var runOutputFilter = func(filterCommand string, input string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), outputFilterTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", filterCommand)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// filterCommandOutput runs the first output filter whose pattern matches the command
+func (m *Manager) filterCommandOutput(command, output string) (string, string, bool) {
+	for _, filter := range m.Config.OutputFilters {
+		if filter.Pattern == "" || filter.Command == "" {
+			continue
+		}
+		match, err := regexp.MatchString(filter.Pattern, command)
+		if err != nil {
+			logger.Error("Invalid output filter pattern '%s': %v", filter.Pattern, err)
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		filtered, err := runOutputFilter(filter.Command, output)
+		if err != nil {
+			logger.Error("Output filter '%s' failed: %v", filter.Command, err)
+			return "", "", false
+		}
+		return filter.Command, strings.TrimRight(filtered, "\n"), true
+	}
+	return "", "", false
+}
+
+// filteredOutput is the result of filterCommandOutput for one exec history entry
+type filteredOutput struct {
+	entry    CommandExecHistory
+	filter   string
+	filtered string
+	ok       bool
+}
+
+// filterHistoryOutput filters the output of an exec history entry, running the filter command
+// only once per entry, not again for every message of the turn
+func (m *Manager) filterHistoryOutput(entry CommandExecHistory) (string, string, bool) {
+	if cached := m.lastFilteredOutput; cached != nil && cached.entry == entry {
+		return cached.filter, cached.filtered, cached.ok
+	}
+	filter, filtered, ok := m.filterCommandOutput(entry.Command, entry.Output)
+	m.lastFilteredOutput = &filteredOutput{entry: entry, filter: filter, filtered: filtered, ok: ok}
+	return filter, filtered, ok
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// outputSummarizer condenses the output of a well-known tool into a compact summary for the AI
+type outputSummarizer struct {
+	Name      string
+	Match     func(command string) bool
+	Summarize func(output string) string
+}
+
+// outputSummarizers is the registry consulted for the last exec pane command, first match wins
+var outputSummarizers = []outputSummarizer{
+	{Name: "go build", Match: commandPrefixMatcher("go build", "go vet", "go install", "go run"), Summarize: summarizeGoBuild},
+	{Name: "git status", Match: commandPrefixMatcher("git status"), Summarize: summarizeGitStatus},
+	{Name: "npm", Match: commandPrefixMatcher("npm ", "npx "), Summarize: summarizeNpm},
+}
+
+// commandPrefixMatcher matches commands starting with any of the given prefixes
+func commandPrefixMatcher(prefixes ...string) func(string) bool {
+	return func(command string) bool {
+		command = strings.TrimSpace(command)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(command, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// summarizeOutput returns a structured summary of the command output when a summarizer matches
+func summarizeOutput(command, output string) (string, string, bool) {
+	for _, s := range outputSummarizers {
+		if !s.Match(command) {
+			continue
+		}
+		summary := s.Summarize(output)
+		if summary == "" {
+			return "", "", false
+		}
+		return s.Name, summary, true
+	}
+	return "", "", false
+}
+
+var goBuildErrorRegex = regexp.MustCompile(`^(\S+\.go):(\d+)(?::\d+)?: (.+)$`)
+
+// summarizeGoBuild lists compiler errors as file:line: message entries
+func summarizeGoBuild(output string) string {
+	var entries []string
+	for _, line := range strings.Split(output, "\n") {
+		match := goBuildErrorRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s: %s", match[1], match[2], match[3]))
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d error(s)\n%s", len(entries), strings.Join(entries, "\n"))
+}
+
+// summarizeGitStatus groups changed files by state
+func summarizeGitStatus(output string) string {
+	states := []string{"modified", "new file", "deleted", "renamed", "untracked"}
+	files := make(map[string][]string)
+	untracked := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Untracked files:") {
+			untracked = true
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "(") {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			untracked = false
+			continue
+		}
+		if untracked {
+			files["untracked"] = append(files["untracked"], trimmed)
+			continue
+		}
+		for _, state := range states {
+			if rest, ok := strings.CutPrefix(trimmed, state+":"); ok {
+				files[state] = append(files[state], strings.TrimSpace(rest))
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, state := range states {
+		if len(files[state]) > 0 {
+			b.WriteString(fmt.Sprintf("%s (%d): %s\n", state, len(files[state]), strings.Join(files[state], ", ")))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// summarizeNpm keeps npm error lines and the final install/audit result lines
+func summarizeNpm(output string) string {
+	var entries []string
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "npm ERR!"), strings.HasPrefix(trimmed, "npm error"):
+			entries = append(entries, trimmed)
+		case strings.HasPrefix(trimmed, "added "), strings.HasPrefix(trimmed, "removed "),
+			strings.HasPrefix(trimmed, "up to date"), strings.HasPrefix(trimmed, "found "):
+			entries = append(entries, trimmed)
+		}
+	}
+	return strings.Join(entries, "\n")
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeOutput_GoBuild(t *testing.T) {
+	output := `# github.com/example/app
+./main.go:12:5: undefined: foo
+internal/server.go:48:2: declared and not used: err
+note: module requires Go 1.22`
+
+	tool, summary, ok := summarizeOutput("go build ./...", output)
+
+	assert.True(t, ok)
+	assert.Equal(t, "go build", tool)
+	assert.Equal(t, "2 error(s)\n./main.go:12: undefined: foo\ninternal/server.go:48: declared and not used: err", summary)
+}
+
+func TestSummarizeOutput_GitStatus(t *testing.T) {
+	output := "On branch main\nChanges not staged for commit:\n  (use \"git add <file>...\" to update what will be committed)\n\tmodified:   main.go\n\tdeleted:    old.go\n\nUntracked files:\n  (use \"git add <file>...\" to include in what will be committed)\n\tnotes.txt\n"
+
+	_, summary, ok := summarizeOutput("git status", output)
+
+	assert.True(t, ok)
+	assert.Equal(t, "modified (1): main.go\ndeleted (1): old.go\nuntracked (1): notes.txt", summary)
+}
+
+func TestSummarizeOutput_Registry(t *testing.T) {
+	original := outputSummarizers
+	defer func() { outputSummarizers = original }()
+
+	outputSummarizers = []outputSummarizer{{
+		Name:      "make",
+		Match:     commandPrefixMatcher("make"),
+		Summarize: func(output string) string { return "summarized" },
+	}}
+
+	tool, summary, ok := summarizeOutput("make test", "lots of output")
+	assert.True(t, ok)
+	assert.Equal(t, "make", tool)
+	assert.Equal(t, "summarized", summary)
+
+	_, _, ok = summarizeOutput("ls -la", "total 0")
+	assert.False(t, ok, "Unknown tools are left as raw output only")
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// PaneCapture is exec pane content bookmarked with /capture
+type PaneCapture struct {
+	Label   string
+	Content string
+}
+
+// capturePane stores the current exec pane content under the label, replacing an earlier capture with the same label
+func (m *Manager) capturePane(label string) {
+	if m.ExecPane == nil || m.ExecPane.Id == "" {
+		m.Println("No exec pane to capture")
+		return
+	}
+	content, err := system.TmuxCapturePane(m.ExecPane.Id, m.GetMaxCaptureLines())
+	if err != nil {
+		m.Println(fmt.Sprintf("Failed to capture exec pane: %v", err))
+		return
+	}
+	if m.captures == nil {
+		m.captures = make(map[string]string)
+	}
+	m.captures[label] = m.redactSecrets(system.SanitizeUTF8(strings.TrimRight(content, "\n")))
+	m.Println(fmt.Sprintf("Captured the exec pane as '%s'", label))
+}
+
+// showCapture prints a labeled capture and, with feed, sends it with the next message
+func (m *Manager) showCapture(label string, feed bool) {
+	content, ok := m.captures[label]
+	if !ok {
+		m.Println(fmt.Sprintf("No capture named '%s', see /capture for the list", label))
+		return
+	}
+	fmt.Println(content)
+	if feed {
+		m.pendingCaptures = append(m.pendingCaptures, PaneCapture{Label: label, Content: content})
+		m.Println(fmt.Sprintf("Capture '%s' will be sent with the next message", label))
+	}
+}
+
+// listCaptures prints the labels of the stored captures
+func (m *Manager) listCaptures() {
+	if len(m.captures) == 0 {
+		m.Println("No captures yet, use /capture <label> to store the exec pane content")
+		return
+	}
+	labels := make([]string, 0, len(m.captures))
+	for label := range m.captures {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Printf("  %s (%d lines)\n", label, strings.Count(m.captures[label], "\n")+1)
+	}
+}
+
+// captureContext formats captures for the next message to the AI
+func captureContext(captures []PaneCapture) string {
+	var b strings.Builder
+	for _, capture := range captures {
+		fmt.Fprintf(&b, "<pane_capture label=%q>\n%s\n</pane_capture>\n", capture.Label, capture.Content)
+	}
+	return strings.TrimSpace(b.String())
+}
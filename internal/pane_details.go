@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"html"
 	"strings"
 
 	"github.com/alvinunreal/tmuxai/config"
@@ -10,13 +11,19 @@ import (
 
 func (m *Manager) GetTmuxPanes() ([]system.TmuxPaneDetails, error) {
 	currentPaneId, _ := system.TmuxCurrentPaneId()
-	windowTarget, _ := system.TmuxCurrentWindowTarget()
+	windowTarget := m.WindowTarget
+	if windowTarget == "" {
+		windowTarget, _ = system.TmuxCurrentWindowTarget()
+	}
 	currentPanes, _ := system.TmuxPanesDetails(windowTarget)
 
 	for i := range currentPanes {
 		currentPanes[i].IsTmuxAiPane = currentPanes[i].Id == currentPaneId
 		currentPanes[i].IsTmuxAiExecPane = currentPanes[i].Id == m.ExecPane.Id
 		currentPanes[i].IsPrepared = currentPanes[i].Id == m.ExecPane.Id
+		if currentPanes[i].IsTmuxAiExecPane {
+			currentPanes[i].Distro = m.ExecPane.Distro
+		}
 		if currentPanes[i].IsSubShell {
 			currentPanes[i].OS = "OS Unknown (subshell)"
 		} else {
@@ -40,8 +47,12 @@ func (m *Manager) getTmuxPanesInXmlFn(config *config.Config) string {
 		}
 	}
 	for _, pane := range filteredPanes {
-		if !pane.IsTmuxAiPane {
-			pane.Refresh(m.GetMaxCaptureLines())
+		fullCapture := pane.IsTmuxAiExecPane && m.FullCaptureNext
+		if fullCapture {
+			m.refreshPane(&pane, system.FullHistory)
+			m.FullCaptureNext = false
+		} else if !pane.IsTmuxAiPane {
+			m.refreshPane(&pane, m.GetMaxCaptureLines())
 		}
 		if pane.IsTmuxAiExecPane {
 			m.ExecPane = &pane
@@ -56,6 +67,9 @@ func (m *Manager) getTmuxPanesInXmlFn(config *config.Config) string {
 
 		currentTmuxWindow.WriteString(fmt.Sprintf("<%s>\n", title))
 		currentTmuxWindow.WriteString(fmt.Sprintf(" - Id: %s\n", pane.Id))
+		if pane.Title != "" {
+			currentTmuxWindow.WriteString(fmt.Sprintf(" - Title: %s\n", pane.Title))
+		}
 		currentTmuxWindow.WriteString(fmt.Sprintf(" - CurrentPid: %d\n", pane.CurrentPid))
 		currentTmuxWindow.WriteString(fmt.Sprintf(" - CurrentCommand: %s\n", pane.CurrentCommand))
 		currentTmuxWindow.WriteString(fmt.Sprintf(" - CurrentCommandArgs: %s\n", pane.CurrentCommandArgs))
@@ -72,10 +86,26 @@ func (m *Manager) getTmuxPanesInXmlFn(config *config.Config) string {
 
 		if !pane.IsTmuxAiPane && pane.Content != "" {
 			currentTmuxWindow.WriteString("<pane_content>\n")
-			currentTmuxWindow.WriteString(pane.Content)
+			content := pane.Content
+			if pane.IsTmuxAiExecPane {
+				content = m.suppressQuietOutput(content)
+			}
+			currentTmuxWindow.WriteString(m.paneContentWithNotices(pane, m.redactSecrets(content), fullCapture))
 			currentTmuxWindow.WriteString("\n</pane_content>\n")
 		}
 
+		if pane.IsTmuxAiExecPane && len(m.ExecHistory) > 0 && !m.isQuietOutput(m.ExecHistory[len(m.ExecHistory)-1]) {
+			last := m.ExecHistory[len(m.ExecHistory)-1]
+			if m.Config.SummarizeToolOutput {
+				if tool, summary, ok := summarizeOutput(last.Command, last.Output); ok {
+					currentTmuxWindow.WriteString(fmt.Sprintf("<last_command_summary tool=\"%s\">\n%s\n</last_command_summary>\n", tool, summary))
+				}
+			}
+			if filter, filtered, ok := m.filterHistoryOutput(last); ok {
+				currentTmuxWindow.WriteString(fmt.Sprintf("<last_command_filtered_output filter=\"%s\">\n%s\n</last_command_filtered_output>\n", html.EscapeString(filter), filtered))
+			}
+		}
+
 		currentTmuxWindow.WriteString(fmt.Sprintf("</%s>\n\n", title))
 	}
 
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// listPanes handles /pane list: the panes TmuxAI can use, marking the exec pane and its own pane
+func (m *Manager) listPanes() {
+	panes, _ := m.GetTmuxPanes()
+	if len(panes) == 0 {
+		m.Println("No panes found")
+		return
+	}
+	for _, pane := range panes {
+		marker := "  "
+		switch {
+		case pane.IsTmuxAiExecPane:
+			marker = "* "
+		case pane.IsTmuxAiPane:
+			marker = "- "
+		}
+		line := fmt.Sprintf("%s%s  %s", marker, pane.Id, pane.CurrentCommand)
+		if pane.Title != "" {
+			line += fmt.Sprintf("  %q", pane.Title)
+		}
+		switch {
+		case pane.IsTmuxAiExecPane:
+			line += "  (exec pane)"
+		case pane.IsTmuxAiPane:
+			line += "  (TmuxAI)"
+		}
+		m.Println(line)
+	}
+}
+
+// selectPane handles /pane select: switch the exec pane, detecting whether the new one is prepared
+func (m *Manager) selectPane(target string) {
+	if err := m.useExecPane(target); err != nil {
+		m.Println(err.Error())
+		return
+	}
+	m.Println(fmt.Sprintf("Exec pane set to %s, mode: %s", m.ExecPane.Id, m.currentMode()))
+}
+
+// useExecPane switches the exec pane to target, forgetting the state of the previous one
+// and detecting whether the new one is prepared
+func (m *Manager) useExecPane(target string) error {
+	if err := m.SetExecPane(target); err != nil {
+		return err
+	}
+	m.preparedShell = ""
+	m.execPaneMark = ""
+	if m.reusePreparedExecPane() {
+		m.parseExecPaneCommandHistory()
+	} else {
+		m.ExecHistory = nil
+	}
+	return nil
+}
+
+// paneCommand handles /pane [list|select <id>]
+func (m *Manager) paneCommand(args []string) {
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		m.listPanes()
+		return
+	}
+	if strings.EqualFold(args[0], "select") && len(args) == 2 {
+		m.selectPane(args[1])
+		return
+	}
+	m.Println("Usage: /pane [list|select <id>]")
+}
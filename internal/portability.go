@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+// portabilityHint flags a GNU-only command form that fails on macOS
+type portabilityHint struct {
+	Pattern *regexp.Regexp
+	Hint    string
+}
+
+// macOSPortabilityHints are the known GNU/BSD divergences the AI is warned about on macOS
+var macOSPortabilityHints = []portabilityHint{
+	// -i without a suffix argument ('', "", .bak or a quoted suffix), which BSD sed requires
+	{regexp.MustCompile(`\bsed\s+(-[a-zA-Z]*\s+)*-i(\s*$|\s+([^'".\s]|'[^'.]|"[^".]))`), "BSD sed needs a backup suffix argument for -i, use `sed -i '' ...` or `perl -pi -e`"},
+	{regexp.MustCompile(`\bdate\s+.*(-d\b|--date)`), "BSD date has no -d/--date, use `date -j -f <format> <date>` or `date -v` for offsets"},
+	{regexp.MustCompile(`\breadlink\s+-f\b`), "readlink -f is missing on older macOS, use `realpath` instead"},
+	{regexp.MustCompile(`\bgrep\s+(-[a-zA-Z]*P|--perl-regexp)`), "BSD grep has no -P, use `grep -E` or `perl -ne`"},
+	{regexp.MustCompile(`\bxargs\s+(-[a-zA-Z]*\s+)*-r\b`), "BSD xargs has no -r, it already skips running on empty input"},
+	{regexp.MustCompile(`\bstat\s+(-c|--format)\b`), "BSD stat uses -f for formats, e.g. `stat -f %z` for the size"},
+}
+
+// execPaneIsMacOS reports whether the exec pane runs on macOS, going by its OS and detected distro
+func (m *Manager) execPaneIsMacOS() bool {
+	os := strings.ToLower(m.ExecPane.OS)
+	if strings.Contains(os, "darwin") || strings.Contains(os, "macos") || strings.Contains(os, "mac os") {
+		return true
+	}
+	return strings.HasPrefix(m.ExecPane.Distro, "Darwin")
+}
+
+// portabilityHintsFor returns the hints matching GNU-only forms used by the commands on macOS
+func (m *Manager) portabilityHintsFor(commands []string) []string {
+	if !m.Config.PortabilityHints || !m.execPaneIsMacOS() {
+		return nil
+	}
+	var hints []string
+	for _, command := range commands {
+		for _, h := range macOSPortabilityHints {
+			if h.Pattern.MatchString(command) {
+				hints = append(hints, h.Hint)
+			}
+		}
+	}
+	return hints
+}
+
+// portabilityContext formats hints for the next message to the AI
+func portabilityContext(hints []string) string {
+	if len(hints) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<portability_hints>\nThe exec pane runs macOS with BSD tools, your last commands used GNU-only forms:\n")
+	for _, hint := range hints {
+		b.WriteString("- " + hint + "\n")
+	}
+	b.WriteString("</portability_hints>")
+	return b.String()
+}
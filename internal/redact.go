@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+const redactedPlaceholder = "[REDACTED]"
+
+// secretPatterns match common secrets in pane content and prompts, the replacement keeps what names them
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// AWS access key ids
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), redactedPlaceholder},
+	// OpenAI style, GitHub and Slack tokens
+	{regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{30,}|xox[abposr]-[A-Za-z0-9-]{10,})`), redactedPlaceholder},
+	// Authorization: Bearer <token>
+	{regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/=-]{8,}`), "${1}" + redactedPlaceholder},
+	// api_key=..., export SECRET=..., "password": "..." and similar assignments
+	{regexp.MustCompile(`(?i)([A-Za-z0-9_]*(?:api[_-]?key|secret|token|passw(?:or)?d)[A-Za-z0-9_]*["']?\s*[=:]\s*["']?)[^\s"'<>]+`), "${1}" + redactedPlaceholder},
+}
+
+// highEntropyRegex matches long runs of token characters, redacted when they look random
+var highEntropyRegex = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+
+// redactSecretPatterns masks secret looking values in s, for anything written to debug logs
+func redactSecretPatterns(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return highEntropyRegex.ReplaceAllStringFunc(s, func(candidate string) string {
+		if looksRandom(candidate) {
+			return redactedPlaceholder
+		}
+		return candidate
+	})
+}
+
+// looksRandom reports whether s mixes letter cases and digits with a high per-character entropy.
+// Hex strings like commit hashes top out at 4 bits and are kept, as are paths and identifiers.
+func looksRandom(s string) bool {
+	if strings.Contains(s, "/") && !strings.ContainsAny(s, "+=") {
+		return false
+	}
+	hasUpper := strings.IndexFunc(s, func(r rune) bool { return r >= 'A' && r <= 'Z' }) != -1
+	hasLower := strings.IndexFunc(s, func(r rune) bool { return r >= 'a' && r <= 'z' }) != -1
+	hasDigit := strings.IndexFunc(s, func(r rune) bool { return r >= '0' && r <= '9' }) != -1
+	if !hasUpper || !hasLower || !hasDigit {
+		return false
+	}
+
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy > 4.2
+}
+
+// redactLog masks secrets in AI responses written to the log, unless redact_secrets is off
+func (c *AiClient) redactLog(s string) string {
+	if c.config == nil || !c.config.RedactSecrets {
+		return s
+	}
+	return redactSecretPatterns(s)
+}
+
+// redactForDebug masks secrets in text written to debug logs, unless redact_secrets is off
+func (m *Manager) redactForDebug(s string) string {
+	s = m.redactSecrets(s)
+	if m.Config == nil || !m.Config.RedactSecrets {
+		return s
+	}
+	return redactSecretPatterns(s)
+}
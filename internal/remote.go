@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// remote API timeouts, a prompt can run several commands before its response is written
+const (
+	remoteReadTimeout  = 10 * time.Second
+	remoteWriteTimeout = 10 * time.Minute
+)
+
+// remoteRunRequest is the body of POST /run
+type remoteRunRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// remoteRunResult is the outcome of a prompt run through the remote API
+type remoteRunResult struct {
+	Accomplished bool     `json:"accomplished"`
+	Status       string   `json:"status"`
+	Message      string   `json:"message"`
+	Commands     []string `json:"commands"`
+}
+
+// remoteListenAddr binds to localhost when listen_addr has no host, e.g. ":8765"
+func remoteListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// StartRemoteServer serves the remote control API on listen_addr in the background, if configured
+func (m *Manager) StartRemoteServer() error {
+	if m.Config.ListenAddr == "" {
+		return nil
+	}
+	if m.Config.RemoteToken == "" {
+		return fmt.Errorf("remote_token is required when listen_addr is set")
+	}
+
+	addr := remoteListenAddr(m.Config.ListenAddr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("Remote control API listening on %s", addr)
+	server := &http.Server{
+		Handler:           m.remoteHandler(),
+		ReadHeaderTimeout: remoteReadTimeout,
+		ReadTimeout:       remoteReadTimeout,
+		WriteTimeout:      remoteWriteTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Error("Remote control API stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// remoteHandler runs prompts posted to /run the same way as typed input, one at a time, and
+// responds with the outcome as JSON. Subcommands are refused, they could turn off confirmations or exit.
+func (m *Manager) remoteHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.Config.RemoteToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req remoteRunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Prompt) == "" {
+			http.Error(w, "expected a JSON body with a prompt", http.StatusBadRequest)
+			return
+		}
+		if m.IsMessageSubcommand(req.Prompt) {
+			logger.Info("Refused remote subcommand: %s", req.Prompt)
+			http.Error(w, "subcommands aren't accepted over the remote API", http.StatusForbidden)
+			return
+		}
+
+		logger.Info("Remote prompt: %s", req.Prompt)
+		m.inputMu.Lock()
+		m.remoteRequest = true
+		accomplished := NewCLIInterface(m).processInput(req.Prompt)
+		m.remoteRequest = false
+		result := remoteRunResult{Accomplished: accomplished, Status: m.Status, Commands: []string{}}
+		result.Commands = append(result.Commands, m.turnCommands...)
+		if parsed, err := m.parseAIResponse(m.LastRawResponse); err == nil {
+			result.Message = parsed.Message
+		}
+		m.inputMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	})
+	return mux
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alvinunreal/tmuxai/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteHandler_Run(t *testing.T) {
+	server := newMockAiServer(t,
+		"Checking disk usage. <ExecCommand>df -h</ExecCommand>",
+		"The disk is 42% full. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.RemoteToken = "s3cret"
+	handler := manager.remoteHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"prompt": "how full is the disk?"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result remoteRunResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, remoteRunResult{
+		Accomplished: true,
+		Status:       "",
+		Message:      "The disk is 42% full.",
+		Commands:     []string{"df -h"},
+	}, result)
+
+	// a wrong token never reaches the AI
+	req = httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"prompt": "rm -rf /"}`))
+	req.Header.Set("Authorization", "Bearer guess")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Len(t, server.Requests, 2)
+}
+
+// Test: Remote prompts never open a confirmation, commands that need one are declined
+func TestRemoteHandler_DeclinesUnconfirmed(t *testing.T) {
+	server := newMockAiServer(t,
+		"Cleaning up. <ExecCommand>rm -rf build</ExecCommand>",
+		"Couldn't clean up. <RequestAccomplished>1</RequestAccomplished>",
+	)
+	manager := newTestManager(t, server)
+	manager.Config.RemoteToken = "s3cret"
+	manager.confirmedToExec = manager.confirmedToExecFn
+	sent := false
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		sent = true
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"prompt": "clean the build"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	output := captureOutput(t, func() {
+		manager.remoteHandler().ServeHTTP(rec, req)
+	})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, output, "Declined, remote requests only run whitelisted commands: rm -rf build")
+	assert.False(t, sent, "The declined command never reaches the pane")
+	assert.False(t, manager.remoteRequest)
+}
+
+// Test: Subcommands over the remote API are refused, they could turn off the confirmations
+func TestRemoteHandler_RefusesSubcommands(t *testing.T) {
+	server := newMockAiServer(t)
+	manager := newTestManager(t, server)
+	manager.Config.RemoteToken = "s3cret"
+
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"prompt": "/config set exec_confirm false"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	manager.remoteHandler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.True(t, manager.GetExecConfirm(), "exec_confirm is unchanged")
+	assert.Empty(t, manager.SessionOverrides)
+}
+
+func TestRemoteListenAddr(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:8765", remoteListenAddr(":8765"))
+	assert.Equal(t, "0.0.0.0:8765", remoteListenAddr("0.0.0.0:8765"))
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alvinunreal/tmuxai/system"
+	"github.com/stretchr/testify/assert"
+)
+
+// replayTranscript is a recorded task: the exec pane content and the model's answer for each turn,
+// and the actions tmuxai is expected to take. Transcripts live in testdata/replay.
+type replayTranscript struct {
+	Request        string       `json:"request"`
+	Prepared       bool         `json:"prepared"`
+	InitialCapture string       `json:"initial_capture"`
+	Steps          []replayStep `json:"steps"`
+	Expect         struct {
+		Accomplished bool     `json:"accomplished"`
+		Sent         []string `json:"sent"`
+		// Context[i] must appear in the pane context sent with the i-th request
+		Context []string `json:"context"`
+	} `json:"expect"`
+}
+
+// replayStep is one model response and what the exec pane shows after its actions ran
+type replayStep struct {
+	Response string `json:"response"`
+	Capture  string `json:"capture"`
+}
+
+// replayHarness runs a transcript through ProcessUserMessage, feeding the recorded responses to the
+// AI client and the recorded captures to TmuxCapturePane, and records what was sent to the exec pane
+type replayHarness struct {
+	transcript replayTranscript
+	server     *mockAiServer
+	manager    *Manager
+	Sent       []string
+}
+
+func newReplayHarness(t *testing.T, transcript replayTranscript) *replayHarness {
+	t.Helper()
+	var responses []string
+	for _, step := range transcript.Steps {
+		responses = append(responses, step.Response)
+	}
+
+	h := &replayHarness{transcript: transcript, server: newMockAiServer(t, responses...)}
+	h.manager = newTestManager(t, h.server)
+	h.manager.getTmuxPanesInXml = h.manager.getTmuxPanesInXmlFn
+	h.manager.ExecPane.IsPrepared = transcript.Prepared
+	h.manager.ExecPane.Shell = "bash"
+
+	system.TmuxSendCommandToPane = func(paneId string, command string, enter bool) error {
+		h.Sent = append(h.Sent, command)
+		return nil
+	}
+	system.TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
+		return h.capture(), nil
+	}
+	return h
+}
+
+// capture returns the pane as it looks after the actions of the last served response
+func (h *replayHarness) capture() string {
+	h.server.mu.Lock()
+	served := len(h.server.Requests)
+	h.server.mu.Unlock()
+
+	for i := served - 1; i >= 0; i-- {
+		if i < len(h.transcript.Steps) && h.transcript.Steps[i].Capture != "" {
+			return h.transcript.Steps[i].Capture
+		}
+	}
+	return h.transcript.InitialCapture
+}
+
+// Run processes the transcript's request and returns whether it was accomplished
+func (h *replayHarness) Run() bool {
+	return h.manager.ProcessUserMessage(context.Background(), h.transcript.Request)
+}
+
+func TestReplayTranscripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "replay", "*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			assert.NoError(t, err)
+			var transcript replayTranscript
+			assert.NoError(t, json.Unmarshal(data, &transcript))
+
+			h := newReplayHarness(t, transcript)
+			accomplished := h.Run()
+
+			assert.Equal(t, transcript.Expect.Accomplished, accomplished)
+			assert.Equal(t, transcript.Expect.Sent, h.Sent)
+			assert.Len(t, h.server.Requests, len(transcript.Steps), "Every recorded response should be used")
+			for i, want := range transcript.Expect.Context {
+				if i < len(h.server.Requests) {
+					sent := h.server.Requests[i].Messages
+					assert.Contains(t, sent[len(sent)-1].Content, want, "context of request %d", i+1)
+				}
+			}
+		})
+	}
+}
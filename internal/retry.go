@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// maxRetryDelay caps the wait between retries, whatever Retry-After asks for
+const maxRetryDelay = time.Minute
+
+// do sends the request, retrying transient failures (HTTP 429, 500, 502, 503 and network timeouts) up to
+// http.max_retries times, waiting as long as Retry-After asks or backing off exponentially from http.retry_base_delay_ms
+func (c *AiClient) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := c.client.Do(attemptReq)
+		reason := retryReason(ctx, resp, err)
+		if reason == "" || attempt >= c.config.HTTP.MaxRetries {
+			return resp, err
+		}
+
+		delay := c.retryDelay(resp, attempt)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		logger.Info("AI request failed (%s), retrying in %s (%d/%d)", reason, delay, attempt+1, c.config.HTTP.MaxRetries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryReason describes why a request is worth retrying, empty when it isn't
+func retryReason(ctx context.Context, resp *http.Response, err error) string {
+	if ctx.Err() != nil {
+		return ""
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "timeout"
+		}
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return resp.Status
+	}
+	return ""
+}
+
+// retryDelay returns the wait before the next attempt, honoring Retry-After in seconds or as an HTTP date
+func (c *AiClient) retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+				return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+			}
+			if at, err := http.ParseTime(after); err == nil {
+				return min(max(time.Until(at), 0), maxRetryDelay)
+			}
+		}
+	}
+	// retry_base_delay_ms 0 retries right away
+	if c.config.HTTP.RetryBaseDelayMs <= 0 {
+		return 0
+	}
+	delay := time.Duration(c.config.HTTP.RetryBaseDelayMs) * time.Millisecond << attempt
+	if delay <= 0 || delay > maxRetryDelay {
+		// doubled past the cap, or overflowed
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// defaultSessionName is used by /save and /load without a name
+const defaultSessionName = "default"
+
+// sessionNameRegex keeps saved session names to plain file names
+var sessionNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// savedSession is the session state /save writes and /load restores
+type savedSession struct {
+	SavedAt          time.Time            `json:"saved_at"`
+	Messages         []ChatMessage        `json:"messages"`
+	ExecHistory      []CommandExecHistory `json:"exec_history"`
+	ExecPaneId       string               `json:"exec_pane_id"`
+	ExecPaneShell    string               `json:"exec_pane_shell,omitempty"`
+	ExecPanePrepared bool                 `json:"exec_pane_prepared"`
+	WatchMode        bool                 `json:"watch_mode"`
+	WatchGoal        string               `json:"watch_goal,omitempty"`
+	SessionOverrides map[string]any       `json:"session_overrides"`
+}
+
+// sessionsDir returns the directory saved sessions are kept in
+func sessionsDir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "sessions"), nil
+}
+
+// sessionPath returns the file of the saved session with the given name
+func sessionPath(name string) (string, error) {
+	if !sessionNameRegex.MatchString(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid session name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// savedSessionNames lists the saved sessions for completion
+func savedSessionNames() []string {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// saveSession writes the chat history, exec history, watch mode and session overrides under name
+func (m *Manager) saveSession(name string) {
+	path, err := sessionPath(name)
+	if err != nil {
+		m.Println(err.Error())
+		return
+	}
+
+	state := savedSession{
+		SavedAt:          time.Now(),
+		Messages:         m.Messages,
+		ExecHistory:      m.ExecHistory,
+		ExecPaneId:       m.ExecPane.Id,
+		ExecPaneShell:    m.ExecPane.Shell,
+		ExecPanePrepared: m.ExecPane.IsPrepared,
+		WatchMode:        m.WatchMode,
+		WatchGoal:        m.watchGoal,
+		SessionOverrides: m.SessionOverrides,
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		m.Println(fmt.Sprintf("Failed to save session: %v", err))
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		m.Println(fmt.Sprintf("Failed to save session: %v", err))
+		return
+	}
+	// the history holds pane content, keep it private
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		m.Println(fmt.Sprintf("Failed to save session: %v", err))
+		return
+	}
+	logger.Info("Saved session %s to %s", name, path)
+	m.Println(fmt.Sprintf("Saved session '%s' (%d messages)", name, len(m.Messages)))
+}
+
+// loadSession restores a session saved with /save, keeping the current exec pane if the saved one is gone
+func (m *Manager) loadSession(name string) {
+	path, err := sessionPath(name)
+	if err != nil {
+		m.Println(err.Error())
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.Println(fmt.Sprintf("No saved session '%s'", name))
+		} else {
+			m.Println(fmt.Sprintf("Failed to load session: %v", err))
+		}
+		return
+	}
+	var state savedSession
+	if err := json.Unmarshal(data, &state); err != nil {
+		m.Println(fmt.Sprintf("Failed to load session '%s': %v", name, err))
+		return
+	}
+
+	m.Messages = state.Messages
+	if m.Messages == nil {
+		m.Messages = []ChatMessage{}
+	}
+	m.ExecHistory = state.ExecHistory
+	m.SessionOverrides = make(map[string]any, len(state.SessionOverrides))
+	for key, value := range state.SessionOverrides {
+		m.SessionOverrides[key] = restoredOverride(key, value)
+	}
+
+	if state.ExecPaneId != "" && state.ExecPaneId != m.ExecPane.Id {
+		panes, err := system.TmuxPanesDetails(state.ExecPaneId)
+		if err != nil || len(panes) == 0 {
+			m.Println(fmt.Sprintf("Warning: the saved exec pane %s no longer exists, keeping exec pane %s", state.ExecPaneId, m.ExecPane.Id))
+		} else {
+			pane := panes[0]
+			pane.Shell = state.ExecPaneShell
+			pane.IsPrepared = state.ExecPanePrepared
+			m.ExecPane = &pane
+		}
+	}
+
+	m.Println(fmt.Sprintf("Loaded session '%s' saved %s (%d messages)", name, state.SavedAt.Format("2006-01-02 15:04"), len(m.Messages)))
+	if state.WatchMode && state.WatchGoal != "" {
+		m.Println("Resuming watch mode: " + state.WatchGoal)
+		m.watch(state.WatchGoal)
+	}
+}
+
+// restoredOverride undoes JSON turning every number into a float64, the getters expect ints for whole numbers
+func restoredOverride(key string, value any) any {
+	number, ok := value.(float64)
+	if !ok {
+		return value
+	}
+	if typed := config.TryInferType(key, strconv.FormatFloat(number, 'f', -1, 64)); typed != nil {
+		if _, isString := typed.(string); !isString {
+			return typed
+		}
+	}
+	if number == math.Trunc(number) {
+		return int(number)
+	}
+	return number
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// shellSwitchRegex matches commands that replace or nest the exec pane's shell, e.g. "exec zsh" or "/bin/fish -l"
+var shellSwitchRegex = regexp.MustCompile(`^\s*(?:exec\s+)?(?:\S*/)?(?:bash|zsh|fish|sh|dash|ksh|nu|pwsh)(?:\s+(?:-l|--login|-i))*\s*$`)
+
+// isShellSwitch reports whether the command starts another shell in the exec pane
+func isShellSwitch(command string) bool {
+	return shellSwitchRegex.MatchString(command)
+}
+
+// execShellSwitch sends a shell switching command without waiting for a prepared prompt, which the new shell won't show
+func (m *Manager) execShellSwitch(command string) {
+	_ = system.TmuxSendCommandToPane(m.ExecPane.Id, command, true)
+	// give the new shell time to start
+	time.Sleep(1 * time.Second)
+	m.clearActivity()
+
+	currentCommand := m.ExecPane.CurrentCommand
+	if panes, err := system.TmuxPanesDetails(m.ExecPane.Id); err == nil && len(panes) > 0 {
+		currentCommand = panes[0].CurrentCommand
+	}
+	wasPrepared := m.ExecPane.IsPrepared
+	m.refreshPane(m.ExecPane, m.GetMaxCaptureLines())
+	m.ExecPane.IsPrepared = wasPrepared
+	m.checkShellChange(currentCommand, !m.atExecPrompt())
+}
+
+// checkShellChange updates the exec pane after its foreground shell changed, e.g. after "exec zsh", and
+// suggests /prepare again when the prepared prompt is gone. It reports whether anything changed.
+func (m *Manager) checkShellChange(currentCommand string, promptLost bool) bool {
+	shellChanged := system.IsShellCommand(currentCommand) && m.ExecPane.Shell != "" && currentCommand != m.ExecPane.Shell
+	if !shellChanged && !(m.ExecPane.IsPrepared && promptLost) {
+		return false
+	}
+
+	if shellChanged {
+		logger.Info("Exec pane shell changed from %s to %s", m.ExecPane.Shell, currentCommand)
+		m.Println(fmt.Sprintf("Exec pane shell changed from %s to %s", m.ExecPane.Shell, currentCommand))
+		m.ExecPane.Shell = currentCommand
+		m.ExecPane.CurrentCommand = currentCommand
+	}
+	if m.ExecPane.IsPrepared {
+		m.ExecPane.IsPrepared = false
+		m.Println(fmt.Sprintf("The prepared prompt is gone, run /prepare %s to track command output again", m.ExecPane.Shell))
+	}
+	return true
+}
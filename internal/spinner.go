@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/briandowns/spinner"
+)
+
+// defaultSpinnerCharSet is the charset used when spinner_style is unset or unknown
+const defaultSpinnerCharSet = 26
+
+// namedSpinnerStyles maps readable spinner_style names to spinner charset indexes
+var namedSpinnerStyles = map[string]int{
+	"dots":    26,
+	"ascii":   9,
+	"braille": 14,
+}
+
+// progressSpinner is the subset of spinner.Spinner used while waiting on the AI
+type progressSpinner interface {
+	Start()
+	Stop()
+}
+
+// noopSpinner is used when spinner_style is "none"
+type noopSpinner struct{}
+
+func (noopSpinner) Start() {}
+func (noopSpinner) Stop()  {}
+
+// newSpinner builds the terminal spinner, overridable in tests
+var newSpinner = func(charSet []string, interval time.Duration) progressSpinner {
+	return spinner.New(charSet, interval)
+}
+
+// newProgressSpinner returns a progress spinner built from the spinner_style and spinner_interval_ms config
+func (m *Manager) newProgressSpinner() progressSpinner {
+	style := strings.ToLower(strings.TrimSpace(m.Config.SpinnerStyle))
+	// the status line takes over the spinner's line
+	if style == "none" || m.statusLineEnabled() {
+		return noopSpinner{}
+	}
+
+	charSet := spinner.CharSets[defaultSpinnerCharSet]
+	if index, ok := namedSpinnerStyles[style]; ok {
+		charSet = spinner.CharSets[index]
+	} else if index, err := strconv.Atoi(style); err == nil {
+		if set, ok := spinner.CharSets[index]; ok {
+			charSet = set
+		} else {
+			logger.Warn("Unknown spinner charset %d, using default", index)
+		}
+	} else if style != "" {
+		logger.Warn("Unknown spinner style '%s', using default", style)
+	}
+
+	interval := time.Duration(m.Config.SpinnerIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	return newSpinner(charSet, interval)
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/briandowns/spinner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProgressSpinner(t *testing.T) {
+	original := newSpinner
+	defer func() { newSpinner = original }()
+
+	var gotCharSet []string
+	var gotInterval time.Duration
+	calls := 0
+	newSpinner = func(charSet []string, interval time.Duration) progressSpinner {
+		calls++
+		gotCharSet = charSet
+		gotInterval = interval
+		return noopSpinner{}
+	}
+
+	m := &Manager{Config: &config.Config{SpinnerStyle: "11", SpinnerIntervalMs: 250}}
+	m.newProgressSpinner()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, spinner.CharSets[11], gotCharSet)
+	assert.Equal(t, 250*time.Millisecond, gotInterval)
+
+	m.Config.SpinnerStyle = "ascii"
+	m.newProgressSpinner()
+	assert.Equal(t, spinner.CharSets[9], gotCharSet)
+
+	m.Config.SpinnerStyle = "none"
+	s := m.newProgressSpinner()
+	assert.Equal(t, 2, calls, "none should not construct a spinner")
+	assert.IsType(t, noopSpinner{}, s)
+}
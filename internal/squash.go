@@ -7,23 +7,51 @@ import (
 	"time"
 
 	"github.com/alvinunreal/tmuxai/logger"
-	"github.com/alvinunreal/tmuxai/system"
-	"github.com/briandowns/spinner"
 )
 
-// needSquash checks if the current context size is approaching the max limit
-func (m *Manager) needSquash() bool {
+// contextTokens estimates the token count of the chat history
+func (m *Manager) contextTokens() int {
 	totalTokens := 0
 	for _, msg := range m.Messages {
-		totalTokens += system.EstimateTokenCount(msg.Content)
+		totalTokens += m.countTokens(msg.Content)
+	}
+	return totalTokens
+}
+
+// needSquash checks if the current context size is approaching the max limit
+func (m *Manager) needSquash() bool {
+	return m.contextTokens() > m.GetSquashThreshold()
+}
+
+// printSquashStatus shows how close the chat history is to being squashed
+func (m *Manager) printSquashStatus() {
+	tokens := m.contextTokens()
+	threshold := m.GetSquashThreshold()
+	m.Println(fmt.Sprintf("Context: ~%d tokens, squash threshold: %d tokens", tokens, threshold))
+	if tokens > threshold {
+		m.Println("Over the threshold, the history will be squashed before the next message")
+	} else {
+		m.Println(fmt.Sprintf("%d tokens until squashing", threshold-tokens))
 	}
+}
 
-	threshold := int(float64(m.GetMaxContextSize()) * 0.8)
-	return totalTokens > threshold
+// squashNow squashes the history on request and reports how much it shrank
+func (m *Manager) squashNow() {
+	before := m.contextTokens()
+	collapsed, err := m.squashHistory()
+	if err != nil {
+		m.Println("Squashing failed: " + err.Error())
+		return
+	}
+	if collapsed == 0 {
+		m.Println("Nothing to squash")
+		return
+	}
+	m.Println(fmt.Sprintf("Squashed %d messages into a summary, context ~%d -> ~%d tokens", collapsed, before, m.contextTokens()))
 }
 
-// manageContext handles context reduction by summarizing chat history
-func (m *Manager) squashHistory() {
+// squashHistory reduces the context by summarizing chat history, returning how many messages were summarized
+func (m *Manager) squashHistory() (int, error) {
 	var systemMessage ChatMessage
 	var assistantBaseMessage ChatMessage
 	var hasSystemMessage bool
@@ -55,13 +83,25 @@ func (m *Manager) squashHistory() {
 
 	// Only summarize if we have messages beyond the base ones
 	if startIdx < len(m.Messages)-1 {
-		messagesToSummarize = m.Messages[startIdx : len(m.Messages)-1] // Exclude the most recent user message
+		// Pinned messages are kept as they are instead of being summarized
+		var pinned []ChatMessage
+		for _, msg := range m.Messages[startIdx : len(m.Messages)-1] { // Exclude the most recent user message
+			if msg.Pinned {
+				pinned = append(pinned, msg)
+			} else {
+				messagesToSummarize = append(messagesToSummarize, msg)
+			}
+		}
+		if len(messagesToSummarize) == 0 {
+			logger.Debug("Only pinned messages left, nothing to summarize")
+			return 0, nil
+		}
 
 		// Request summarization from AI
 		summarizedHistory, err := m.summarizeChatHistory(messagesToSummarize)
 		if err != nil {
 			logger.Error("Failed to summarize chat history: %v", err)
-			return
+			return 0, err
 		}
 
 		// Build new context with summarized history
@@ -77,6 +117,8 @@ func (m *Manager) squashHistory() {
 			newHistory = append(newHistory, assistantBaseMessage)
 		}
 
+		newHistory = append(newHistory, pinned...)
+
 		// Add the summary as a system message
 		newHistory = append(newHistory, ChatMessage{
 			Content:   summarizedHistory,
@@ -86,12 +128,14 @@ func (m *Manager) squashHistory() {
 
 		m.Messages = newHistory
 		logger.Debug("Context successfully reduced through summarization")
+		return len(messagesToSummarize), nil
 	}
+	return 0, nil
 }
 
 // summarizeChatHistory asks the AI to summarize the chat history
 func (m *Manager) summarizeChatHistory(messages []ChatMessage) (string, error) {
-	s := spinner.New(spinner.CharSets[26], 100*time.Millisecond)
+	s := m.newProgressSpinner()
 	s.Start()
 
 	// Convert messages to a readable format for summarization
@@ -124,13 +168,13 @@ func (m *Manager) summarizeChatHistory(messages []ChatMessage) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	summary, err := m.AiClient.GetResponseFromChatMessages(ctx, summarizationMessage, m.GetOpenRouterModel())
+	summary, err := m.AiClient.GetResponseFromChatMessages(ctx, summarizationMessage, m.GetSummaryModel())
 	if err != nil {
 		return "", err
 	}
 
 	if m.Config.Debug {
-		debugChatMessages(summarizationMessage, summary)
+		m.debugChatMessages(summarizationMessage, summary)
 	}
 
 	s.Stop()
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Activity phases shown by the status line
+const (
+	activityQuerying  = "querying model"
+	activityExecuting = "executing"
+	activityWaiting   = "waiting for pane"
+	activityCountdown = "countdown"
+)
+
+// statusLineMaxDetail bounds the command shown after "executing:"
+const statusLineMaxDetail = 60
+
+// stdoutIsTerminal reports whether stdout is a TTY, overridable in tests
+var stdoutIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// formatStatusLine renders the status line text for an activity phase
+func formatStatusLine(phase string, detail string) string {
+	switch phase {
+	case activityExecuting:
+		if len(detail) > statusLineMaxDetail {
+			detail = detail[:statusLineMaxDetail-3] + "..."
+		}
+		return fmt.Sprintf("[tmuxai] executing: %s", detail)
+	case activityCountdown:
+		return fmt.Sprintf("[tmuxai] countdown: %s", detail)
+	default:
+		return "[tmuxai] " + phase
+	}
+}
+
+// statusLineEnabled reports whether the status line is configured and stdout can render it
+func (m *Manager) statusLineEnabled() bool {
+	return m.Config.StatusLine && stdoutIsTerminal()
+}
+
+// showActivity redraws the status line in place with the current activity
+func (m *Manager) showActivity(phase string, detail string) {
+	if !m.statusLineEnabled() || m.Status == "" {
+		return
+	}
+	fmt.Printf("\r\033[K%s", formatStatusLine(phase, detail))
+}
+
+// clearActivity removes the status line before regular output is printed
+func (m *Manager) clearActivity() {
+	if !m.statusLineEnabled() {
+		return
+	}
+	fmt.Print("\r\033[K")
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatStatusLine(t *testing.T) {
+	assert.Equal(t, "[tmuxai] querying model", formatStatusLine(activityQuerying, ""))
+	assert.Equal(t, "[tmuxai] executing: make test", formatStatusLine(activityExecuting, "make test"))
+	assert.Equal(t, "[tmuxai] waiting for pane", formatStatusLine(activityWaiting, ""))
+	assert.Equal(t, "[tmuxai] countdown: 3", formatStatusLine(activityCountdown, "3"))
+
+	long := formatStatusLine(activityExecuting, strings.Repeat("x", 100))
+	assert.Equal(t, "[tmuxai] executing: "+strings.Repeat("x", statusLineMaxDetail-3)+"...", long)
+}
+
+func TestShowActivity_NonTerminal(t *testing.T) {
+	original := stdoutIsTerminal
+	defer func() { stdoutIsTerminal = original }()
+	stdoutIsTerminal = func() bool { return false }
+
+	m := &Manager{Config: &config.Config{StatusLine: true}, Status: "running"}
+	output := captureOutput(t, func() {
+		m.showActivity(activityQuerying, "")
+	})
+	assert.Empty(t, output, "Status line is not drawn when stdout is not a terminal")
+
+	stdoutIsTerminal = func() bool { return true }
+	output = captureOutput(t, func() {
+		m.showActivity(activityQuerying, "")
+	})
+	assert.Equal(t, "\r\033[K[tmuxai] querying model", output)
+}
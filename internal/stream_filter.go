@@ -0,0 +1,102 @@
+package internal
+
+import "strings"
+
+// responseTagNames are the action and status tags parseAIResponse strips from the message
+var responseTagNames = []string{"TmuxSendKeys", "ExecCommand", "PasteMultilineContent", "RequestAccomplished", "ExecPaneSeemsBusy", "WaitingForUserResponse", "NoComment"}
+
+// streamFilter prints a streamed response as it arrives, holding back the tags and, with fences,
+// the fenced action blocks, which aren't part of the message
+type streamFilter struct {
+	print  func(string)
+	fences bool
+	// text not printed yet, and the end of the block being skipped, "" outside one
+	pending string
+	closing string
+}
+
+// Write adds a streamed delta, printing what is known to be outside a tag or action block
+func (f *streamFilter) Write(delta string) {
+	f.pending += delta
+	for f.pending != "" {
+		if f.closing != "" {
+			i := strings.Index(f.pending, f.closing)
+			if i == -1 {
+				// keep what may be the start of the closing
+				if keep := len(f.closing) - 1; len(f.pending) > keep {
+					f.pending = f.pending[len(f.pending)-keep:]
+				}
+				return
+			}
+			f.pending = f.pending[i+len(f.closing):]
+			f.closing = ""
+			continue
+		}
+
+		i := strings.IndexAny(f.pending, "<`")
+		if i == -1 {
+			f.print(f.pending)
+			f.pending = ""
+			return
+		}
+		f.print(f.pending[:i])
+		f.pending = f.pending[i:]
+
+		closing, decided := f.blockStart(f.pending)
+		if !decided {
+			// wait for the rest of the opening
+			return
+		}
+		if closing == "" {
+			f.print(f.pending[:1])
+		} else {
+			f.closing = closing
+		}
+		f.pending = f.pending[1:]
+	}
+}
+
+// Flush prints the rest of the response, unless it's inside an unclosed tag
+func (f *streamFilter) Flush() {
+	if f.closing == "" {
+		f.print(f.pending)
+	}
+	f.pending, f.closing = "", ""
+}
+
+// blockStart reports how the tag or action block s starts with ends, "" when s doesn't start one,
+// and whether s is long enough to tell
+func (f *streamFilter) blockStart(s string) (string, bool) {
+	type opening struct {
+		text    string
+		closing string
+		follow  string // characters that may come right after text
+	}
+	var openings []opening
+	for _, name := range responseTagNames {
+		openings = append(openings, opening{"<" + name, "</" + name + ">", " \t\r\n>/"})
+	}
+	if f.fences {
+		for _, kind := range []string{"exec", "sendkeys", "paste"} {
+			openings = append(openings, opening{"```" + kind, "```", " \t\r\n"})
+		}
+	}
+
+	for _, o := range openings {
+		if len(s) <= len(o.text) {
+			if strings.HasPrefix(o.text, s) {
+				return "", false
+			}
+			continue
+		}
+		if !strings.HasPrefix(s, o.text) || !strings.ContainsRune(o.follow, rune(s[len(o.text)])) {
+			continue
+		}
+		if s[len(o.text)] == '/' {
+			// self-closing status tag
+			return "/>", true
+		}
+		return o.closing, true
+	}
+	return "", true
+}
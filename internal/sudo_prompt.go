@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/alvinunreal/tmuxai/system"
+	"golang.org/x/term"
+)
+
+// sudoPromptRegex matches the password prompt sudo prints, e.g. "[sudo] password for alice:"
+var sudoPromptRegex = regexp.MustCompile(`^\[sudo\] password for [^:]+:\s*$`)
+
+// readSecret asks the user for a secret without echoing it, overridable in tests
+var readSecret = func(prompt string) (string, error) {
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return string(secret), err
+}
+
+// isSudoPrompt reports whether a pane line is a sudo password prompt
+func isSudoPrompt(line string) bool {
+	return sudoPromptRegex.MatchString(strings.TrimSpace(line))
+}
+
+// countSudoPrompts counts the password prompts in the output of a command, sudo prints a new one after "Sorry, try again"
+func countSudoPrompts(output string) int {
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if isSudoPrompt(line) {
+			count++
+		}
+	}
+	return count
+}
+
+// answerSudoPrompt asks the user for the password sudo is waiting for and types it into the exec pane.
+// The password is never logged or added to the chat, and is redacted from anything captured afterwards.
+func (m *Manager) answerSudoPrompt(promptLine string) {
+	logger.Info("Sudo password prompt detected in exec pane")
+	if m.remoteRequest {
+		m.Println("Remote requests can't ask for a password, cancelling the sudo prompt")
+		_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-c", false)
+		return
+	}
+	password, err := readSecret(fmt.Sprintf("%s ", strings.TrimSpace(promptLine)))
+	if err != nil || password == "" {
+		// abort sudo rather than leave it waiting for input nobody will type
+		m.Println("No password entered, cancelling the sudo prompt")
+		_ = system.TmuxSendCommandToPane(m.ExecPane.Id, "C-c", false)
+		return
+	}
+
+	m.addSecret(password)
+	if err := system.TmuxSendSecretToPane(m.ExecPane.Id, password); err != nil {
+		logger.Error("Failed to send sudo password: %v", err)
+	}
+}
+
+// addSecret remembers a secret so it can be redacted from captured content
+func (m *Manager) addSecret(secret string) {
+	for _, s := range m.secrets {
+		if s == secret {
+			return
+		}
+	}
+	m.secrets = append(m.secrets, secret)
+}
+
+// redactSecrets replaces every secret the user entered this session with a placeholder.
+// Only whole words are replaced, a short password would otherwise mangle every word containing it.
+func (m *Manager) redactSecrets(s string) string {
+	for _, secret := range m.secrets {
+		s = replaceWord(s, secret, redactedPlaceholder)
+	}
+	return s
+}
+
+// replaceWord replaces the occurrences of word in s that have whitespace or the start or end of s on both sides
+func replaceWord(s string, word string, repl string) string {
+	if word == "" {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, word)
+		if i == -1 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(word)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if (i == 0 || unicode.IsSpace(before)) && (end == len(s) || unicode.IsSpace(after)) {
+			b.WriteString(s[:i] + repl)
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+}
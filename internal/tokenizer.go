@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// tokenizer settings, auto picks one by model family
+const (
+	tokenizerAuto      = "auto"
+	tokenizerBPE       = "bpe"
+	tokenizerHeuristic = "heuristic"
+)
+
+// bpeModelFamilies are the model name prefixes known to use a tiktoken BPE tokenizer
+var bpeModelFamilies = []string{"gpt-", "chatgpt-", "o1", "o3", "o4", "text-embedding-"}
+
+// tokenizerForModel returns the tokenizer matching the model family, the heuristic for models it doesn't know
+func tokenizerForModel(model string) string {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	for _, family := range bpeModelFamilies {
+		if strings.HasPrefix(name, family) {
+			return tokenizerBPE
+		}
+	}
+	return tokenizerHeuristic
+}
+
+// activeTokenizer returns the tokenizer token counts use, from the tokenizer setting and the current model
+func (m *Manager) activeTokenizer() string {
+	switch m.Config.Tokenizer {
+	case tokenizerBPE, tokenizerHeuristic:
+		return m.Config.Tokenizer
+	case "", tokenizerAuto:
+		return tokenizerForModel(m.GetOpenRouterModel())
+	}
+	return tokenizerHeuristic
+}
+
+// countTokens counts the tokens of text with the active tokenizer
+func (m *Manager) countTokens(text string) int {
+	if m.activeTokenizer() == tokenizerBPE {
+		return system.BPETokenCount(text, system.BPEEncodingForModel(m.GetOpenRouterModel()))
+	}
+	return system.EstimateTokenCount(text)
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/fatih/color"
+)
+
+// maxTraceMessage bounds how much of a message a trace line quotes
+const maxTraceMessage = 80
+
+var traceColor = color.New(color.FgHiBlack)
+
+// trace prints a decision of the agent loop while /trace is on for the current turn
+func (m *Manager) trace(format string, args ...any) {
+	if !m.tracing {
+		return
+	}
+	msg := m.redactForDebug(fmt.Sprintf(format, args...))
+	logger.Info("trace: %s", msg)
+	fmt.Println(traceColor.Sprint("[trace] " + msg))
+}
+
+// truncateTrace shortens a message quoted in a trace line
+func truncateTrace(message string) string {
+	runes := []rune(message)
+	if len(runes) <= maxTraceMessage {
+		return message
+	}
+	return string(runes[:maxTraceMessage]) + "…"
+}
+
+// summary lists the parsed tags of a response on one line
+func (ai *AIResponse) summary() string {
+	return fmt.Sprintf("%d exec commands, %d send keys, paste %t, accomplished %t, busy %t, waiting %t, no comment %t, message %d chars",
+		len(ai.ExecCommand), len(ai.SendKeys), ai.PasteMultilineContent != "", ai.RequestAccomplished,
+		ai.ExecPaneSeemsBusy, ai.WaitingForUserResponse, ai.NoComment, len(ai.Message))
+}
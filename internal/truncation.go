@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// truncationNotice tells the AI what was cut from a pane's content, using the truncation_notice template
+func (m *Manager) truncationNotice(details string) string {
+	notice := m.Config.TruncationNotice
+	if notice == "" {
+		return "[output truncated, " + details + "]"
+	}
+	return strings.ReplaceAll(notice, "{details}", details)
+}
+
+// truncateOutput keeps the last max_command_output_bytes of content, starting at a line when possible,
+// and reports the byte count it was cut from
+func (m *Manager) truncateOutput(content string) (string, int, bool) {
+	limit := m.Config.MaxCommandOutputBytes
+	if limit <= 0 || len(content) <= limit {
+		return content, len(content), false
+	}
+	tail := content[len(content)-limit:]
+	if i := strings.IndexByte(tail, '\n'); i != -1 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	// don't start in the middle of a multi-byte character
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return tail, len(content), true
+}
+
+// paneContentWithNotices returns the pane content sent to the AI, prefixed with a notice for each way it was cut:
+// scrollback lines beyond max_capture_lines and bytes beyond max_command_output_bytes
+func (m *Manager) paneContentWithNotices(pane system.TmuxPaneDetails, content string, fullCapture bool) string {
+	var notices []string
+	if maxLines := m.GetMaxCaptureLines(); !fullCapture && maxLines > 0 && pane.HistorySize > maxLines {
+		notices = append(notices, m.truncationNotice(fmt.Sprintf("%d earlier lines of scrollback were not captured", pane.HistorySize-maxLines)))
+	}
+	if truncated, total, ok := m.truncateOutput(content); ok {
+		notices = append(notices, m.truncationNotice(fmt.Sprintf("showing the last %d of %d bytes", len(truncated), total)))
+		content = truncated
+	}
+	if len(notices) == 0 {
+		return content
+	}
+	return strings.Join(notices, "\n") + "\n" + content
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/alvinunreal/tmuxai/config"
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// Usage holds token counts for one or more completions
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	Completions      int
+	Estimated        int     // completions whose provider didn't report usage, counted with estimateUsage
+	Cost             float64 // estimated USD, each completion priced at the model that produced it
+}
+
+// Add accumulates other into u
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Completions += other.Completions
+	u.Estimated += other.Estimated
+	u.Cost += other.Cost
+}
+
+// apiUsage is the usage object of a completion as reported by OpenAI compatible APIs, or by Anthropic
+type apiUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+// normalize converts either provider's counts to a Usage
+func (u apiUsage) normalize() Usage {
+	usage := Usage{
+		PromptTokens:     u.PromptTokens + u.InputTokens,
+		CompletionTokens: u.CompletionTokens + u.OutputTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage
+}
+
+// completionUsage returns the usage the provider reported for the last completion, or an estimate,
+// priced at the model it was requested from
+func (m *Manager) completionUsage(sent []ChatMessage, response string, model string) Usage {
+	usage, reported := m.AiClient.LastUsage()
+	if !reported {
+		usage = m.estimateUsage(sent, response)
+		usage.Estimated = 1
+	}
+	usage.Completions = 1
+	usage.Cost = m.usageCost(usage, model)
+	return usage
+}
+
+// estimateUsage approximates token usage for a completion from the sent messages and the response
+func (m *Manager) estimateUsage(sent []ChatMessage, response string) Usage {
+	usage := Usage{CompletionTokens: m.countTokens(response)}
+	for _, msg := range sent {
+		usage.PromptTokens += m.countTokens(msg.Content)
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// modelPricing returns the configured pricing for model, falling back to the "default" entry
+func (m *Manager) modelPricing(model string) (config.ModelPricing, bool) {
+	if pricing, ok := m.Config.Pricing[model]; ok {
+		return pricing, true
+	}
+	pricing, ok := m.Config.Pricing["default"]
+	return pricing, ok
+}
+
+// usageCost returns the estimated USD cost of usage for the given model based on configured pricing
+func (m *Manager) usageCost(usage Usage, model string) float64 {
+	pricing, ok := m.modelPricing(model)
+	if !ok {
+		return 0
+	}
+	return float64(usage.PromptTokens)/1e6*pricing.Prompt + float64(usage.CompletionTokens)/1e6*pricing.Completion
+}
+
+// SessionCost returns the estimated USD spent so far in this session
+func (m *Manager) SessionCost() float64 {
+	return m.Usage.Cost
+}
+
+// exceedsSessionCost reports whether sending the given messages would push the session over the cost cap.
+// Without pricing for the model the cap can't be enforced, that's reported once per model.
+func (m *Manager) exceedsSessionCost(sending []ChatMessage) bool {
+	maxCost := m.GetMaxSessionCostUSD()
+	if maxCost <= 0 {
+		return false
+	}
+	model := m.GetOpenRouterModel()
+	if _, ok := m.modelPricing(model); !ok {
+		if m.unpricedCapWarned != model {
+			m.unpricedCapWarned = model
+			m.Println(fmt.Sprintf("max_session_cost_usd can't be enforced, there is no pricing for %s. Add pricing.%s or pricing.default to the config.", model, model))
+		}
+		return false
+	}
+	next := m.estimateUsage(sending, "")
+	return m.SessionCost()+m.usageCost(next, model) > maxCost
+}
+
+// printUsage shows the session's token usage and its estimated cost with the configured pricing
+func (m *Manager) printUsage() {
+	formatter := system.NewInfoFormatter()
+	const labelWidth = 18
+	formatLine := func(key string, value any) {
+		fmt.Print(formatter.LabelColor.Sprintf("%-*s", labelWidth, key))
+		fmt.Print("  ")
+		fmt.Println(value)
+	}
+
+	model := m.GetOpenRouterModel()
+	completions := fmt.Sprint(m.Usage.Completions)
+	if m.Usage.Estimated > 0 {
+		completions += fmt.Sprintf(" (%d estimated)", m.Usage.Estimated)
+	}
+
+	fmt.Println(formatter.FormatSection("\nUsage"))
+	formatLine("Model", model)
+	formatLine("Completions", completions)
+	formatLine("Prompt Tokens", m.Usage.PromptTokens)
+	formatLine("Output Tokens", m.Usage.CompletionTokens)
+	formatLine("Total Tokens", m.Usage.TotalTokens)
+	if _, ok := m.modelPricing(model); ok {
+		formatLine("Cost~", fmt.Sprintf("$%.4f", m.SessionCost()))
+	} else {
+		formatLine("Cost~", fmt.Sprintf("unknown, set pricing for %s in the config", model))
+	}
+}
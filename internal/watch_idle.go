@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+	"github.com/alvinunreal/tmuxai/system"
+)
+
+// resetWatchIdle forgets the pane content seen by a previous watch
+func (m *Manager) resetWatchIdle() {
+	m.watchContentHash = ""
+	m.watchUnchangedSince = time.Time{}
+	m.watchPaused = false
+}
+
+// watchedContentHash hashes the content of the watched panes, without the details sent to the AI
+func (m *Manager) watchedContentHash() string {
+	panes, _ := m.GetTmuxPanes()
+	h := sha256.New()
+	for _, pane := range panes {
+		if pane.IsTmuxAiPane {
+			continue
+		}
+		content, _ := system.TmuxCapturePane(pane.Id, m.GetMaxCaptureLines())
+		h.Write([]byte(pane.Id + "\x00" + content + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// watchIdle reports whether watch mode should skip the AI this round, i.e. the panes haven't changed
+// for watch_idle_pause_sec. Any change resumes it.
+func (m *Manager) watchIdle() bool {
+	if m.Config.WatchIdlePauseSec <= 0 {
+		return false
+	}
+
+	hash := m.watchedContentHash()
+	if hash != m.watchContentHash {
+		m.watchContentHash = hash
+		m.watchUnchangedSince = time.Now()
+		if m.watchPaused {
+			m.watchPaused = false
+			m.Println("Pane content changed, resuming watch")
+		}
+		return false
+	}
+
+	idle := time.Duration(m.Config.WatchIdlePauseSec) * time.Second
+	if time.Since(m.watchUnchangedSince) < idle {
+		return false
+	}
+	if !m.watchPaused {
+		m.watchPaused = true
+		logger.Info("Watch paused, panes unchanged for %s", idle)
+		m.Println(fmt.Sprintf("Nothing changed for %s, watch paused until the panes change", idle))
+	}
+	return true
+}
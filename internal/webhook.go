@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alvinunreal/tmuxai/logger"
+)
+
+// Outcomes reported to webhook_url
+const (
+	webhookAccomplished = "accomplished"
+	webhookWaiting      = "waiting_for_input"
+)
+
+// webhookPayload is the JSON body posted to webhook_url
+type webhookPayload struct {
+	Session string `json:"session"`
+	Outcome string `json:"outcome"`
+	Request string `json:"request"`
+	Message string `json:"message"`
+}
+
+// postWebhook posts a JSON body to the webhook, overridable in tests
+var postWebhook = func(url string, body []byte) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyWebhook tells webhook_url that a request finished or needs input
+func (m *Manager) notifyWebhook(outcome string, message string) {
+	if m.Config.WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(webhookPayload{
+		Session: m.PaneId,
+		Outcome: outcome,
+		Request: compactMessage(m.turnRequest),
+		Message: message,
+	})
+	if err != nil {
+		logger.Error("Failed to encode webhook payload: %v", err)
+		return
+	}
+	if err := postWebhook(m.Config.WebhookURL, body); err != nil {
+		logger.Error("Failed to notify webhook: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcessInfo describes a single entry from the process table
+type ProcessInfo struct {
+	Pid     int
+	PPid    int
+	Command string
+}
+
+// ListProcesses returns the raw process table as pid, ppid and command columns
+var ListProcesses = func() (string, error) {
+	output, err := exec.Command("ps", "-A", "-o", "pid=,ppid=,command=").Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// ParseProcessList parses `ps -o pid=,ppid=,command=` output, skipping malformed lines
+func ParseProcessList(output string) []ProcessInfo {
+	var processes []ProcessInfo
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		processes = append(processes, ProcessInfo{
+			Pid:     pid,
+			PPid:    ppid,
+			Command: strings.Join(fields[2:], " "),
+		})
+	}
+	return processes
+}
+
+// DescendantProcesses returns every process below rootPid in the process tree, ordered by pid
+func DescendantProcesses(processes []ProcessInfo, rootPid int) []ProcessInfo {
+	children := make(map[int][]ProcessInfo)
+	for _, p := range processes {
+		children[p.PPid] = append(children[p.PPid], p)
+	}
+
+	var result []ProcessInfo
+	queue := []int{rootPid}
+	seen := map[int]bool{rootPid: true}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if seen[child.Pid] {
+				continue
+			}
+			seen[child.Pid] = true
+			result = append(result, child)
+			queue = append(queue, child.Pid)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Pid < result[j].Pid })
+	return result
+}
+
+// PaneJobs lists the processes running under the given pane's shell
+func PaneJobs(panePid int) ([]ProcessInfo, error) {
+	output, err := ListProcesses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	return DescendantProcesses(ParseProcessList(output), panePid), nil
+}
+
+// KillProcess sends SIGTERM to the given pid
+var KillProcess = func(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,45 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcessList(t *testing.T) {
+	output := `    1     0 /sbin/init
+  100     1 tmux new-session
+  200   100 -bash
+  210   200 npm run dev
+  211   210 node server.js --port 3000
+  300   100 -zsh
+  310   300 vim notes.md
+garbage line
+  abc   200 broken
+`
+
+	processes := ParseProcessList(output)
+	assert.Len(t, processes, 7)
+	assert.Equal(t, ProcessInfo{Pid: 211, PPid: 210, Command: "node server.js --port 3000"}, processes[4])
+
+	jobs := DescendantProcesses(processes, 200)
+	assert.Equal(t, []ProcessInfo{
+		{Pid: 210, PPid: 200, Command: "npm run dev"},
+		{Pid: 211, PPid: 210, Command: "node server.js --port 3000"},
+	}, jobs)
+
+	assert.Empty(t, DescendantProcesses(processes, 310))
+}
+
+func TestPaneJobs(t *testing.T) {
+	original := ListProcesses
+	defer func() { ListProcesses = original }()
+
+	ListProcesses = func() (string, error) {
+		return "  200     1 -bash\n  210   200 sleep 100\n  300     1 -zsh\n", nil
+	}
+
+	jobs, err := PaneJobs(200)
+	assert.NoError(t, err)
+	assert.Equal(t, []ProcessInfo{{Pid: 210, PPid: 200, Command: "sleep 100"}}, jobs)
+}
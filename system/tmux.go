@@ -2,6 +2,7 @@ package system
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,6 +12,33 @@ import (
 	"github.com/alvinunreal/tmuxai/logger"
 )
 
+var (
+	// ErrTmuxNotInstalled is returned by TmuxPreflight when the tmux binary can't be found
+	ErrTmuxNotInstalled = errors.New("tmux is not installed")
+	// ErrNoTmuxSession is returned by TmuxPreflight when not running inside a reachable tmux session
+	ErrNoTmuxSession = errors.New("no tmux session")
+)
+
+var tmuxLookPath = exec.LookPath
+
+// TmuxPreflight verifies tmux is installed and that we are running inside a reachable tmux session
+func TmuxPreflight() error {
+	if _, err := tmuxLookPath("tmux"); err != nil {
+		return fmt.Errorf("%w: %v", ErrTmuxNotInstalled, err)
+	}
+
+	paneId, err := TmuxCurrentPaneId()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoTmuxSession, err)
+	}
+
+	if _, err := TmuxPanesDetails(paneId); err != nil {
+		return fmt.Errorf("%w: pane %s is not reachable: %v", ErrNoTmuxSession, paneId, err)
+	}
+
+	return nil
+}
+
 // TmuxCreateNewPane creates a new horizontal split pane in the specified window and returns its ID
 func TmuxCreateNewPane(target string) (string, error) {
 	cmd := exec.Command("tmux", "split-window", "-d", "-h", "-t", target, "-P", "-F", "#{pane_id}")
@@ -30,7 +58,7 @@ func TmuxCreateNewPane(target string) (string, error) {
 
 // TmuxPanesDetails gets details for all panes in a target window
 var TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
-	cmd := exec.Command("tmux", "list-panes", "-t", target, "-F", "#{pane_id},#{pane_active},#{pane_pid},#{pane_current_command},#{history_size},#{history_limit}")
+	cmd := exec.Command("tmux", "list-panes", "-t", target, "-F", "#{pane_id},#{pane_active},#{pane_pid},#{pane_current_command},#{history_size},#{history_limit},#{pane_title}")
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -55,8 +83,9 @@ var TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
 			continue
 		}
 
-		parts := strings.SplitN(line, ",", 6)
-		if len(parts) < 5 {
+		// the title is last, it may contain commas
+		parts := strings.SplitN(line, ",", 7)
+		if len(parts) < 6 {
 			logger.Error("Invalid pane details format for line: %s", line)
 			continue
 		}
@@ -74,6 +103,10 @@ var TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
 		historyLimit, _ := strconv.Atoi(parts[5])
 		currentCommandArgs := GetProcessArgs(pid)
 		isSubShell := IsSubShell(parts[3])
+		title := ""
+		if len(parts) == 7 {
+			title = parts[6]
+		}
 
 		paneDetail := TmuxPaneDetails{
 			Id:                 id,
@@ -84,6 +117,7 @@ var TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
 			HistorySize:        historySize,
 			HistoryLimit:       historyLimit,
 			IsSubShell:         isSubShell,
+			Title:              title,
 		}
 
 		paneDetails = append(paneDetails, paneDetail)
@@ -92,9 +126,16 @@ var TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
 	return paneDetails, nil
 }
 
+// FullHistory passed as maxLines to TmuxCapturePane captures the whole scrollback
+const FullHistory = -1
+
 // TmuxCapturePane gets the content of a specific pane by ID
 var TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
-	cmd := exec.Command("tmux", "capture-pane", "-p", "-t", paneId, "-S", fmt.Sprintf("-%d", maxLines))
+	args := []string{"capture-pane", "-p", "-t", paneId, "-S", fmt.Sprintf("-%d", maxLines)}
+	if maxLines == FullHistory {
+		args = []string{"capture-pane", "-p", "-t", paneId, "-S", "-", "-E", "-"}
+	}
+	cmd := exec.Command("tmux", args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -105,10 +146,50 @@ var TmuxCapturePane = func(paneId string, maxLines int) (string, error) {
 		return "", err
 	}
 
-	content := strings.TrimSpace(stdout.String())
+	content := strings.TrimSpace(SanitizeUTF8(stdout.String()))
 	return content, nil
 }
 
+// TmuxPaneAlternateOn reports whether a full-screen program (vim, less, top) switched the pane to the alternate screen
+var TmuxPaneAlternateOn = func(paneId string) (bool, error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", paneId, "#{alternate_on}")
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to get alternate screen state for %s: %w", paneId, err)
+	}
+	return strings.TrimSpace(string(output)) == "1", nil
+}
+
+// TmuxPaneCurrentPath returns the working directory of the pane's foreground process
+var TmuxPaneCurrentPath = func(paneId string) (string, error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", paneId, "#{pane_current_path}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current path of %s: %w", paneId, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// TmuxResolvePane resolves a pane id (%3) or target (session:window.pane) to the pane id and its window target
+var TmuxResolvePane = func(target string) (string, string, error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_id},#{session_id}:#{window_index}")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", "", fmt.Errorf("%s", msg)
+		}
+		return "", "", err
+	}
+
+	paneId, window, found := strings.Cut(strings.TrimSpace(stdout.String()), ",")
+	if !found || paneId == "" {
+		return "", "", fmt.Errorf("no pane found for target %s", target)
+	}
+	return paneId, window, nil
+}
+
 // Return current tmux window target with session id and window id
 func TmuxCurrentWindowTarget() (string, error) {
 	paneId, err := TmuxCurrentPaneId()
@@ -170,7 +251,8 @@ func TmuxAttachSession(paneId string) error {
 	return nil
 }
 
-func TmuxClearPane(paneId string) error {
+// TmuxClearPane clears the pane's scrollback history
+var TmuxClearPane = func(paneId string) error {
 	paneDetails, err := TmuxPanesDetails(paneId)
 	if err != nil {
 		logger.Error("Failed to get pane details for %s: %v", paneId, err)
@@ -138,3 +138,24 @@ func getSpecialKeys() map[string]bool {
 
 	return specialKeys
 }
+
+// secretBufferName is the tmux paste buffer a secret passes through, deleted right after pasting
+const secretBufferName = "tmuxai-secret"
+
+// TmuxSendSecretToPane types text literally followed by Enter, without logging it or interpreting key names.
+// The secret goes to tmux on stdin through a paste buffer, arguments would show it to anyone running ps.
+var TmuxSendSecretToPane = func(paneId string, secret string) error {
+	load := exec.Command("tmux", "load-buffer", "-b", secretBufferName, "-")
+	load.Stdin = strings.NewReader(secret)
+	if err := load.Run(); err != nil {
+		return fmt.Errorf("failed to send secret to pane: %w", err)
+	}
+	if err := exec.Command("tmux", "paste-buffer", "-d", "-b", secretBufferName, "-t", paneId).Run(); err != nil {
+		_ = exec.Command("tmux", "delete-buffer", "-b", secretBufferName).Run()
+		return fmt.Errorf("failed to send secret to pane: %w", err)
+	}
+	if err := exec.Command("tmux", "send-keys", "-t", paneId, "Enter").Run(); err != nil {
+		return fmt.Errorf("failed to send Enter key to pane: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTmuxPreflight(t *testing.T) {
+	originalLookPath := tmuxLookPath
+	originalCurrentPaneId := TmuxCurrentPaneId
+	originalPanesDetails := TmuxPanesDetails
+	defer func() {
+		tmuxLookPath = originalLookPath
+		TmuxCurrentPaneId = originalCurrentPaneId
+		TmuxPanesDetails = originalPanesDetails
+	}()
+
+	tmuxLookPath = func(file string) (string, error) {
+		return "/usr/bin/tmux", nil
+	}
+	TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
+		return []TmuxPaneDetails{{Id: target}}, nil
+	}
+
+	// Not inside a tmux session
+	TmuxCurrentPaneId = func() (string, error) {
+		return "", fmt.Errorf("TMUX_PANE environment variable not set")
+	}
+	err := TmuxPreflight()
+	assert.True(t, errors.Is(err, ErrNoTmuxSession), "Should report no tmux session")
+
+	// tmux binary missing takes precedence
+	tmuxLookPath = func(file string) (string, error) {
+		return "", fmt.Errorf("executable file not found in $PATH")
+	}
+	err = TmuxPreflight()
+	assert.True(t, errors.Is(err, ErrTmuxNotInstalled), "Should report tmux not installed")
+
+	// Inside a reachable session
+	tmuxLookPath = func(file string) (string, error) {
+		return "/usr/bin/tmux", nil
+	}
+	TmuxCurrentPaneId = func() (string, error) {
+		return "%1", nil
+	}
+	assert.NoError(t, TmuxPreflight())
+
+	// Stale TMUX_PANE pointing at a dead server
+	TmuxPanesDetails = func(target string) ([]TmuxPaneDetails, error) {
+		return nil, fmt.Errorf("no server running")
+	}
+	err = TmuxPreflight()
+	assert.True(t, errors.Is(err, ErrNoTmuxSession), "Should report no tmux session when the pane is unreachable")
+}
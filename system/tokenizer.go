@@ -0,0 +1,67 @@
+package system
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tiktoken encodings of OpenAI models
+const (
+	EncodingCl100k = "cl100k_base"
+	EncodingO200k  = "o200k_base"
+)
+
+// o200kModelFamilies are the OpenAI model name prefixes using o200k_base, older ones use cl100k_base
+var o200kModelFamilies = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"}
+
+var (
+	bpeEncodingsMu sync.Mutex
+	bpeEncodings   = map[string]*tiktoken.Tiktoken{}
+)
+
+func init() {
+	// the encodings are embedded, so counting never downloads them
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// BPEEncodingForModel returns the tiktoken encoding of an OpenAI model, cl100k_base for models it doesn't know
+func BPEEncodingForModel(model string) string {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	for _, family := range o200kModelFamilies {
+		if strings.HasPrefix(name, family) {
+			return EncodingO200k
+		}
+	}
+	return EncodingCl100k
+}
+
+// BPETokenCount counts the tokens of text with a tiktoken encoding (cl100k_base or o200k_base).
+// Falls back to EstimateTokenCount if the encoding can't be loaded.
+func BPETokenCount(text string, encoding string) int {
+	enc, err := bpeEncoding(encoding)
+	if err != nil {
+		return EstimateTokenCount(text)
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// bpeEncoding loads an encoding once, building its merge table takes a while
+func bpeEncoding(name string) (*tiktoken.Tiktoken, error) {
+	bpeEncodingsMu.Lock()
+	defer bpeEncodingsMu.Unlock()
+	if enc, ok := bpeEncodings[name]; ok {
+		return enc, nil
+	}
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	bpeEncodings[name] = enc
+	return enc, nil
+}
@@ -10,9 +10,11 @@ type TmuxPaneDetails struct {
 	CurrentPid         int
 	CurrentCommand     string
 	CurrentCommandArgs string
+	Title              string
 	Content            string
 	Shell              string
 	OS                 string
+	Distro             string // detected in the pane itself at prepare time, e.g. over ssh
 	LastLine           string
 	IsActive           int
 	IsTmuxAiPane       bool
@@ -87,6 +89,9 @@ func (p *TmuxPaneDetails) FormatInfo(f *InfoFormatter) string {
 	// Add shell and OS info on separate lines
 	formatLine("Shell", p.Shell)
 	formatLine("OS", p.OS)
+	if p.Distro != "" {
+		formatLine("Distro", p.Distro)
+	}
 
 	// Add status flags each on their own line
 	formatLine("TmuxAI", f.FormatBool(p.IsTmuxAiPane))
@@ -99,7 +104,7 @@ func (p *TmuxPaneDetails) FormatInfo(f *InfoFormatter) string {
 
 func (p *TmuxPaneDetails) Refresh(maxLines int) {
 	content, _ := TmuxCapturePane(p.Id, maxLines)
-	p.Content = content
+	p.Content = SanitizeUTF8(content)
 	p.LastLine = strings.TrimSpace(strings.Split(p.Content, "\n")[len(strings.Split(p.Content, "\n"))-1])
 	p.IsPrepared = strings.HasSuffix(p.LastLine, "»")
 	if IsShellCommand(p.CurrentCommand) {
@@ -6,11 +6,13 @@ import (
 	"os"
 	"os/exec"
 	"reflect"
+	"regexp"
 	"runtime"
 	"slices"
 	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
@@ -104,10 +106,19 @@ var HighlightCode = func(language string, code string) (string, error) {
 	return buf.String(), nil
 }
 
+// SanitizeUTF8 drops invalid UTF-8 sequences, such as a multi-byte character
+// split at the tmux capture boundary, so content is safe to parse and send
+func SanitizeUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "")
+}
+
 // IsShellCommand checks if the given command is a shell
 func IsShellCommand(command string) bool {
 	shellCommands := []string{
-		"bash", "zsh", "fish", "sh", "dash", "ksh", "csh", "tcsh",
+		"bash", "zsh", "fish", "sh", "dash", "ksh", "csh", "tcsh", "nu", "nushell", "pwsh",
 	}
 	return slices.Contains(shellCommands, command)
 }
@@ -124,16 +135,7 @@ func GetOSDetails() string {
 		// Try reading /etc/os-release
 		content, err := os.ReadFile("/etc/os-release")
 		if err == nil {
-			lines := strings.Split(string(content), "\n")
-			info := make(map[string]string)
-			for _, line := range lines {
-				parts := strings.SplitN(line, "=", 2)
-				if len(parts) == 2 {
-					key := parts[0]
-					val := strings.Trim(parts[1], `"`)
-					info[key] = val
-				}
-			}
+			info := parseOSRelease(string(content))
 			// Format without key names
 			osName := info["NAME"]
 			osVersion := info["VERSION"]
@@ -165,6 +167,45 @@ func GetOSDetails() string {
 	return runtime.GOOS + " - " + runtime.GOARCH
 }
 
+var (
+	osReleaseLineRegex = regexp.MustCompile(`^([A-Z_]+)=(.*)$`)
+	unameLineRegex     = regexp.MustCompile(`^(Linux|Darwin|FreeBSD|OpenBSD|NetBSD) \S+`)
+)
+
+// parseOSRelease reads the KEY=value pairs of an os-release file, ignoring any other lines
+func parseOSRelease(content string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		if match := osReleaseLineRegex.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			info[match[1]] = strings.Trim(match[2], `"'`)
+		}
+	}
+	return info
+}
+
+// ParseDistro extracts the distribution from captured os-release or uname output,
+// e.g. "Ubuntu 22.04.4 LTS (ubuntu)" or "Darwin 23.1.0". Returns "" when neither is found.
+func ParseDistro(content string) string {
+	info := parseOSRelease(content)
+	name := info["PRETTY_NAME"]
+	if name == "" && info["NAME"] != "" {
+		name = strings.TrimSpace(info["NAME"] + " " + info["VERSION_ID"])
+	}
+	if name != "" {
+		if id := info["ID"]; id != "" {
+			name += " (" + id + ")"
+		}
+		return name
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); unameLineRegex.MatchString(line) {
+			return line
+		}
+	}
+	return ""
+}
+
 // structToMap flattens a struct to a map[string]interface{} recursively
 func StructToMap(s interface{}, prefix string) map[string]interface{} {
 	result := make(map[string]interface{})
@@ -0,0 +1,50 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDistro(t *testing.T) {
+	osRelease := `user@host:~[10:00][0]» export PS1='\u@\h:\w[\A][$?]» '; cat /etc/os-release 2>/dev/null || uname -sr
+PRETTY_NAME="Ubuntu 22.04.4 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+ID=ubuntu
+ID_LIKE=debian
+user@host:~[10:00][0]»`
+	assert.Equal(t, "Ubuntu 22.04.4 LTS (ubuntu)", ParseDistro(osRelease))
+
+	noPretty := "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.19.1"
+	assert.Equal(t, "Alpine Linux 3.19.1 (alpine)", ParseDistro(noPretty))
+
+	uname := "mac:~[10:00][0]» cat /etc/os-release 2>/dev/null || uname -sr\nDarwin 23.1.0\nmac:~[10:00][0]»"
+	assert.Equal(t, "Darwin 23.1.0", ParseDistro(uname))
+
+	assert.Equal(t, "", ParseDistro("bash: command not found"))
+}
+
+func TestIsShellCommand(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish", "nu", "nushell", "pwsh"} {
+		assert.True(t, IsShellCommand(shell), shell)
+	}
+	assert.False(t, IsShellCommand("vim"))
+}
+
+func TestBPETokenCount(t *testing.T) {
+	code := "func main() {\n\tfmt.Println(\"hi\")\n}"
+	assert.Equal(t, 10, BPETokenCount(code, EncodingCl100k))
+	assert.NotEqual(t, EstimateTokenCount(code), BPETokenCount(code, EncodingCl100k))
+
+	assert.Equal(t, 9, BPETokenCount("Hello world, how are you doing today?", EncodingCl100k))
+	assert.Equal(t, 4, BPETokenCount("こんにちは世界", EncodingCl100k))
+	assert.Equal(t, 2, BPETokenCount("こんにちは世界", EncodingO200k), "o200k_base merges more non-English text")
+	assert.Equal(t, 0, BPETokenCount("", EncodingO200k))
+}
+
+func TestBPEEncodingForModel(t *testing.T) {
+	assert.Equal(t, EncodingO200k, BPEEncodingForModel("openai/gpt-4o-mini"))
+	assert.Equal(t, EncodingO200k, BPEEncodingForModel("o3"))
+	assert.Equal(t, EncodingCl100k, BPEEncodingForModel("gpt-4-turbo"))
+}